@@ -1,11 +1,9 @@
 package sspanel
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -18,6 +16,8 @@ import (
 	"github.com/go-resty/resty/v2"
 
 	"Xray-P/api"
+	xraylog "Xray-P/common/log"
+	"Xray-P/common/rulelist"
 )
 
 var (
@@ -41,13 +41,23 @@ type APIClient struct {
 	LocalRuleList       []api.DetectRule
 	LastReportOnline    map[int]int
 	access              sync.Mutex
+	ruleAccess          sync.Mutex
+	ruleWatcher         *rulelist.Watcher
+	localRuleChanged    bool
+	panelRuleList       []api.DetectRule
 	version             string
 	eTags               map[string]string
+	logger              *log.Entry
 }
 
 // New create api instance
 func New(apiConfig *api.Config) *APIClient {
 	client := resty.New()
+	logger := log.WithFields(log.Fields{
+		xraylog.FieldPanel:    apiConfig.APIHost,
+		xraylog.FieldNodeType: apiConfig.NodeType,
+		xraylog.FieldNodeID:   apiConfig.NodeID,
+	})
 
 	client.SetRetryCount(3)
 	if apiConfig.Timeout > 0 {
@@ -60,19 +70,24 @@ func New(apiConfig *api.Config) *APIClient {
 		if errors.As(err, &v) {
 			// v.Response contains the last response from the server
 			// v.Err contains the original error
-			log.Print(v.Err)
+			logger.WithError(v.Err).WithField(xraylog.FieldEndpoint, req.URL).Print("Panel request failed")
 		}
 	})
+	client.OnAfterResponse(func(_ *resty.Client, res *resty.Response) error {
+		logger.WithFields(log.Fields{
+			xraylog.FieldEndpoint: res.Request.URL,
+			xraylog.FieldDuration: res.Time().String(),
+			"status":              res.StatusCode(),
+		}).Debug("Panel request done")
+		return nil
+	})
 
 	client.SetBaseURL(apiConfig.APIHost)
 	// Create Key for each requests
 	client.SetQueryParam("key", apiConfig.Key)
 	// Add support for muKey
 	client.SetQueryParam("muKey", apiConfig.Key)
-	// Read local rule list
-	localRuleList := readLocalRuleList(apiConfig.RuleListPath)
-
-	return &APIClient{
+	apiClient := &APIClient{
 		client:              client,
 		NodeID:              apiConfig.NodeID,
 		Key:                 apiConfig.Key,
@@ -82,49 +97,52 @@ func New(apiConfig *api.Config) *APIClient {
 		VlessFlow:           apiConfig.VlessFlow,
 		SpeedLimit:          apiConfig.SpeedLimit,
 		DeviceLimit:         apiConfig.DeviceLimit,
-		LocalRuleList:       localRuleList,
+		LocalRuleList:       make([]api.DetectRule, 0),
 		DisableCustomConfig: apiConfig.DisableCustomConfig,
 		LastReportOnline:    make(map[int]int),
 		eTags:               make(map[string]string),
+		logger:              logger,
 	}
-}
+	// Read local rule list
+	apiClient.loadLocalRuleList(apiConfig.RuleListPath)
 
-// readLocalRuleList reads the local rule list file
-func readLocalRuleList(path string) (LocalRuleList []api.DetectRule) {
-	LocalRuleList = make([]api.DetectRule, 0)
-	if path != "" {
-		// open the file
-		file, err := os.Open(path)
-
-		defer func(file *os.File) {
-			err := file.Close()
-			if err != nil {
-				log.Printf("Error when closing file: %s", err)
-			}
-		}(file)
-		// handle errors while opening
-		if err != nil {
-			log.Printf("Error when opening file: %s", err)
-			return LocalRuleList
-		}
+	return apiClient
+}
 
-		fileScanner := bufio.NewScanner(file)
+// loadLocalRuleList reads the local rule list and reloads it whenever its files change
+func (c *APIClient) loadLocalRuleList(path string) {
+	if path == "" {
+		return
+	}
+	logger := c.logger.WithField("rule_list", path)
+	list, watcher, err := rulelist.Watch(path, func(list *rulelist.List) {
+		c.logRuleListErrors(list)
+		c.ruleAccess.Lock()
+		c.LocalRuleList = list.DetectRules()
+		c.localRuleChanged = true
+		c.ruleAccess.Unlock()
+		logger.WithField("rules", len(list.Rules)).Print("Reloaded local rules")
+	})
+	if err != nil {
+		logger.WithError(err).Print("Watch local rule list failed")
+	}
+	c.logRuleListErrors(list)
+	c.LocalRuleList = list.DetectRules()
+	c.ruleWatcher = watcher
+}
 
-		// read line by line
-		for fileScanner.Scan() {
-			LocalRuleList = append(LocalRuleList, api.DetectRule{
-				ID:      -1,
-				Pattern: regexp.MustCompile(fileScanner.Text()),
-			})
-		}
-		// handle first encountered error while reading
-		if err := fileScanner.Err(); err != nil {
-			log.Fatalf("Error while reading file: %s", err)
-			return
-		}
+func (c *APIClient) logRuleListErrors(list *rulelist.List) {
+	for _, err := range list.Errors {
+		c.logger.WithError(err).Print("Invalid local rule")
 	}
+}
 
-	return LocalRuleList
+// Close stops watching the local rule list
+func (c *APIClient) Close() error {
+	if c.ruleWatcher != nil {
+		return c.ruleWatcher.Close()
+	}
+	return nil
 }
 
 // Describe return a description of the client
@@ -196,7 +214,7 @@ func (c *APIClient) GetNodeInfo() (nodeInfo *api.NodeInfo, err error) {
 
 	if c.DisableCustomConfig || isExpired {
 		if isExpired {
-			log.Print("The panel version is expired, it is recommended to update immediately")
+			c.logger.Print("The panel version is expired, it is recommended to update immediately")
 		}
 
 		switch c.NodeType {
@@ -345,7 +363,6 @@ func (c *APIClient) ReportUserTraffic(userTraffic *[]api.UserTraffic) error {
 
 // GetNodeRule will pull the audit rule form ssPanel
 func (c *APIClient) GetNodeRule() (*[]api.DetectRule, error) {
-	ruleList := c.LocalRuleList
 	path := "/mod_mu/func/detect_rules"
 	res, err := c.client.R().
 		SetResult(&Response{}).
@@ -355,7 +372,13 @@ func (c *APIClient) GetNodeRule() (*[]api.DetectRule, error) {
 
 	// Etag identifier for a specific version of a resource. StatusCode = 304 means no changed
 	if res.StatusCode() == 304 {
-		return nil, errors.New(api.RuleNotModified)
+		// The panel rules are the same, but the local rules may have been reloaded
+		c.ruleAccess.Lock()
+		defer c.ruleAccess.Unlock()
+		if !c.localRuleChanged {
+			return nil, errors.New(api.RuleNotModified)
+		}
+		return c.ruleList(), nil
 	}
 
 	if res.Header().Get("ETag") != "" && res.Header().Get("ETag") != c.eTags["rules"] {
@@ -373,13 +396,30 @@ func (c *APIClient) GetNodeRule() (*[]api.DetectRule, error) {
 		return nil, fmt.Errorf("unmarshal %s failed: %s", reflect.TypeOf(ruleListResponse), err)
 	}
 
+	panelRuleList := make([]api.DetectRule, 0, len(*ruleListResponse))
 	for _, r := range *ruleListResponse {
-		ruleList = append(ruleList, api.DetectRule{
+		pattern, err := regexp.Compile(r.Content)
+		if err != nil {
+			c.logger.WithError(err).WithField("rule_id", r.ID).Print("Invalid panel rule")
+			continue
+		}
+		panelRuleList = append(panelRuleList, api.DetectRule{
 			ID:      r.ID,
-			Pattern: regexp.MustCompile(r.Content),
+			Pattern: pattern,
 		})
 	}
-	return &ruleList, nil
+	// The request is made without the lock, the local rules may be reloaded meanwhile
+	c.ruleAccess.Lock()
+	defer c.ruleAccess.Unlock()
+	c.panelRuleList = panelRuleList
+	return c.ruleList(), nil
+}
+
+// ruleList returns the local and the panel rules, called with ruleAccess held
+func (c *APIClient) ruleList() *[]api.DetectRule {
+	c.localRuleChanged = false
+	ruleList := append(append([]api.DetectRule{}, c.LocalRuleList...), c.panelRuleList...)
+	return &ruleList
 }
 
 // ReportIllegal reports the user illegal behaviors
@@ -2,7 +2,12 @@ package sspanel_test
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"Xray-P/api"
 	"Xray-P/api/sspanel"
@@ -144,6 +149,47 @@ func TestGetNodeRule(t *testing.T) {
 	t.Log(ruleList)
 }
 
+func TestLocalRuleListEmptied(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == "rules-1" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", "rules-1")
+		fmt.Fprint(w, `{"ret": 1, "data": []}`)
+	}))
+	defer ts.Close()
+	ruleFile := filepath.Join(t.TempDir(), "rulelist")
+	if err := os.WriteFile(ruleFile, []byte("full:example.org\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client := sspanel.New(&api.Config{APIHost: ts.URL, Key: "123", NodeID: 3, NodeType: "V2ray", RuleListPath: ruleFile})
+	defer client.Close()
+
+	ruleList, err := client.GetNodeRule()
+	if err != nil || len(*ruleList) != 1 {
+		t.Fatalf("expected the local rule, got %v %v", ruleList, err)
+	}
+	// Emptying the local rules is a change, the old rules must not be kept
+	if err := os.WriteFile(ruleFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ruleList, err = client.GetNodeRule()
+		if err == nil {
+			break
+		}
+		if err.Error() != api.RuleNotModified || time.Now().After(deadline) {
+			t.Fatalf("local rule list not reloaded: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(*ruleList) != 0 {
+		t.Errorf("expected no rule, got %v", *ruleList)
+	}
+}
+
 func TestReportIllegal(t *testing.T) {
 	client := CreateClient()
 
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"Xray-P/common/secret"
+	"Xray-P/panel"
+)
+
+func init() {
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "check",
+		Short: "Check the config file without starting any node",
+		Run: func(cmd *cobra.Command, args []string) {
+			problems := check()
+			for _, err := range problems {
+				fmt.Println(secret.Redact(err.Error()))
+			}
+			if len(problems) > 0 {
+				fmt.Printf("%d problems found in the config\n", len(problems))
+				os.Exit(1)
+			}
+			fmt.Println("Config OK")
+		},
+	})
+}
+
+// check returns every problem of the config file
+func check() []error {
+	config, err := loadConfig()
+	if err != nil {
+		return []error{fmt.Errorf("config file error: %s", err)}
+	}
+	var problems []error
+	// The variables and files which could not be read
+	expanded, err := expandConfig(config)
+	if expanded == nil {
+		return []error{fmt.Errorf("config file error: %s", err)}
+	}
+	if err != nil {
+		problems = append(problems, panel.UnwrapJoined(err)...)
+	}
+	panelConfig := &panel.Config{}
+	// Unknown keys are usually typos, report them and check the rest
+	if err := expanded.UnmarshalExact(panelConfig); err != nil {
+		problems = append(problems, fmt.Errorf("parse config file %v: %s", config.ConfigFileUsed(), err))
+		panelConfig = &panel.Config{}
+		if err := expanded.Unmarshal(panelConfig); err != nil {
+			return problems
+		}
+	}
+	if err := readInlineSections(config, panelConfig); err != nil {
+		problems = append(problems, fmt.Errorf("parse config file %v: %s", config.ConfigFileUsed(), err))
+	}
+	return append(problems, panel.Check(panelConfig)...)
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"Xray-P/service/admin"
+)
+
+var (
+	controlSocket string
+	controlToken  string
+	controlJSON   bool
+)
+
+func init() {
+	commands := []*cobra.Command{
+		{
+			Use:   "status",
+			Short: "Show a summary of the running nodes",
+			Args:  cobra.NoArgs,
+			RunE:  func(cmd *cobra.Command, args []string) error { return controlStatus() },
+		},
+		{
+			Use:   "nodes",
+			Short: "List the running nodes",
+			Args:  cobra.NoArgs,
+			RunE:  func(cmd *cobra.Command, args []string) error { return controlNodes() },
+		},
+		{
+			Use:   "users <node>",
+			Short: "List the users of a node, given by its tag or node ID",
+			Args:  cobra.ExactArgs(1),
+			RunE:  func(cmd *cobra.Command, args []string) error { return controlUsers(args[0]) },
+		},
+		{
+			Use:   "online",
+			Short: "List the online users of every node",
+			Args:  cobra.NoArgs,
+			RunE:  func(cmd *cobra.Command, args []string) error { return controlOnline() },
+		},
+		{
+			Use:   "kick <node> <uid>",
+			Short: "Close every connection of a user",
+			Args:  cobra.ExactArgs(2),
+			RunE:  func(cmd *cobra.Command, args []string) error { return controlKick(args[0], args[1]) },
+		},
+		{
+			Use:   "sync [node]",
+			Short: "Sync the nodes with the panel now",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return controlSync(strings.Join(args, ""))
+			},
+		},
+		{
+			Use:   "certs",
+			Short: "Show the certificates kept renewed and their renewal state",
+			Args:  cobra.NoArgs,
+			RunE:  func(cmd *cobra.Command, args []string) error { return controlCerts() },
+		},
+		{
+			Use:   "reload",
+			Short: "Reload the config file of the running process",
+			Args:  cobra.NoArgs,
+			RunE:  func(cmd *cobra.Command, args []string) error { return controlReload() },
+		},
+	}
+	for _, c := range commands {
+		c.SilenceUsage = true
+		c.SilenceErrors = true // Printed by main
+		c.Flags().StringVar(&controlSocket, "socket", "", "Admin API address, unix:/path or host:port, default to the one in the config")
+		c.Flags().StringVar(&controlToken, "token", "", "Admin API token, default to the one in the config")
+		c.Flags().BoolVar(&controlJSON, "json", false, "Print JSON instead of a table")
+		rootCmd.AddCommand(c)
+	}
+}
+
+// controlClient returns a client of the admin API of the running process,
+// the address and token come from the flags or the config file
+func controlClient() *admin.Client {
+	config := &admin.Config{}
+	if v, err := loadConfig(); err == nil {
+		if panelConfig, err := parseConfig(v); err == nil && panelConfig.AdminConfig != nil {
+			config = panelConfig.AdminConfig
+		}
+	}
+	if controlSocket != "" {
+		config.Listen = controlSocket
+	}
+	if controlToken != "" {
+		config.Token = controlToken
+	}
+	return admin.NewClient(config)
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func printTable(header []string, rows [][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func controlStatus() error {
+	status, err := controlClient().Status()
+	if err != nil {
+		return err
+	}
+	if controlJSON {
+		return printJSON(status)
+	}
+	fmt.Printf("Up since %s (%s)\n\n", status.StartedAt.Format(time.RFC3339), time.Since(status.StartedAt).Round(time.Second))
+	rows := make([][]string, 0, len(status.Nodes))
+	for _, n := range status.Nodes {
+		rows = append(rows, []string{
+			n.Tag, strconv.Itoa(n.NodeID), n.Panel, strconv.Itoa(n.Users), strconv.Itoa(n.OnlineUsers),
+			strconv.Itoa(n.OnlineIPs), strconv.Itoa(n.Limited), strconv.Itoa(n.Warned), n.Error,
+		})
+	}
+	printTable([]string{"TAG", "NODE ID", "PANEL", "USERS", "ONLINE", "IPS", "LIMITED", "WARNED", "ERROR"}, rows)
+	return nil
+}
+
+func controlNodes() error {
+	nodes, err := controlClient().Nodes()
+	if err != nil {
+		return err
+	}
+	if controlJSON {
+		return printJSON(nodes)
+	}
+	rows := make([][]string, 0, len(nodes))
+	for _, n := range nodes {
+		row := []string{n.Tag, strconv.Itoa(n.NodeID), n.NodeType, n.Panel, "", "", ""}
+		if n.NodeInfo != nil {
+			row[4] = strconv.Itoa(int(n.NodeInfo.Port))
+			row[5] = n.NodeInfo.TransportProtocol
+			row[6] = strconv.FormatBool(n.NodeInfo.EnableTLS || n.NodeInfo.EnableREALITY)
+		}
+		rows = append(rows, row)
+	}
+	printTable([]string{"TAG", "NODE ID", "TYPE", "PANEL", "PORT", "TRANSPORT", "TLS"}, rows)
+	return nil
+}
+
+func controlUsers(node string) error {
+	client := controlClient()
+	users, err := client.Users(node)
+	if err != nil {
+		return err
+	}
+	limited, warned, err := client.LimitedUsers(node)
+	if err != nil {
+		return err
+	}
+	if controlJSON {
+		return printJSON(map[string]interface{}{"users": users, "limited": limited, "warned": warned})
+	}
+	limits := make(map[int]string)
+	for _, l := range limited {
+		limits[l.UID] = fmt.Sprintf("%dMbps until %s", l.SpeedLimit, l.End.Format("01-02 15:04:05"))
+	}
+	for _, w := range warned {
+		if _, ok := limits[w.UID]; !ok {
+			limits[w.UID] = fmt.Sprintf("warned %d times", w.Warnings)
+		}
+	}
+	rows := make([][]string, 0, len(users))
+	for _, u := range users {
+		rows = append(rows, []string{
+			strconv.Itoa(u.UID), u.Email, strconv.FormatUint(u.SpeedLimit*8/1000000, 10), strconv.Itoa(u.DeviceLimit),
+			formatBytes(u.Upload), formatBytes(u.Download), strconv.Itoa(u.Sessions), strings.Join(u.OnlineIPs, ","), limits[u.UID],
+		})
+	}
+	printTable([]string{"UID", "EMAIL", "SPEED(Mbps)", "DEVICES", "UP", "DOWN", "CONNS", "ONLINE IPS", "LIMIT"}, rows)
+	return nil
+}
+
+func controlOnline() error {
+	type onlineUser struct {
+		Tag       string   `json:"tag"`
+		UID       int      `json:"uid"`
+		Email     string   `json:"email"`
+		Sessions  int      `json:"sessions"`
+		OnlineIPs []string `json:"online_ips"`
+	}
+	client := controlClient()
+	nodes, err := client.Nodes()
+	if err != nil {
+		return err
+	}
+	online := make([]onlineUser, 0)
+	for _, n := range nodes {
+		users, err := client.Users(n.Tag)
+		if err != nil {
+			return fmt.Errorf("%s: %s", n.Tag, err)
+		}
+		for _, u := range users {
+			if len(u.OnlineIPs) > 0 {
+				online = append(online, onlineUser{Tag: n.Tag, UID: u.UID, Email: u.Email, Sessions: u.Sessions, OnlineIPs: u.OnlineIPs})
+			}
+		}
+	}
+	if controlJSON {
+		return printJSON(online)
+	}
+	rows := make([][]string, 0, len(online))
+	for _, u := range online {
+		rows = append(rows, []string{u.Tag, strconv.Itoa(u.UID), u.Email, strconv.Itoa(u.Sessions), strings.Join(u.OnlineIPs, ",")})
+	}
+	printTable([]string{"TAG", "UID", "EMAIL", "CONNS", "ONLINE IPS"}, rows)
+	return nil
+}
+
+func controlKick(node string, uid string) error {
+	id, err := strconv.Atoi(uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid: %s", uid)
+	}
+	kicked, err := controlClient().Kick(node, id)
+	if err != nil {
+		return err
+	}
+	if controlJSON {
+		return printJSON(map[string]int{"kicked": kicked})
+	}
+	fmt.Printf("User %d kicked, %d connections closed\n", id, kicked)
+	return nil
+}
+
+func controlSync(node string) error {
+	if err := controlClient().Sync(node); err != nil {
+		return err
+	}
+	if controlJSON {
+		return printJSON(map[string]string{"status": "synced"})
+	}
+	fmt.Println("Synced")
+	return nil
+}
+
+func controlReload() error {
+	if err := controlClient().Reload(); err != nil {
+		return err
+	}
+	if controlJSON {
+		return printJSON(map[string]string{"status": "reloading"})
+	}
+	fmt.Println("Reloading")
+	return nil
+}
+
+func controlCerts() error {
+	certs, err := controlClient().Certs()
+	if err != nil {
+		return err
+	}
+	if controlJSON {
+		return printJSON(certs)
+	}
+	rows := make([][]string, 0, len(certs))
+	for _, c := range certs {
+		notAfter := ""
+		if !c.NotAfter.IsZero() {
+			notAfter = c.NotAfter.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			c.Domain, c.Mode, notAfter, strconv.Itoa(c.Nodes), strconv.Itoa(c.Failures), c.NextCheck.Format(time.RFC3339), c.LastError,
+		})
+	}
+	printTable([]string{"DOMAIN", "MODE", "EXPIRES", "NODES", "FAILURES", "NEXT CHECK", "ERROR"}, rows)
+	return nil
+}
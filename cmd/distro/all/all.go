@@ -13,7 +13,9 @@ import (
 	// Default commander and all its services. This is an optional feature.
 	_ "github.com/xtls/xray-core/app/commander"
 	_ "github.com/xtls/xray-core/app/log/command"
+	_ "github.com/xtls/xray-core/app/observatory/command"
 	_ "github.com/xtls/xray-core/app/proxyman/command"
+	_ "github.com/xtls/xray-core/app/router/command"
 	_ "github.com/xtls/xray-core/app/stats/command"
 
 	// Other optional features.
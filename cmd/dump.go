@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"Xray-P/api"
+	"Xray-P/panel"
+)
+
+var (
+	dumpUsers    int
+	dumpNodeInfo string
+	dumpRunning  bool
+)
+
+func init() {
+	dumpCmd := &cobra.Command{
+		Use:   "dump [node]",
+		Short: "Print the Xray JSON config generated for the nodes, secrets masked",
+		Long: "Print the Xray JSON config generated for every node, or the one given by its node ID, " +
+			"in the format of xray run. The node info and users are fetched from the panel, read from " +
+			"a node info file, or taken from the running process with --running.",
+		Args:          cobra.MaximumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true, // Printed by main
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := dump(args)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	}
+	dumpCmd.Flags().IntVar(&dumpUsers, "users", panel.DefaultDumpUsers, "Number of users of each node put in the clients")
+	dumpCmd.Flags().StringVar(&dumpNodeInfo, "node-info", "", "Node info JSON file to use instead of the panel, like the node_info of xrayp nodes --json")
+	dumpCmd.Flags().BoolVar(&dumpRunning, "running", false, "Dump the config of the running process through the admin API")
+	dumpCmd.Flags().StringVar(&controlSocket, "socket", "", "Admin API address, unix:/path or host:port, default to the one in the config")
+	dumpCmd.Flags().StringVar(&controlToken, "token", "", "Admin API token, default to the one in the config")
+	rootCmd.AddCommand(dumpCmd)
+}
+
+func dump(args []string) ([]byte, error) {
+	node := ""
+	if len(args) > 0 {
+		node = args[0]
+	}
+	if dumpRunning {
+		return controlClient().Dump(node, dumpUsers)
+	}
+
+	nodeID := 0
+	if node != "" {
+		id, err := strconv.Atoi(node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node ID: %s", node)
+		}
+		nodeID = id
+	}
+	var nodeInfo *api.NodeInfo
+	if dumpNodeInfo != "" {
+		data, err := os.ReadFile(dumpNodeInfo)
+		if err != nil {
+			return nil, err
+		}
+		nodeInfo = &api.NodeInfo{}
+		if err := json.Unmarshal(data, nodeInfo); err != nil {
+			return nil, fmt.Errorf("parse node info file %s failed: %s", dumpNodeInfo, err)
+		}
+	}
+	panelConfig, err := parseConfig(getConfig())
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %v failed: %s", cfgFile, err)
+	}
+	return panel.Dump(panelConfig, nodeID, nodeInfo, dumpUsers)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+
+	"Xray-P/panel"
+)
+
+var migrateOutput string
+
+func init() {
+	migrateCmd := &cobra.Command{
+		Use:   "migrate <config>",
+		Short: "Convert an XrayR or V2bX config file to the config of Xray-P",
+		Long: "Convert an XrayR config file (YAML) or a V2bX config file (JSON) to the config of Xray-P. " +
+			"The options dropped or changed in meaning are listed, followed by the diff of the config " +
+			"for review. The converted config is written with --output.",
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true, // Printed by main
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return migrate(args[0])
+		},
+	}
+	migrateCmd.Flags().StringVarP(&migrateOutput, "output", "o", "", "File to write the converted config to")
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func migrate(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m, err := panel.Migrate(data)
+	if err != nil {
+		return fmt.Errorf("migrate %s failed: %s", path, err)
+	}
+	fmt.Printf("Converted the %s config %s, %d options dropped or changed\n", m.Source, path, len(m.Notes))
+	for _, note := range m.Notes {
+		fmt.Println("  " + note.String())
+	}
+	to := migrateOutput
+	if to == "" {
+		to = "migrated"
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(data)),
+		B:        difflib.SplitLines(string(m.Config)),
+		FromFile: path,
+		ToFile:   to,
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Print("\n" + diff)
+	if migrateOutput == "" {
+		return nil
+	}
+	if err := os.WriteFile(migrateOutput, m.Config, 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("\nWrote %s, check it with: xrayp check -c %s\n", migrateOutput, migrateOutput)
+	return nil
+}
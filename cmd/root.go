@@ -1,12 +1,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,7 +21,11 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"Xray-P/common/secret"
+	"Xray-P/common/systemd"
 	"Xray-P/panel"
+	"Xray-P/service/admin"
+	"Xray-P/service/health"
 )
 
 var (
@@ -38,6 +45,14 @@ func init() {
 }
 
 func getConfig() *viper.Viper {
+	config, err := loadConfig()
+	if err != nil {
+		log.Panicf("Config file error: %s \n", err)
+	}
+	return config
+}
+
+func loadConfig() (*viper.Viper, error) {
 	config := viper.New()
 
 	// Set custom path and name
@@ -61,54 +76,153 @@ func getConfig() *viper.Viper {
 	}
 
 	if err := config.ReadInConfig(); err != nil {
-		log.Panicf("Config file error: %s \n", err)
+		return nil, err
 	}
 
-	config.WatchConfig() // Watch the config
+	return config, nil
+}
 
-	return config
+// parseConfig expands the environment variables, secret files and includes of the
+// config file and decodes it
+func parseConfig(config *viper.Viper) (*panel.Config, error) {
+	expanded, expandErr := expandConfig(config)
+	if expanded == nil {
+		return nil, expandErr
+	}
+	panelConfig := &panel.Config{}
+	if err := expanded.Unmarshal(panelConfig); err != nil {
+		return nil, errors.Join(expandErr, err)
+	}
+	if err := readInlineSections(config, panelConfig); err != nil {
+		return nil, errors.Join(expandErr, err)
+	}
+	return panelConfig, expandErr
+}
+
+// readInlineSections reads the inline core sections of the YAML or JSON config file
+// again, so they keep the dots and the case of their keys
+func readInlineSections(config *viper.Viper, panelConfig *panel.Config) error {
+	switch filepath.Ext(config.ConfigFileUsed()) {
+	case ".yml", ".yaml", ".json":
+	default:
+		return nil
+	}
+	data, err := os.ReadFile(config.ConfigFileUsed())
+	if err != nil {
+		return err
+	}
+	return panelConfig.ReadInlineSections(data, filepath.Dir(config.ConfigFileUsed()))
+}
+
+// expandConfig returns the config file with its environment variables, secret files
+// and includes expanded. The values which could not be expanded are left as they are.
+func expandConfig(config *viper.Viper) (*viper.Viper, error) {
+	settings, expandErr := secret.Expand(config.AllSettings(), filepath.Dir(config.ConfigFileUsed()))
+	expanded := viper.New()
+	if mergeErr := expanded.MergeConfigMap(settings); mergeErr != nil {
+		return nil, errors.Join(expandErr, mergeErr)
+	}
+	return expanded, expandErr
 }
 
 func run() error {
 	showVersion()
 
+	// Redact the secrets of the config file from the logs
+	log.AddHook(secret.Hook{})
 	config := getConfig()
-	panelConfig := &panel.Config{}
-	if err := config.Unmarshal(panelConfig); err != nil {
+	config.WatchConfig() // Watch the config
+	panelConfig, err := parseConfig(config)
+	if err != nil {
 		return fmt.Errorf("Parse config file %v failed: %s \n", cfgFile, err)
 	}
 
-	// Modern Log Format
-	log.SetFormatter(&xraylog.ModernFormatter{})
-	if panelConfig.LogConfig.Level == "debug" {
-		log.SetReportCaller(true)
-	}
+	setLogFormat(panelConfig.LogConfig)
 
 	p := panel.New(panelConfig)
+	var reloadAccess sync.Mutex
+	stopped := false
+	watchFiles := func([]string) {}
+	reload := func() error {
+		reloadAccess.Lock()
+		defer reloadAccess.Unlock()
+		if stopped {
+			return nil
+		}
+		notify(systemd.Reloading)
+		defer notify(systemd.Ready)
+		newConfig, err := parseConfig(config)
+		if err != nil {
+			return fmt.Errorf("parse config file %v failed, keeping the running config: %s", cfgFile, err)
+		}
+		// Also when invalid, a fix of a file is a change
+		watchFiles(newConfig.Files())
+		// Only the changed nodes are restarted
+		if err := p.Reload(newConfig); err != nil {
+			return fmt.Errorf("invalid config, keeping the running config: %s", err)
+		}
+		panelConfig = newConfig
+		setLogFormat(panelConfig.LogConfig)
+		// Delete old instances and trigger GC
+		runtime.GC()
+		return nil
+	}
+	// The JSON files of the core sections are watched as well
+	if watcher, err := newFileWatcher(func(name string) {
+		fmt.Println("Config file changed:", name)
+		if err := reload(); err != nil {
+			log.Error(err)
+		}
+	}); err != nil {
+		log.Errorf("Watch the files of the config failed: %s", err)
+	} else {
+		defer watcher.Close()
+		watchFiles = watcher.set
+		watchFiles(panelConfig.Files())
+	}
 	lastTime := time.Now()
 	config.OnConfigChange(func(e fsnotify.Event) {
 		// Discarding event received within a short period of time after receiving an event.
 		if time.Now().After(lastTime.Add(3 * time.Second)) {
 			// Hot reload function
 			fmt.Println("Config file changed:", e.Name)
-			p.Close()
-			// Delete old instance and trigger GC
-			runtime.GC()
-			if err := config.Unmarshal(panelConfig); err != nil {
-				log.Panicf("Parse config file %v failed: %s \n", cfgFile, err)
+			if err := reload(); err != nil {
+				log.Error(err)
 			}
-
-			if panelConfig.LogConfig.Level == "debug" {
-				log.SetReportCaller(true)
-			}
-
-			p.Start()
 			lastTime = time.Now()
 		}
 	})
 
 	p.Start()
-	defer p.Close()
+	notify(systemd.Ready)
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go watchdog(p, interval)
+	}
+
+	// Admin API, changes of its own config need a restart
+	if c := panelConfig.AdminConfig; c != nil && c.Enable {
+		adminServer := admin.New(c, p.Controllers, func() error {
+			if err := config.ReadInConfig(); err != nil {
+				return err
+			}
+			return reload()
+		}, p.Dump)
+		if err := adminServer.Start(); err != nil {
+			log.Errorf("Failed to start admin API: %s", err)
+		} else {
+			defer adminServer.Close()
+		}
+	}
+
+	// Health checks, changes of their own config need a restart
+	if c := panelConfig.HealthConfig; c != nil && c.Enable {
+		healthServer := health.New(c, p.Controllers, p.Ready)
+		if err := healthServer.Start(); err != nil {
+			log.Errorf("Failed to start health checks: %s", err)
+		} else {
+			defer healthServer.Close()
+		}
+	}
 
 	// Explicitly triggering GC to remove garbage from config loading.
 	runtime.GC()
@@ -117,9 +231,45 @@ func run() error {
 	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM)
 	<-osSignals
 
+	notify(systemd.Stopping)
+	reloadAccess.Lock()
+	defer reloadAccess.Unlock()
+	stopped = true
+	p.Shutdown(time.Duration(panelConfig.DrainTimeout) * time.Second)
+	p.Close()
 	return nil
 }
 
+func notify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		log.Warnf("Notify systemd of %s failed: %s", state, err)
+	}
+}
+
+// watchdog pings the systemd watchdog as long as the periodic tasks of the nodes run
+func watchdog(p *panel.Panel, interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if stalled := p.Stalled(); len(stalled) > 0 {
+			log.Errorf("Periodic tasks stalled, the watchdog is not pinged: %s", strings.Join(stalled, ", "))
+			continue
+		}
+		notify(systemd.Watchdog)
+	}
+}
+
+func setLogFormat(logConfig *panel.LogConfig) {
+	if logConfig == nil {
+		log.SetFormatter(xraylog.NewFormatter(xraylog.FormatText))
+		return
+	}
+	log.SetFormatter(xraylog.NewFormatter(logConfig.Format))
+	if logConfig.Level == "debug" {
+		log.SetReportCaller(true)
+	}
+}
+
 func Execute() error {
 	return rootCmd.Execute()
 }
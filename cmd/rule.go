@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"Xray-P/common/rulelist"
+)
+
+var (
+	ruleFile string
+	ruleCmd  = &cobra.Command{
+		Use:   "rule",
+		Short: "Inspect the local audit rule lists",
+	}
+	ruleTestCmd = &cobra.Command{
+		Use:   "test <destination>",
+		Short: "Test a destination like example.com:443 against the local rule lists",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := ruleTest(args[0]); err != nil {
+				fmt.Println(err)
+			}
+		},
+	}
+)
+
+func init() {
+	ruleTestCmd.Flags().StringVarP(&ruleFile, "file", "f", "", "Rule list file or directory to test instead of the ones in the config")
+	ruleCmd.AddCommand(ruleTestCmd)
+	rootCmd.AddCommand(ruleCmd)
+}
+
+func ruleTest(destination string) error {
+	// Rules are matched against destinations like tcp:example.com:443
+	if !strings.HasPrefix(destination, "tcp:") && !strings.HasPrefix(destination, "udp:") {
+		destination = "tcp:" + destination
+	}
+
+	if ruleFile != "" {
+		printRuleTest(ruleFile, rulelist.Load(ruleFile), destination)
+		return nil
+	}
+
+	panelConfig, err := parseConfig(getConfig())
+	if err != nil {
+		return fmt.Errorf("parse config file %v failed: %s", cfgFile, err)
+	}
+	for _, node := range panelConfig.NodesConfig {
+		if node.ApiConfig == nil || node.ApiConfig.RuleListPath == "" {
+			continue
+		}
+		name := fmt.Sprintf("%s node %d", node.ApiConfig.APIHost, node.ApiConfig.NodeID)
+		printRuleTest(name, rulelist.Load(node.ApiConfig.RuleListPath), destination)
+	}
+	return nil
+}
+
+func printRuleTest(name string, list *rulelist.List, destination string) {
+	fmt.Printf("%s: %d rules\n", name, len(list.Rules))
+	for _, err := range list.Errors {
+		fmt.Printf("  invalid: %s\n", err)
+	}
+	if r := list.Match(destination); r != nil {
+		fmt.Printf("  %s matched by %s: %s\n", destination, r.Source(), r.Raw)
+	} else {
+		fmt.Printf("  %s not matched\n", destination)
+	}
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// Editors write files in several steps, the change is reported once they are done
+const fileChangeDelay = 500 * time.Millisecond
+
+// fileWatcher calls onChange when any of the files referenced by the config file
+// changes. The directories are watched, editors and config managers replace files.
+type fileWatcher struct {
+	watcher  *fsnotify.Watcher
+	onChange func(name string)
+	access   sync.Mutex
+	files    map[string]bool
+	dirs     map[string]bool
+	timer    *time.Timer
+}
+
+func newFileWatcher(onChange func(name string)) (*fileWatcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &fileWatcher{
+		watcher:  fw,
+		onChange: onChange,
+		files:    make(map[string]bool),
+		dirs:     make(map[string]bool),
+	}
+	go w.run()
+	return w, nil
+}
+
+// set replaces the watched files
+func (w *fileWatcher) set(files []string) {
+	w.access.Lock()
+	defer w.access.Unlock()
+	w.files = make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			continue
+		}
+		w.files[abs] = true
+		dir := filepath.Dir(abs)
+		if !w.dirs[dir] && !dirs[dir] {
+			if err := w.watcher.Add(dir); err != nil {
+				log.Warnf("Watch %s failed: %s", dir, err)
+				continue
+			}
+		}
+		dirs[dir] = true
+	}
+	for dir := range w.dirs {
+		if !dirs[dir] {
+			w.watcher.Remove(dir)
+		}
+	}
+	w.dirs = dirs
+}
+
+func (w *fileWatcher) relevant(name string) bool {
+	w.access.Lock()
+	defer w.access.Unlock()
+	return w.files[filepath.Clean(name)]
+}
+
+func (w *fileWatcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 || !w.relevant(event.Name) {
+				continue
+			}
+			w.access.Lock()
+			if w.timer != nil {
+				w.timer.Stop()
+			}
+			name := event.Name
+			w.timer = time.AfterFunc(fileChangeDelay, func() { w.onChange(name) })
+			w.access.Unlock()
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("Watch config files failed: %s", err)
+		}
+	}
+}
+
+func (w *fileWatcher) Close() error {
+	w.access.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.access.Unlock()
+	return w.watcher.Close()
+}
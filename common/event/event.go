@@ -0,0 +1,251 @@
+// Package event publishes the node lifecycle and enforcement events to webhooks
+package event
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"Xray-P/api"
+	xraylog "Xray-P/common/log"
+)
+
+// Event types
+const (
+	NodeInfoChanged      = "node.info_changed"
+	InboundRebuildFailed = "node.inbound_failed"
+	PanelUnreachable     = "panel.unreachable"
+	PanelRecovered       = "panel.recovered"
+	CertRenewed          = "cert.renewed"
+	CertFailing          = "cert.failing"
+	CertRejected         = "cert.rejected"
+	UserLimited          = "user.limited"
+	UserReleased         = "user.released"
+	DeviceLimitBurst     = "user.device_limit_burst"
+	RuleHit              = "user.rule_hit"
+
+	defaultDeviceLimitBurst = 10
+	burstWindow             = time.Minute
+)
+
+type Config struct {
+	Enable           bool             `mapstructure:"Enable"`
+	DeviceLimitBurst int              `mapstructure:"DeviceLimitBurst"` // Device limit rejections of a user in a minute to publish a burst
+	Webhooks         []*WebhookConfig `mapstructure:"Webhooks"`
+}
+
+// Event is something that happened on a node
+type Event struct {
+	Type     string                 `json:"type"`
+	Time     time.Time              `json:"time"`
+	Panel    string                 `json:"panel"`
+	NodeID   int                    `json:"node_id"`
+	NodeType string                 `json:"node_type"`
+	Tag      string                 `json:"tag,omitempty"`
+	UID      int                    `json:"uid,omitempty"`
+	Email    string                 `json:"email,omitempty"`
+	Message  string                 `json:"message"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// Manager sends the published events to the webhooks
+type Manager struct {
+	webhooks         []*webhook
+	deviceLimitBurst int
+}
+
+var (
+	current atomic.Pointer[Manager]
+
+	nodesAccess sync.Mutex
+	// Key: inbound tag. The last node bound to the tag receives its rejections, the node of
+	// a core being replaced shares the tag with the node of the new core until it is closed.
+	nodes = make(map[string][]*Node)
+)
+
+// Start starts the webhooks of config and makes the manager the one events are published to
+func Start(config *Config) (*Manager, error) {
+	m := &Manager{deviceLimitBurst: config.DeviceLimitBurst}
+	if m.deviceLimitBurst <= 0 {
+		m.deviceLimitBurst = defaultDeviceLimitBurst
+	}
+	for i, c := range config.Webhooks {
+		w, err := newWebhook(c)
+		if err != nil {
+			for _, started := range m.webhooks {
+				started.close()
+			}
+			return nil, fmt.Errorf("webhook %d: %s", i, err)
+		}
+		m.webhooks = append(m.webhooks, w)
+	}
+	current.Store(m)
+	return m, nil
+}
+
+// Close stops publishing to the manager and sends the queued events
+func (m *Manager) Close() error {
+	current.CompareAndSwap(m, nil)
+	for _, w := range m.webhooks {
+		w.close()
+	}
+	return nil
+}
+
+func publish(e *Event) {
+	m := current.Load()
+	if m == nil {
+		return
+	}
+	log.WithFields(log.Fields{"type": e.Type, xraylog.FieldNodeID: e.NodeID}).Debug("Event published")
+	for _, w := range m.webhooks {
+		w.publish(e)
+	}
+}
+
+// Node publishes the events of one panel node
+type Node struct {
+	clientInfo api.ClientInfo
+	access     sync.Mutex
+	tag        string
+	bursts     map[string]*burst // Key: email
+}
+
+type burst struct {
+	start     time.Time
+	rejects   int
+	ips       map[string]struct{}
+	published bool
+}
+
+// NewNode returns the publisher of the node described by clientInfo
+func NewNode(clientInfo api.ClientInfo) *Node {
+	return &Node{clientInfo: clientInfo, bursts: make(map[string]*burst)}
+}
+
+// SetTag binds the node to its inbound, so the rejections of the limiter reach it
+func (n *Node) SetTag(tag string) {
+	n.access.Lock()
+	defer n.access.Unlock()
+	nodesAccess.Lock()
+	defer nodesAccess.Unlock()
+	if n.tag != "" {
+		bound := nodes[n.tag]
+		for i := range bound {
+			if bound[i] == n {
+				bound = append(bound[:i:i], bound[i+1:]...)
+				break
+			}
+		}
+		if len(bound) == 0 {
+			delete(nodes, n.tag)
+		} else {
+			nodes[n.tag] = bound
+		}
+	}
+	n.tag = tag
+	n.bursts = make(map[string]*burst)
+	if tag != "" {
+		nodes[tag] = append(nodes[tag], n)
+	}
+}
+
+// boundNode returns the node receiving the rejections of the inbound tag
+func boundNode(tag string) *Node {
+	nodesAccess.Lock()
+	defer nodesAccess.Unlock()
+	if bound := nodes[tag]; len(bound) > 0 {
+		return bound[len(bound)-1]
+	}
+	return nil
+}
+
+// Delete unbinds the node from its inbound, used when the node is closed
+func (n *Node) Delete() {
+	n.SetTag("")
+}
+
+// Publish fills in the node of the event and sends it to the webhooks
+func (n *Node) Publish(e *Event) {
+	n.access.Lock()
+	e.Tag = n.tag
+	n.access.Unlock()
+	e.Time = time.Now()
+	e.Panel = n.clientInfo.APIHost
+	e.NodeID = n.clientInfo.NodeID
+	e.NodeType = n.clientInfo.NodeType
+	publish(e)
+}
+
+// DeviceLimitRejected counts a connection rejected by the device limits, and publishes
+// a burst once a user reaches the threshold in a minute. It is the reject hook of the limiter.
+func DeviceLimitRejected(tag string, email string, ip string) {
+	m := current.Load()
+	if m == nil {
+		return
+	}
+	n := boundNode(tag)
+	if n == nil {
+		return
+	}
+
+	n.access.Lock()
+	now := time.Now()
+	b, ok := n.bursts[email]
+	if !ok || now.Sub(b.start) > burstWindow {
+		b = &burst{start: now, ips: make(map[string]struct{})}
+		n.bursts[email] = b
+		// Forget the users who stopped
+		for k, v := range n.bursts {
+			if now.Sub(v.start) > burstWindow {
+				delete(n.bursts, k)
+			}
+		}
+	}
+	b.rejects++
+	b.ips[ip] = struct{}{}
+	reached := !b.published && b.rejects >= m.deviceLimitBurst
+	if reached {
+		b.published = true
+	}
+	rejects, ips := b.rejects, make([]string, 0, len(b.ips))
+	for ip := range b.ips {
+		ips = append(ips, ip)
+	}
+	n.access.Unlock()
+	if !reached {
+		return
+	}
+
+	e := &Event{
+		Type:    DeviceLimitBurst,
+		Message: "Device limit rejections burst",
+		Data:    map[string]interface{}{"rejects": rejects, "ips": ips, "window": burstWindow.String()},
+	}
+	// The email of the core is the user tag
+	fields := xraylog.UserFields(email)
+	e.Email, _ = fields[xraylog.FieldEmail].(string)
+	e.UID, _ = fields[xraylog.FieldUID].(int)
+	n.Publish(e)
+}
+
+// match returns whether the event type is matched by one of the patterns,
+// a pattern is a type, a group like "user.*" or "*"
+func match(patterns []string, eventType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if p == "*" || p == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package event
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"Xray-P/api"
+)
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		patterns []string
+		event    string
+		want     bool
+	}{
+		{nil, UserLimited, true},
+		{[]string{"*"}, PanelRecovered, true},
+		{[]string{"user.*"}, UserLimited, true},
+		{[]string{"user.*"}, PanelUnreachable, false},
+		{[]string{PanelUnreachable, CertFailing}, CertFailing, true},
+		{[]string{PanelUnreachable}, PanelRecovered, false},
+	} {
+		if got := match(c.patterns, c.event); got != c.want {
+			t.Errorf("match(%v, %s) = %v, want %v", c.patterns, c.event, got, c.want)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan *Payload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("secret", r.Header.Get(TimestampHeader), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// Fail the first delivery to test the retry
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		payload := &Payload{}
+		json.Unmarshal(body, payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	m, err := Start(&Config{Webhooks: []*WebhookConfig{{
+		URL:       server.URL,
+		Secret:    "secret",
+		Events:    []string{"user.*"},
+		NodeIDs:   []int{1},
+		BatchSize: 2,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	node := NewNode(api.ClientInfo{APIHost: "http://panel", NodeID: 1, NodeType: "V2ray"})
+	other := NewNode(api.ClientInfo{APIHost: "http://panel", NodeID: 2, NodeType: "V2ray"})
+	node.SetTag("V2ray_0.0.0.0_443")
+	defer node.Delete()
+	node.Publish(&Event{Type: PanelUnreachable, Message: "filtered by type"})
+	other.Publish(&Event{Type: UserLimited, UID: 3, Message: "filtered by node"})
+	node.Publish(&Event{Type: UserLimited, UID: 1, Message: "User speed limited"})
+	node.Publish(&Event{Type: UserReleased, UID: 1, Message: "User speed limit released"})
+
+	select {
+	case payload := <-received:
+		if len(payload.Events) != 2 {
+			t.Fatalf("got %d events, want 2", len(payload.Events))
+		}
+		e := payload.Events[0]
+		if e.Type != UserLimited || e.NodeID != 1 || e.Tag != "V2ray_0.0.0.0_443" || e.Panel != "http://panel" || e.Time.IsZero() {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("events not delivered")
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("got %d attempts, want 2", n)
+	}
+}
+
+func TestDeviceLimitBurst(t *testing.T) {
+	received := make(chan *Payload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := &Payload{}
+		json.NewDecoder(r.Body).Decode(payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	m, err := Start(&Config{DeviceLimitBurst: 3, Webhooks: []*WebhookConfig{{URL: server.URL, BatchSize: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tag := "Vless_0.0.0.0_8443"
+	node := NewNode(api.ClientInfo{NodeID: 5})
+	node.SetTag(tag)
+	defer node.Delete()
+	// Not bound to a node, ignored
+	DeviceLimitRejected("unknown", "unknown|b@test.com|9", "1.1.1.1")
+	for i := 0; i < 5; i++ {
+		DeviceLimitRejected(tag, tag+"|a@test.com|7", "1.2.3.4")
+	}
+
+	select {
+	case payload := <-received:
+		e := payload.Events[0]
+		if e.Type != DeviceLimitBurst || e.UID != 7 || e.Email != "a@test.com" || e.NodeID != 5 {
+			t.Errorf("unexpected event: %+v", e)
+		}
+		if rejects := e.Data["rejects"].(float64); rejects != 3 {
+			t.Errorf("got %v rejects, want 3", rejects)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("burst not published")
+	}
+	// Published once per window
+	select {
+	case payload := <-received:
+		t.Errorf("unexpected second payload: %+v", payload.Events[0])
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestUnsupportedWebhook(t *testing.T) {
+	if _, err := Start(&Config{Webhooks: []*WebhookConfig{{}}}); err == nil {
+		t.Error("webhook without URL accepted")
+	}
+}
+
+func TestSetTag(t *testing.T) {
+	tag := "Trojan_0.0.0.0_443"
+	node := NewNode(api.ClientInfo{NodeID: 6})
+	replacing := NewNode(api.ClientInfo{NodeID: 6})
+	node.SetTag(tag)
+	replacing.SetTag(tag)
+	if boundNode(tag) != replacing {
+		t.Error("the rejections do not reach the last node bound")
+	}
+	// The new node is closed when it fails to start, the running one is bound again
+	replacing.Delete()
+	if boundNode(tag) != node {
+		t.Error("the running node is not bound after the new one is closed")
+	}
+	node.Delete()
+	if boundNode(tag) != nil {
+		t.Error("a closed node is still bound")
+	}
+}
+
+func TestWebhookClose(t *testing.T) {
+	var attempts atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	w, err := newWebhook(&WebhookConfig{URL: failing.URL, Retries: 3, FlushInterval: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.publish(&Event{Type: UserLimited})
+	start := time.Now()
+	w.close()
+	// One attempt without retries
+	if n := attempts.Load(); n != 1 || time.Since(start) >= retryBackoff {
+		t.Errorf("got %d attempts in %s on close, want 1 without backoff", n, time.Since(start))
+	}
+
+	// A webhook not answering does not hold the close past the timeout
+	defer func(timeout time.Duration) { closeTimeout = timeout }(closeTimeout)
+	closeTimeout = 100 * time.Millisecond
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+	w, err = newWebhook(&WebhookConfig{URL: hanging.URL, BatchSize: 1, FlushInterval: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		w.publish(&Event{Type: UserLimited})
+	}
+	start = time.Now()
+	w.close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("close took %s", elapsed)
+	}
+}
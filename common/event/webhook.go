@@ -0,0 +1,256 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SignatureHeader holds "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>" keyed by the Secret
+	SignatureHeader = "X-Xrayp-Signature"
+	// TimestampHeader holds the unix time the request was signed at
+	TimestampHeader = "X-Xrayp-Timestamp"
+
+	defaultBatchSize     = 20
+	defaultFlushInterval = 5
+	defaultRetries       = 3
+	defaultTimeout       = 10
+	retryBackoff         = time.Second
+	webhookQueueSize     = 1024
+)
+
+// closeTimeout bounds the delivery of the events left when the webhooks are closed
+var closeTimeout = 10 * time.Second
+
+type WebhookConfig struct {
+	URL           string   `mapstructure:"URL"`
+	Secret        string   `mapstructure:"Secret"`        // Key of the HMAC-SHA256 signature, no signature if empty
+	Events        []string `mapstructure:"Events"`        // Event types to send, e.g. panel.unreachable or user.*, empty for all
+	NodeIDs       []int    `mapstructure:"NodeIDs"`       // Nodes to send the events of, empty for all
+	BatchSize     int      `mapstructure:"BatchSize"`     // Events sent at once
+	FlushInterval int      `mapstructure:"FlushInterval"` // Seconds before a partial batch is sent
+	Retries       int      `mapstructure:"Retries"`       // Retries of a failed delivery, with exponential backoff
+	Timeout       int      `mapstructure:"Timeout"`       // Seconds of a delivery
+}
+
+// Payload is the body posted to the webhooks
+type Payload struct {
+	Events []*Event `json:"events"`
+}
+
+// Sign returns the value of the signature header of body, sent at timestamp
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhook struct {
+	config        *WebhookConfig
+	client        *http.Client
+	nodeIDs       map[int]struct{}
+	batchSize     int
+	flushInterval time.Duration
+	retries       int
+	queue         chan *Event
+	dropped       atomic.Int64
+	done          chan struct{}
+	ctx           context.Context // Canceled when the events left could not be delivered in time on close
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+	closeOnce     sync.Once
+}
+
+func newWebhook(config *WebhookConfig) (*webhook, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("a webhook needs a URL")
+	}
+	w := &webhook{
+		config:        config,
+		batchSize:     config.BatchSize,
+		flushInterval: time.Duration(config.FlushInterval) * time.Second,
+		retries:       config.Retries,
+		queue:         make(chan *Event, webhookQueueSize),
+		done:          make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	if w.batchSize <= 0 {
+		w.batchSize = defaultBatchSize
+	}
+	if w.flushInterval <= 0 {
+		w.flushInterval = defaultFlushInterval * time.Second
+	}
+	if w.retries < 0 {
+		w.retries = 0
+	} else if w.retries == 0 {
+		w.retries = defaultRetries
+	}
+	timeout := time.Duration(config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout * time.Second
+	}
+	w.client = &http.Client{Timeout: timeout}
+	if len(config.NodeIDs) > 0 {
+		w.nodeIDs = make(map[int]struct{}, len(config.NodeIDs))
+		for _, id := range config.NodeIDs {
+			w.nodeIDs[id] = struct{}{}
+		}
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// publish queues the event if it passes the filters, it is dropped when the queue is full
+func (w *webhook) publish(e *Event) {
+	if !match(w.config.Events, e.Type) {
+		return
+	}
+	if w.nodeIDs != nil {
+		if _, ok := w.nodeIDs[e.NodeID]; !ok {
+			return
+		}
+	}
+	select {
+	case w.queue <- e:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+func (w *webhook) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Event, 0, w.batchSize)
+	flush := func() {
+		if dropped := w.dropped.Swap(0); dropped > 0 {
+			log.WithFields(log.Fields{"url": w.config.URL, "dropped": dropped}).Warn("Webhook queue is full, events dropped")
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.deliver(batch); err != nil {
+			log.WithError(err).WithFields(log.Fields{"url": w.config.URL, "events": len(batch)}).Error("Webhook delivery failed")
+		}
+		batch = make([]*Event, 0, w.batchSize)
+	}
+	for {
+		select {
+		case e := <-w.queue:
+			batch = append(batch, e)
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-w.done:
+			// Drain what is left in the queue
+			for {
+				select {
+				case e := <-w.queue:
+					batch = append(batch, e)
+					if len(batch) >= w.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver posts the events, and retries with backoff on network errors, 429 and 5xx.
+// Once the webhook is closing, one attempt is made without retries.
+func (w *webhook) deliver(events []*Event) error {
+	body, err := json.Marshal(&Payload{Events: events})
+	if err != nil {
+		return err
+	}
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		closing := w.closing()
+		retry, err := w.post(body)
+		if err == nil || !retry || attempt >= w.retries || closing {
+			return err
+		}
+		log.WithError(err).WithFields(log.Fields{"url": w.config.URL, "attempt": attempt + 1}).Warn("Webhook delivery failed, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-w.done:
+			// Closing, the last attempt is made without waiting
+		}
+		backoff *= 2
+	}
+}
+
+func (w *webhook) closing() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *webhook) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	if w.config.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.config.Secret, timestamp, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return retry, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
+
+// close delivers the events left, for up to closeTimeout
+func (w *webhook) close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		finished := make(chan struct{})
+		go func() {
+			w.wg.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(closeTimeout):
+			log.WithField("url", w.config.URL).Warn("Webhook delivery timed out on close, the events left are dropped")
+			// The deliveries left fail at once
+			w.cancel()
+			<-finished
+		}
+		w.cancel()
+		w.client.CloseIdleConnections()
+	})
+}
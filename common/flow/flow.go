@@ -0,0 +1,204 @@
+// Package flow exports a record of every finished connection of the users,
+// to a local JSONL file, an IPFIX collector or an HTTP endpoint
+package flow
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xtls/xray-core/xrayr/conntrack"
+
+	xraylog "Xray-P/common/log"
+)
+
+const (
+	ExporterJSONL = "jsonl"
+	ExporterIPFIX = "ipfix"
+	ExporterHTTP  = "http"
+
+	defaultBatchSize     = 500
+	defaultFlushInterval = 10
+	queueSize            = 8192
+)
+
+type Config struct {
+	Enable        bool                  `mapstructure:"Enable"`
+	Exporter      string                `mapstructure:"Exporter"`      // jsonl, ipfix or http
+	Path          string                `mapstructure:"Path"`          // File of the jsonl exporter
+	Rotate        *xraylog.RotateConfig `mapstructure:"Rotate"`        // Rotation of the jsonl file
+	Collector     string                `mapstructure:"Collector"`     // host:port of the IPFIX collector, over UDP
+	DomainID      uint32                `mapstructure:"DomainID"`      // IPFIX observation domain ID
+	URL           string                `mapstructure:"URL"`           // Endpoint of the http exporter
+	Token         string                `mapstructure:"Token"`         // Bearer token of the http exporter
+	BatchSize     int                   `mapstructure:"BatchSize"`     // Records sent at once
+	FlushInterval int                   `mapstructure:"FlushInterval"` // Seconds before a partial batch is sent
+}
+
+// Record is a finished connection of a user
+type Record struct {
+	Tag         string    `json:"tag"`
+	UID         int       `json:"uid,omitempty"`
+	Email       string    `json:"email"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Domain      string    `json:"domain,omitempty"`
+	Network     string    `json:"network"`
+	Transport   string    `json:"transport,omitempty"`
+	Protocol    string    `json:"protocol,omitempty"`
+	Outbound    string    `json:"outbound,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Upload      int64     `json:"upload"`
+	Download    int64     `json:"download"`
+	Reason      string    `json:"reason"`
+}
+
+func newRecord(r *conntrack.Record) *Record {
+	record := &Record{
+		Tag:         r.Tag,
+		Email:       r.Email,
+		Source:      r.Source,
+		Destination: r.Destination,
+		Domain:      r.Domain,
+		Network:     r.Network,
+		Transport:   r.Transport,
+		Protocol:    r.Protocol,
+		Outbound:    r.Outbound,
+		Start:       r.Start,
+		End:         r.End,
+		Upload:      r.Upload,
+		Download:    r.Download,
+		Reason:      r.Reason,
+	}
+	// The email of the core is the user tag
+	fields := xraylog.UserFields(r.Email)
+	if email, ok := fields[xraylog.FieldEmail].(string); ok {
+		record.Email = email
+	}
+	if uid, ok := fields[xraylog.FieldUID].(int); ok {
+		record.UID = uid
+	}
+	return record
+}
+
+// sink writes the batches of records
+type sink interface {
+	write(records []*Record) error
+	close() error
+}
+
+// Exporter queues the records and writes them in batches, it implements conntrack.Exporter
+type Exporter struct {
+	sink          sink
+	batchSize     int
+	flushInterval time.Duration
+	queue         chan *Record
+	dropped       atomic.Int64
+	done          chan struct{}
+	wg            sync.WaitGroup
+	closeOnce     sync.Once
+}
+
+// New returns the exporter of config and starts it
+func New(config *Config) (*Exporter, error) {
+	var s sink
+	var err error
+	switch config.Exporter {
+	case ExporterJSONL, "":
+		s, err = newJSONLSink(config)
+	case ExporterIPFIX:
+		s, err = newIPFIXSink(config)
+	case ExporterHTTP:
+		s, err = newHTTPSink(config)
+	default:
+		return nil, fmt.Errorf("unsupported flow exporter: %s", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Exporter{
+		sink:          s,
+		batchSize:     config.BatchSize,
+		flushInterval: time.Duration(config.FlushInterval) * time.Second,
+		queue:         make(chan *Record, queueSize),
+		done:          make(chan struct{}),
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval * time.Second
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e, nil
+}
+
+// Export queues the record, it is dropped when the queue is full
+func (e *Exporter) Export(record *conntrack.Record) {
+	select {
+	case e.queue <- newRecord(record):
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *Exporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Record, 0, e.batchSize)
+	flush := func() {
+		if dropped := e.dropped.Swap(0); dropped > 0 {
+			log.WithField("dropped", dropped).Warn("Flow exporter queue is full, records dropped")
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.sink.write(batch); err != nil {
+			log.WithError(err).WithField("records", len(batch)).Error("Export flow records failed")
+		}
+		batch = make([]*Record, 0, e.batchSize)
+	}
+	for {
+		select {
+		case record := <-e.queue:
+			batch = append(batch, record)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			// Drain what is left in the queue
+			for {
+				select {
+				case record := <-e.queue:
+					batch = append(batch, record)
+					if len(batch) >= e.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close writes the queued records and closes the exporter
+func (e *Exporter) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.done)
+		e.wg.Wait()
+		err = e.sink.close()
+	})
+	return err
+}
@@ -0,0 +1,195 @@
+package flow
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/xrayr/conntrack"
+)
+
+func testRecord() *conntrack.Record {
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	return &conntrack.Record{
+		Tag:         "Vless_0.0.0.0_443",
+		Email:       "Vless_0.0.0.0_443|a@test.com|12",
+		Source:      "tcp:1.2.3.4:5678",
+		Destination: "tcp:5.6.7.8:443",
+		Domain:      "example.com",
+		Network:     "tcp",
+		Transport:   "ws",
+		Protocol:    "tls",
+		Outbound:    "direct",
+		Start:       start,
+		End:         start.Add(time.Minute),
+		Upload:      1000,
+		Download:    20000,
+		Reason:      conntrack.ReasonClosed,
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flow.jsonl")
+	e, err := New(&Config{Exporter: ExporterJSONL, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Export(testRecord())
+	e.Export(testRecord())
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+		record := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatal(err)
+		}
+		if record.UID != 12 || record.Email != "a@test.com" || record.Domain != "example.com" || record.Transport != "ws" || record.Download != 20000 {
+			t.Errorf("unexpected record: %+v", record)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("got %d records, want 2", lines)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	received := make(chan []Record, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var records []Record
+		json.NewDecoder(r.Body).Decode(&records)
+		received <- records
+	}))
+	defer server.Close()
+
+	e, err := New(&Config{Exporter: ExporterHTTP, URL: server.URL, Token: "secret", BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.Export(testRecord())
+	select {
+	case records := <-received:
+		if len(records) != 1 || records[0].Outbound != "direct" {
+			t.Errorf("unexpected records: %+v", records)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("records not posted")
+	}
+}
+
+func TestIPFIX(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+
+	e, err := New(&Config{Exporter: ExporterIPFIX, Collector: collector.LocalAddr().String(), DomainID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v6 := testRecord()
+	v6.Source = "tcp:[2001:db8::1]:5678"
+	e.Export(testRecord())
+	e.Export(v6)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 65535)
+	n, _, err := collector.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = b[:n]
+	if version := binary.BigEndian.Uint16(b); version != ipfixVersion {
+		t.Fatalf("got version %d", version)
+	}
+	if length := binary.BigEndian.Uint16(b[2:]); int(length) != n {
+		t.Fatalf("message length %d, received %d", length, n)
+	}
+	if domain := binary.BigEndian.Uint32(b[12:]); domain != 7 {
+		t.Errorf("got observation domain %d", domain)
+	}
+
+	// Walk the sets, the template set comes first
+	sets := make(map[uint16][]byte)
+	var order []uint16
+	for rest := b[ipfixHeaderSize:]; len(rest) > 0; {
+		id, length := binary.BigEndian.Uint16(rest), binary.BigEndian.Uint16(rest[2:])
+		if length < ipfixSetHeaderSize || int(length) > len(rest) {
+			t.Fatalf("invalid set length %d", length)
+		}
+		sets[id] = rest[ipfixSetHeaderSize:length]
+		order = append(order, id)
+		rest = rest[length:]
+	}
+	if len(order) != 3 || order[0] != ipfixTemplateSetID {
+		t.Fatalf("unexpected sets: %v", order)
+	}
+	if template := binary.BigEndian.Uint16(sets[ipfixTemplateSetID]); template != templateIPv4 {
+		t.Errorf("first template %d", template)
+	}
+
+	data := sets[templateIPv4]
+	if start := binary.BigEndian.Uint64(data); start != uint64(testRecord().Start.UnixMilli()) {
+		t.Errorf("got flow start %d", start)
+	}
+	if source := net.IP(data[16:20]); !source.Equal(net.ParseIP("1.2.3.4")) {
+		t.Errorf("got source %s", source)
+	}
+	if port := binary.BigEndian.Uint16(data[20:]); port != 5678 {
+		t.Errorf("got source port %d", port)
+	}
+	if protocol := data[28]; protocol != 6 {
+		t.Errorf("got protocol %d", protocol)
+	}
+	if upload, download := binary.BigEndian.Uint64(data[29:]), binary.BigEndian.Uint64(data[37:]); upload != 1000 || download != 20000 {
+		t.Errorf("got octets %d/%d", upload, download)
+	}
+	if reason := data[45]; reason != endOfFlowDetected {
+		t.Errorf("got end reason %d", reason)
+	}
+	// The strings follow the fixed fields, each behind its length
+	var fields []string
+	for rest := data[46:]; len(rest) > 0; {
+		fields = append(fields, string(rest[1:1+rest[0]]))
+		rest = rest[1+rest[0]:]
+	}
+	if want := []string{"a@test.com|12", "Vless_0.0.0.0_443", "tls", "ws", "direct", "example.com"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("got strings %q, want %q", fields, want)
+	}
+	if source := net.IP(sets[templateIPv6][16:32]); !source.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("got IPv6 source %s", source)
+	}
+}
+
+func TestUnsupportedExporter(t *testing.T) {
+	if _, err := New(&Config{Exporter: "netflow"}); err == nil {
+		t.Error("unsupported exporter accepted")
+	}
+	if _, err := New(&Config{Exporter: ExporterIPFIX}); err == nil {
+		t.Error("ipfix exporter without collector accepted")
+	}
+}
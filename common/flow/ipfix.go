@@ -0,0 +1,263 @@
+package flow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/xrayr/conntrack"
+)
+
+// IPFIX (RFC 7011) over UDP, the templates are sent along with the data records
+// in every message, so a collector restarted in between can decode them at once
+
+const (
+	ipfixVersion       = 10
+	ipfixHeaderSize    = 16
+	ipfixSetHeaderSize = 4
+	ipfixTemplateSetID = 2
+	ipfixMaxMessage    = 1400 // Fits in the MTU of most paths
+
+	// Template IDs, by the address families of the source and the destination
+	templateIPv4 = 256
+	templateIPv6 = 257
+)
+
+// Information elements of the IANA registry. The strings of a record have no
+// elements of their own, they are sent as the closest ones: the inbound tag as
+// interfaceName and its transport as interfaceDescription, the outbound tag as
+// VRFname and the sniffed domain as httpRequestHost
+const (
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieInterfaceName            = 82
+	ieInterfaceDescription     = 83
+	ieApplicationName          = 96
+	ieFlowEndReason            = 136
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+	ieInitiatorOctets          = 231
+	ieResponderOctets          = 232
+	ieVRFName                  = 236
+	ieUserName                 = 371
+	ieHTTPRequestHost          = 460
+
+	variableLength = 0xffff
+)
+
+// Values of flowEndReason
+const (
+	endOfFlowDetected = 3
+	forcedEnd         = 4
+)
+
+type fieldSpec struct {
+	id     uint16
+	length uint16
+}
+
+func templateFields(ipv6 bool) []fieldSpec {
+	source, destination, length := uint16(ieSourceIPv4Address), uint16(ieDestinationIPv4Address), uint16(4)
+	if ipv6 {
+		source, destination, length = ieSourceIPv6Address, ieDestinationIPv6Address, 16
+	}
+	return []fieldSpec{
+		{ieFlowStartMilliseconds, 8},
+		{ieFlowEndMilliseconds, 8},
+		{source, length},
+		{ieSourceTransportPort, 2},
+		{destination, length},
+		{ieDestinationTransportPort, 2},
+		{ieProtocolIdentifier, 1},
+		{ieInitiatorOctets, 8},
+		{ieResponderOctets, 8},
+		{ieFlowEndReason, 1},
+		{ieUserName, variableLength},
+		{ieInterfaceName, variableLength},
+		{ieApplicationName, variableLength},
+		{ieInterfaceDescription, variableLength},
+		{ieVRFName, variableLength},
+		{ieHTTPRequestHost, variableLength},
+	}
+}
+
+func encodeTemplate(id uint16) []byte {
+	fields := templateFields(id == templateIPv6)
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint16(b, f.id)
+		b = binary.BigEndian.AppendUint16(b, f.length)
+	}
+	return b
+}
+
+// splitDestination splits a destination like tcp:1.2.3.4:443 into its address and port
+func splitDestination(destination string) (net.IP, uint16) {
+	if i := strings.Index(destination, ":"); i >= 0 && !strings.HasPrefix(destination, "[") {
+		if network := destination[:i]; network == "tcp" || network == "udp" || network == "unix" {
+			destination = destination[i+1:]
+		}
+	}
+	host, port, err := net.SplitHostPort(destination)
+	if err != nil {
+		return nil, 0
+	}
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return net.ParseIP(host), uint16(p)
+}
+
+func appendString(b []byte, s string) []byte {
+	if len(s) < 255 {
+		b = append(b, byte(len(s)))
+	} else {
+		if len(s) > 0xffff {
+			s = s[:0xffff]
+		}
+		b = append(b, 255)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	}
+	return append(b, s...)
+}
+
+// encodeRecord returns the data record and the ID of its template
+func encodeRecord(r *Record) ([]byte, uint16) {
+	source, sourcePort := splitDestination(r.Source)
+	destination, destinationPort := splitDestination(r.Destination)
+	// Domains are not resolved, they are sent as the unspecified address
+	ipv6 := (source != nil && source.To4() == nil) || (destination != nil && destination.To4() == nil)
+	template, length := uint16(templateIPv4), 4
+	if ipv6 {
+		template, length = templateIPv6, 16
+	}
+	address := func(ip net.IP) []byte {
+		if ip == nil {
+			return make([]byte, length)
+		}
+		if ipv6 {
+			return ip.To16()
+		}
+		return ip.To4()
+	}
+	protocol := byte(6)
+	if r.Network == "udp" {
+		protocol = 17
+	}
+	reason := byte(forcedEnd)
+	if r.Reason == conntrack.ReasonClosed {
+		reason = endOfFlowDetected
+	}
+	user := r.Email
+	if r.UID > 0 {
+		user = fmt.Sprintf("%s|%d", r.Email, r.UID)
+	}
+
+	b := binary.BigEndian.AppendUint64(nil, uint64(r.Start.UnixMilli()))
+	b = binary.BigEndian.AppendUint64(b, uint64(r.End.UnixMilli()))
+	b = append(b, address(source)...)
+	b = binary.BigEndian.AppendUint16(b, sourcePort)
+	b = append(b, address(destination)...)
+	b = binary.BigEndian.AppendUint16(b, destinationPort)
+	b = append(b, protocol)
+	b = binary.BigEndian.AppendUint64(b, uint64(r.Upload))
+	b = binary.BigEndian.AppendUint64(b, uint64(r.Download))
+	b = append(b, reason)
+	b = appendString(b, user)
+	b = appendString(b, r.Tag)
+	b = appendString(b, r.Protocol)
+	b = appendString(b, r.Transport)
+	b = appendString(b, r.Outbound)
+	b = appendString(b, r.Domain)
+	return b, template
+}
+
+type ipfixSink struct {
+	conn     net.Conn
+	domainID uint32
+	sequence uint32
+}
+
+func newIPFIXSink(config *Config) (*ipfixSink, error) {
+	if config.Collector == "" {
+		return nil, fmt.Errorf("the ipfix flow exporter needs a Collector")
+	}
+	conn, err := net.Dial("udp", config.Collector)
+	if err != nil {
+		return nil, err
+	}
+	return &ipfixSink{conn: conn, domainID: config.DomainID}, nil
+}
+
+// message builds an IPFIX message of the data records, grouped by template
+func (s *ipfixSink) message(records map[uint16][][]byte, count int) []byte {
+	b := make([]byte, ipfixHeaderSize, ipfixMaxMessage)
+	binary.BigEndian.PutUint16(b[0:], ipfixVersion)
+	binary.BigEndian.PutUint32(b[4:], uint32(time.Now().Unix()))
+	binary.BigEndian.PutUint32(b[8:], s.sequence)
+	binary.BigEndian.PutUint32(b[12:], s.domainID)
+
+	appendSet := func(id uint16, records [][]byte) {
+		start := len(b)
+		b = binary.BigEndian.AppendUint16(b, id)
+		b = append(b, 0, 0)
+		for _, r := range records {
+			b = append(b, r...)
+		}
+		binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	}
+	var templates [][]byte
+	for _, id := range []uint16{templateIPv4, templateIPv6} {
+		if len(records[id]) > 0 {
+			templates = append(templates, encodeTemplate(id))
+		}
+	}
+	appendSet(ipfixTemplateSetID, templates)
+	for _, id := range []uint16{templateIPv4, templateIPv6} {
+		if len(records[id]) > 0 {
+			appendSet(id, records[id])
+		}
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	s.sequence += uint32(count)
+	return b
+}
+
+func (s *ipfixSink) write(records []*Record) error {
+	// Room taken by the header, the template set and the headers of the data sets
+	overhead := ipfixHeaderSize + ipfixSetHeaderSize*3 + len(encodeTemplate(templateIPv4)) + len(encodeTemplate(templateIPv6))
+	pending := make(map[uint16][][]byte)
+	count, size := 0, overhead
+	send := func() error {
+		if count == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(s.message(pending, count))
+		pending = make(map[uint16][][]byte)
+		count, size = 0, overhead
+		return err
+	}
+	for _, r := range records {
+		data, template := encodeRecord(r)
+		if size+len(data) > ipfixMaxMessage {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		pending[template] = append(pending[template], data)
+		count++
+		size += len(data)
+	}
+	return send()
+}
+
+func (s *ipfixSink) close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,91 @@
+package flow
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	xraylog "Xray-P/common/log"
+)
+
+// jsonlSink appends the records as JSON lines to a local file
+type jsonlSink struct {
+	w *xraylog.RotateWriter
+}
+
+func newJSONLSink(config *Config) (*jsonlSink, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("the jsonl flow exporter needs a Path")
+	}
+	w, err := xraylog.NewRotateWriter(config.Path, config.Rotate)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlSink{w: w}, nil
+}
+
+func (s *jsonlSink) write(records []*Record) error {
+	buffer := bufio.NewWriter(s.w)
+	encoder := json.NewEncoder(buffer)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+	return buffer.Flush()
+}
+
+func (s *jsonlSink) close() error {
+	return s.w.Close()
+}
+
+// httpSink posts the records as a JSON array
+type httpSink struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func newHTTPSink(config *Config) (*httpSink, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("the http flow exporter needs a URL")
+	}
+	return &httpSink{
+		client: &http.Client{Timeout: 30 * time.Second},
+		url:    config.URL,
+		token:  config.Token,
+	}, nil
+}
+
+func (s *httpSink) write(records []*Record) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("flow endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
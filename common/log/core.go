@@ -0,0 +1,206 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	applog "github.com/xtls/xray-core/app/log"
+	"github.com/xtls/xray-core/common"
+	xlog "github.com/xtls/xray-core/common/log"
+)
+
+// CoreConfig is the output of the Xray core error and access logs
+type CoreConfig struct {
+	Format string
+	Rotate *RotateConfig
+	// The access log has one entry per closed connection instead of one per request
+	ConnectionLog bool
+}
+
+// SetCoreLog sets the output of the Xray core error and access logs,
+// it takes effect on the next core instance created
+func SetCoreLog(config *CoreConfig) {
+	common.Must(applog.RegisterHandlerCreator(applog.LogType_Console, func(applog.LogType, applog.HandlerCreatorOptions) (xlog.Handler, error) {
+		if config.Format == FormatJSON {
+			return wrapHandler(config, &jsonHandler{out: os.Stdout}), nil
+		}
+		return wrapHandler(config, xlog.NewLogger(xlog.CreateStdoutLogWriter())), nil
+	}))
+	common.Must(applog.RegisterHandlerCreator(applog.LogType_File, func(_ applog.LogType, options applog.HandlerCreatorOptions) (xlog.Handler, error) {
+		w, err := NewRotateWriter(options.Path, config.Rotate)
+		if err != nil {
+			return nil, err
+		}
+		if config.Format == FormatJSON {
+			return wrapHandler(config, &jsonHandler{out: w}), nil
+		}
+		w.Close()
+		// The core logger reopens its writer once it has been idle
+		return wrapHandler(config, xlog.NewLogger(func() xlog.Writer {
+			w, err := NewRotateWriter(options.Path, config.Rotate)
+			if err != nil {
+				return nil
+			}
+			return &textWriter{out: w, logger: stdlog.New(w, "", stdlog.Ldate|stdlog.Ltime|stdlog.Lmicroseconds)}
+		})), nil
+	}))
+}
+
+func wrapHandler(config *CoreConfig, handler xlog.Handler) xlog.Handler {
+	if config.ConnectionLog {
+		return &connectionHandler{Handler: handler}
+	}
+	return handler
+}
+
+// connectionHandler drops the accepted requests from the access log,
+// as the closed connections are logged instead
+type connectionHandler struct {
+	xlog.Handler
+}
+
+func (h *connectionHandler) Handle(msg xlog.Message) {
+	inner := msg
+	if masked, ok := msg.(*applog.MaskedMsgWrapper); ok {
+		inner = masked.Message
+	}
+	if access, ok := inner.(*xlog.AccessMessage); ok && access.Status == xlog.AccessAccepted {
+		return
+	}
+	h.Handler.Handle(msg)
+}
+
+func (h *connectionHandler) Close() error {
+	return common.Close(h.Handler)
+}
+
+// textWriter writes the core logs in the format of the core file logger
+type textWriter struct {
+	out    io.WriteCloser
+	logger *stdlog.Logger
+}
+
+func (w *textWriter) Write(s string) error {
+	w.logger.Print(s)
+	return nil
+}
+
+func (w *textWriter) Close() error {
+	return w.out.Close()
+}
+
+// jsonHandler writes the core logs as JSON lines with the fields of the logrus JSON formatter
+type jsonHandler struct {
+	access sync.Mutex
+	out    io.Writer
+}
+
+func (h *jsonHandler) Handle(msg xlog.Message) {
+	data, err := json.Marshal(coreFields(msg))
+	if err != nil {
+		return
+	}
+	h.access.Lock()
+	defer h.access.Unlock()
+	h.out.Write(append(data, '\n'))
+}
+
+func (h *jsonHandler) Close() error {
+	if closer, ok := h.out.(io.Closer); ok && h.out != os.Stdout {
+		return closer.Close()
+	}
+	return nil
+}
+
+func coreFields(msg xlog.Message) logrus.Fields {
+	fields := logrus.Fields{
+		logrus.FieldKeyTime:  time.Now().Format(time.RFC3339),
+		logrus.FieldKeyLevel: logrus.InfoLevel.String(),
+		FieldSource:          "xray",
+	}
+	switch msg := msg.(type) {
+	case *xlog.GeneralMessage:
+		fields[logrus.FieldKeyLevel] = severityLevel(msg.Severity).String()
+		content, ok := msg.Content.(*xlog.FieldsContent)
+		if !ok {
+			fields[logrus.FieldKeyMsg] = fmt.Sprint(msg.Content)
+			break
+		}
+		for k, v := range content.Fields {
+			switch v := v.(type) {
+			case error:
+				fields[k] = v.Error()
+			default:
+				fields[k] = v
+			}
+		}
+		// The email of the core is the user tag
+		if email, ok := content.Fields[FieldEmail].(string); ok {
+			for k, v := range UserFields(email) {
+				fields[k] = v
+			}
+		}
+		fields[logrus.FieldKeyMsg] = content.Message
+	case *xlog.ConnectionMessage:
+		for k, v := range UserFields(msg.Email) {
+			fields[k] = v
+		}
+		fields[logrus.FieldKeyMsg] = "connection"
+		fields[FieldNodeTag] = msg.Tag
+		fields["from"] = msg.From
+		fields["to"] = msg.To
+		if msg.Domain != "" {
+			fields["domain"] = msg.Domain
+		}
+		if msg.Protocol != "" {
+			fields["protocol"] = msg.Protocol
+		}
+		if msg.Detour != "" {
+			fields["detour"] = msg.Detour
+		}
+		fields["upload"] = msg.Upload
+		fields["download"] = msg.Download
+		fields[FieldDuration] = msg.Duration.String()
+		fields["reason"] = msg.Reason
+	case *xlog.AccessMessage:
+		for k, v := range UserFields(msg.Email) {
+			fields[k] = v
+		}
+		fields[logrus.FieldKeyMsg] = "access"
+		fields["from"] = fmt.Sprint(msg.From)
+		fields["to"] = fmt.Sprint(msg.To)
+		fields["status"] = string(msg.Status)
+		if msg.Reason != nil {
+			fields["reason"] = fmt.Sprint(msg.Reason)
+		}
+		if msg.Detour != "" {
+			fields["detour"] = msg.Detour
+		}
+		if msg.Email == "" {
+			delete(fields, FieldEmail)
+		}
+	default:
+		// DNS logs, and the masked messages which must not leak their fields
+		fields[logrus.FieldKeyMsg] = msg.String()
+	}
+	return fields
+}
+
+func severityLevel(severity xlog.Severity) logrus.Level {
+	switch severity {
+	case xlog.Severity_Error:
+		return logrus.ErrorLevel
+	case xlog.Severity_Warning:
+		return logrus.WarnLevel
+	case xlog.Severity_Debug:
+		return logrus.DebugLevel
+	default:
+		return logrus.InfoLevel
+	}
+}
@@ -0,0 +1,53 @@
+package log
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Names of the structured fields shared by every log
+const (
+	FieldPanel    = "panel"
+	FieldNodeID   = "node_id"
+	FieldNodeType = "node_type"
+	FieldNodeTag  = "node_tag"
+	FieldUID      = "uid"
+	FieldEmail    = "email"
+	FieldEndpoint = "endpoint"
+	FieldDuration = "duration"
+	FieldSource   = "source"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	AccessModeRequest    = "request"
+	AccessModeConnection = "connection"
+)
+
+// NewFormatter returns the formatter of the log format, text by default
+func NewFormatter(format string) logrus.Formatter {
+	if format == FormatJSON {
+		return &logrus.JSONFormatter{}
+	}
+	return &ModernFormatter{}
+}
+
+// UserFields splits a user tag like NodeTag|email|uid into log fields
+func UserFields(userTag string) logrus.Fields {
+	parts := strings.Split(userTag, "|")
+	if len(parts) < 3 {
+		return logrus.Fields{FieldEmail: userTag}
+	}
+	fields := logrus.Fields{
+		FieldNodeTag: parts[0],
+		FieldEmail:   strings.Join(parts[1:len(parts)-1], "|"),
+	}
+	if uid, err := strconv.Atoi(parts[len(parts)-1]); err == nil {
+		fields[FieldUID] = uid
+	}
+	return fields
+}
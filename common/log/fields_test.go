@@ -0,0 +1,70 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	xlog "github.com/xtls/xray-core/common/log"
+)
+
+func TestUserFields(t *testing.T) {
+	fields := UserFields("Vless_0.0.0.0_443|a|b@example.com|12")
+	if fields[FieldNodeTag] != "Vless_0.0.0.0_443" || fields[FieldEmail] != "a|b@example.com" || fields[FieldUID] != 12 {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if fields := UserFields("user@example.com"); len(fields) != 1 || fields[FieldEmail] != "user@example.com" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+}
+
+func TestCoreFields(t *testing.T) {
+	fields := coreFields(&xlog.GeneralMessage{
+		Severity: xlog.Severity_Warning,
+		Content: &xlog.FieldsContent{
+			Message: "Devices reach the limit",
+			Fields:  xlog.Fields{"email": "Trojan_0.0.0.0_443|user@example.com|3", "ip": "1.2.3.4"},
+		},
+	})
+	for k, v := range map[string]interface{}{
+		"level":      "warning",
+		"msg":        "Devices reach the limit",
+		"source":     "xray",
+		"ip":         "1.2.3.4",
+		FieldEmail:   "user@example.com",
+		FieldUID:     3,
+		FieldNodeTag: "Trojan_0.0.0.0_443",
+	} {
+		if fields[k] != v {
+			t.Errorf("field %s: got %v, want %v", k, fields[k], v)
+		}
+	}
+
+	fields = coreFields(&xlog.ConnectionMessage{
+		From:     "tcp:1.2.3.4:5678",
+		To:       "tcp:5.6.7.8:443",
+		Domain:   "example.com",
+		Tag:      "Trojan_0.0.0.0_443",
+		Email:    "Trojan_0.0.0.0_443|user@example.com|3",
+		Upload:   100,
+		Download: 2000,
+		Duration: 1500 * time.Millisecond,
+		Reason:   "closed",
+	})
+	for k, v := range map[string]interface{}{
+		"msg":         "connection",
+		"domain":      "example.com",
+		"upload":      int64(100),
+		"download":    int64(2000),
+		FieldDuration: "1.5s",
+		FieldUID:      3,
+	} {
+		if fields[k] != v {
+			t.Errorf("connection field %s: got %v, want %v", k, fields[k], v)
+		}
+	}
+
+	fields = coreFields(&xlog.AccessMessage{From: "1.2.3.4:5678", To: "tcp:example.com:443", Status: xlog.AccessAccepted})
+	if fields["msg"] != "access" || fields["status"] != "accepted" || fields[FieldEmail] != nil {
+		t.Errorf("unexpected access fields: %v", fields)
+	}
+}
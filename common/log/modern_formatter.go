@@ -3,6 +3,7 @@ package log
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -33,6 +34,7 @@ func (f *ModernFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	levelStyle := lipgloss.NewStyle().Bold(true)
 	msgStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("252"))
 	callerStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("243")).Italic(true)
+	fieldStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("245"))
 
 	// Level Color
 	var levelStr string
@@ -56,6 +58,21 @@ func (f *ModernFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	// Message
 	msg := msgStyle.Render(entry.Message)
 
+	// Fields
+	var fieldsStr string
+	if len(entry.Data) > 0 {
+		keys := make([]string, 0, len(entry.Data))
+		for k := range entry.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields := make([]string, 0, len(keys))
+		for _, k := range keys {
+			fields = append(fields, fmt.Sprintf("%s=%v", k, entry.Data[k]))
+		}
+		fieldsStr = " " + fieldStyle.Render(strings.Join(fields, " "))
+	}
+
 	// Caller (if enabled)
 	var callerStr string
 	if entry.HasCaller() {
@@ -71,7 +88,7 @@ func (f *ModernFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	}
 
 	// Write to buffer
-	fmt.Fprintf(b, "%s %s %s%s\n", timeStr, levelStr, msg, callerStr)
+	fmt.Fprintf(b, "%s %s %s%s%s\n", timeStr, levelStr, msg, fieldsStr, callerStr)
 
 	return b.Bytes(), nil
 }
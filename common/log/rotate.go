@@ -0,0 +1,248 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	IntervalHourly = "hourly"
+	IntervalDaily  = "daily"
+
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	compressSuffix   = ".gz"
+)
+
+// RotateConfig is the rotation of the log files, nothing is rotated when empty
+type RotateConfig struct {
+	MaxSize    int    `mapstructure:"MaxSize"`    // Megabytes a file can grow to before it is rotated, 0 for no limit
+	Interval   string `mapstructure:"Interval"`   // Rotate every hour or day as well: hourly, daily
+	MaxBackups int    `mapstructure:"MaxBackups"` // Number of rotated files to keep, 0 to keep them all
+	MaxAge     int    `mapstructure:"MaxAge"`     // Days to keep rotated files, 0 to keep them forever
+	Compress   bool   `mapstructure:"Compress"`   // Gzip the rotated files
+}
+
+func (c *RotateConfig) Validate() error {
+	if c.MaxSize < 0 || c.MaxBackups < 0 || c.MaxAge < 0 {
+		return fmt.Errorf("negative log rotation limits")
+	}
+	switch c.Interval {
+	case "", IntervalHourly, IntervalDaily:
+		return nil
+	default:
+		return fmt.Errorf("unsupported log rotation interval: %s", c.Interval)
+	}
+}
+
+// RotateWriter appends to a log file and rotates it to path-<time>.ext
+// once it grows over the maximum size or the interval ends
+type RotateWriter struct {
+	access sync.Mutex
+	path   string
+	config RotateConfig
+	file   *os.File
+	size   int64
+	period time.Time
+	now    func() time.Time
+	mill   sync.WaitGroup
+}
+
+// NewRotateWriter opens the log file at path
+func NewRotateWriter(path string, config *RotateConfig) (*RotateWriter, error) {
+	w := &RotateWriter{path: path, now: time.Now}
+	if config != nil {
+		w.config = *config
+	}
+	if err := w.config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotateWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	// A file left by a previous run belongs to the period it was last written in
+	w.period = w.periodOf(w.now())
+	if w.size > 0 {
+		w.period = w.periodOf(info.ModTime())
+	}
+	return nil
+}
+
+// periodOf returns the start of the interval t is in
+func (w *RotateWriter) periodOf(t time.Time) time.Time {
+	switch w.config.Interval {
+	case IntervalHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case IntervalDaily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.access.Lock()
+	defer w.access.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	maxSize := int64(w.config.MaxSize) * 1024 * 1024
+	if (maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > maxSize) || !w.periodOf(w.now()).Equal(w.period) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the log file now
+func (w *RotateWriter) Rotate() error {
+	w.access.Lock()
+	defer w.access.Unlock()
+	return w.rotate()
+}
+
+func (w *RotateWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+	if w.size > 0 {
+		if err := os.Rename(w.path, w.backupName(w.now())); err != nil {
+			return err
+		}
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	cutoff := w.now().AddDate(0, 0, -w.config.MaxAge)
+	w.mill.Add(1)
+	go func() {
+		defer w.mill.Done()
+		w.compressAndPrune(cutoff)
+	}()
+	return nil
+}
+
+func (w *RotateWriter) backupName(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(w.path, ext), t.Format(backupTimeFormat), ext)
+}
+
+type backup struct {
+	path string
+	time time.Time
+}
+
+// backups returns the rotated files of the log, newest first
+func (w *RotateWriter) backups() ([]backup, error) {
+	dir := filepath.Dir(w.path)
+	ext := filepath.Ext(w.path)
+	prefix := strings.TrimSuffix(filepath.Base(w.path), ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), compressSuffix)
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, e.Name()), time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+	return backups, nil
+}
+
+var millAccess sync.Mutex
+
+// compressAndPrune compresses the rotated files, and removes the ones over
+// the maximum number or older than cutoff
+func (w *RotateWriter) compressAndPrune(cutoff time.Time) {
+	millAccess.Lock()
+	defer millAccess.Unlock()
+
+	backups, err := w.backups()
+	if err != nil {
+		return
+	}
+	for i, b := range backups {
+		if (w.config.MaxBackups > 0 && i >= w.config.MaxBackups) || (w.config.MaxAge > 0 && b.time.Before(cutoff)) {
+			os.Remove(b.path)
+			continue
+		}
+		if w.config.Compress && !strings.HasSuffix(b.path, compressSuffix) {
+			if err := compressFile(b.path); err == nil {
+				os.Remove(b.path)
+			}
+		}
+	}
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+compressSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + compressSuffix)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + compressSuffix)
+		return err
+	}
+	return dst.Close()
+}
+
+// Close closes the log file, and waits for the rotated files to be compressed
+func (w *RotateWriter) Close() error {
+	w.access.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.access.Unlock()
+	w.mill.Wait()
+	return err
+}
@@ -0,0 +1,82 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	w, err := NewRotateWriter(path, &RotateConfig{MaxSize: 1, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+	w.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	line := []byte(strings.Repeat("x", 1023) + "\n")
+	// 4 files of 1 MB, the first one is pruned
+	for i := 0; i < 4*1024+1; i++ {
+		if _, err := w.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got %d backups, want 2", len(backups))
+	}
+	for _, b := range backups {
+		if !strings.HasSuffix(b.path, ".log.gz") {
+			t.Errorf("backup not compressed: %s", b.path)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(len(line)) {
+		t.Errorf("current log not rotated: %v %v", info.Size(), err)
+	}
+}
+
+func TestRotateInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "error.log")
+	w, err := NewRotateWriter(path, &RotateConfig{Interval: IntervalDaily})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	now := time.Date(2026, 10, 18, 23, 59, 0, 0, time.Local)
+	w.now = func() time.Time { return now }
+	w.period = w.periodOf(now)
+
+	w.Write([]byte("first\n"))
+	now = now.Add(2 * time.Minute)
+	w.Write([]byte("second\n"))
+	w.mill.Wait()
+
+	backups, _ := w.backups()
+	if len(backups) != 1 {
+		t.Fatalf("got %d backups, want 1", len(backups))
+	}
+	if data, _ := os.ReadFile(backups[0].path); string(data) != "first\n" {
+		t.Errorf("unexpected backup: %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "second\n" {
+		t.Errorf("unexpected log: %q", data)
+	}
+
+	if _, err := NewRotateWriter(path, &RotateConfig{Interval: "weekly"}); err == nil {
+		t.Error("unsupported interval accepted")
+	}
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"io"
+	"time"
+
+	"Xray-P/api"
+)
+
+// instrumentedAPI records the latency and errors of every panel request
+type instrumentedAPI struct {
+	api.API
+	node *Node
+}
+
+// WrapAPI returns an api.API recording request metrics for the node
+func WrapAPI(a api.API) api.API {
+	return &instrumentedAPI{API: a, node: newNode(a.Describe())}
+}
+
+func (a *instrumentedAPI) observe(endpoint string, start time.Time, err error) {
+	// Not modified is the normal answer when nothing changed
+	if err != nil {
+		switch err.Error() {
+		case api.NodeNotModified, api.UserNotModified, api.RuleNotModified:
+			err = nil
+		}
+	}
+	a.node.ObserveRequest(endpoint, time.Since(start), err)
+}
+
+func (a *instrumentedAPI) GetNodeInfo() (nodeInfo *api.NodeInfo, err error) {
+	start := time.Now()
+	nodeInfo, err = a.API.GetNodeInfo()
+	a.observe("GetNodeInfo", start, err)
+	return
+}
+
+func (a *instrumentedAPI) GetUserList() (userList *[]api.UserInfo, err error) {
+	start := time.Now()
+	userList, err = a.API.GetUserList()
+	a.observe("GetUserList", start, err)
+	return
+}
+
+func (a *instrumentedAPI) ReportNodeStatus(nodeStatus *api.NodeStatus) (err error) {
+	start := time.Now()
+	err = a.API.ReportNodeStatus(nodeStatus)
+	a.observe("ReportNodeStatus", start, err)
+	return
+}
+
+func (a *instrumentedAPI) ReportNodeOnlineUsers(onlineUser *[]api.OnlineUser) (err error) {
+	start := time.Now()
+	err = a.API.ReportNodeOnlineUsers(onlineUser)
+	a.observe("ReportNodeOnlineUsers", start, err)
+	return
+}
+
+func (a *instrumentedAPI) ReportUserTraffic(userTraffic *[]api.UserTraffic) (err error) {
+	start := time.Now()
+	err = a.API.ReportUserTraffic(userTraffic)
+	a.observe("ReportUserTraffic", start, err)
+	return
+}
+
+func (a *instrumentedAPI) GetNodeRule() (ruleList *[]api.DetectRule, err error) {
+	start := time.Now()
+	ruleList, err = a.API.GetNodeRule()
+	a.observe("GetNodeRule", start, err)
+	return
+}
+
+func (a *instrumentedAPI) ReportIllegal(detectResultList *[]api.DetectResult) (err error) {
+	start := time.Now()
+	err = a.API.ReportIllegal(detectResultList)
+	a.observe("ReportIllegal", start, err)
+	return
+}
+
+// Close closes the wrapped api if it holds resources
+func (a *instrumentedAPI) Close() error {
+	if closer, ok := a.API.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+// Package metrics exposes node, panel and limiter metrics in the Prometheus text format
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+
+	"Xray-P/api"
+)
+
+const namespace = "xrayp"
+
+type Config struct {
+	Enable  bool   `mapstructure:"Enable"`
+	Listen  string `mapstructure:"Listen"`
+	Path    string `mapstructure:"Path"`
+	PerUser bool   `mapstructure:"PerUser"` // per-user series, may produce a lot of series on big nodes
+}
+
+const (
+	RejectDeviceLimit = "device_limit"
+	RejectAuditRule   = "audit_rule"
+	RejectAbuseGuard  = "abuse_guard"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+	perUser  atomic.Bool
+
+	nodesAccess sync.Mutex
+	// Key: panel and node ID, Value: open nodes recording the series. The node of a core
+	// being replaced records the series with the node of the new core until it is closed.
+	nodes = make(map[string]int)
+
+	nodeLabels = []string{"panel", "node_id"}
+
+	panelRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "panel_request_duration_seconds",
+		Help:      "Latency of panel API requests.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, append(nodeLabels, "endpoint"))
+	panelRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panel_request_errors_total",
+		Help:      "Failed panel API requests.",
+	}, append(nodeLabels, "endpoint"))
+	nodeTraffic = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_traffic_bytes_total",
+		Help:      "User traffic through the node inbound.",
+	}, append(nodeLabels, "direction"))
+	nodeConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_connections_total",
+		Help:      "User connections accepted by the node inbound.",
+	}, nodeLabels)
+	nodeOnlineUsers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_online_users",
+		Help:      "Users online in the last reporting period.",
+	}, nodeLabels)
+	nodeOnlineIPs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_online_ips",
+		Help:      "User IPs online in the last reporting period.",
+	}, nodeLabels)
+	nodeUsers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_users",
+		Help:      "Users configured on the node.",
+	}, nodeLabels)
+	rejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rejections_total",
+		Help:      "Connections rejected by the device limit, audit rules or the abuse guard.",
+	}, append(nodeLabels, "reason"))
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "periodic_task_duration_seconds",
+		Help:      "Duration of the controller periodic tasks.",
+		Buckets:   []float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, append(nodeLabels, "task"))
+	taskLastRun = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "periodic_task_last_run_timestamp_seconds",
+		Help:      "Unix time the controller periodic task last finished.",
+	}, append(nodeLabels, "task"))
+	certExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "Unix time the node TLS certificate expires.",
+	}, append(nodeLabels, "domain"))
+	certFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_renewal_failures",
+		Help:      "Renewals of the node TLS certificate failed in a row.",
+	}, append(nodeLabels, "domain"))
+	userTraffic = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_traffic_bytes_total",
+		Help:      "Traffic of each user, only exported when PerUser is enabled.",
+	}, append(nodeLabels, "uid", "direction"))
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		panelRequestDuration,
+		panelRequestErrors,
+		nodeTraffic,
+		nodeConnections,
+		nodeOnlineUsers,
+		nodeOnlineIPs,
+		nodeUsers,
+		rejections,
+		taskDuration,
+		taskLastRun,
+		certExpiry,
+		certFailures,
+		userTraffic,
+	)
+}
+
+// SetPerUser enables or disables the per-user series
+func SetPerUser(enable bool) {
+	perUser.Store(enable)
+	if !enable {
+		userTraffic.Reset()
+	}
+}
+
+// Node records the metrics of one panel node
+type Node struct {
+	labels  prometheus.Labels
+	values  []string
+	deleted atomic.Bool
+}
+
+// NewNode returns the metrics of the node described by clientInfo
+func NewNode(clientInfo api.ClientInfo) *Node {
+	n := newNode(clientInfo)
+	nodesAccess.Lock()
+	nodes[n.key()]++
+	nodesAccess.Unlock()
+	return n
+}
+
+// newNode returns the metrics of the node without keeping its series, the node
+// deleting them is the one of NewNode
+func newNode(clientInfo api.ClientInfo) *Node {
+	nodeID := strconv.Itoa(clientInfo.NodeID)
+	return &Node{
+		labels: prometheus.Labels{"panel": clientInfo.APIHost, "node_id": nodeID},
+		values: []string{clientInfo.APIHost, nodeID},
+	}
+}
+
+func (n *Node) key() string {
+	return n.values[0] + "\x00" + n.values[1]
+}
+
+func (n *Node) with(values ...string) []string {
+	return append(append([]string{}, n.values...), values...)
+}
+
+func (n *Node) ObserveRequest(endpoint string, duration time.Duration, err error) {
+	panelRequestDuration.WithLabelValues(n.with(endpoint)...).Observe(duration.Seconds())
+	if err != nil {
+		panelRequestErrors.WithLabelValues(n.with(endpoint)...).Inc()
+	}
+}
+
+func (n *Node) AddTraffic(up, down int64) {
+	nodeTraffic.WithLabelValues(n.with("uplink")...).Add(float64(up))
+	nodeTraffic.WithLabelValues(n.with("downlink")...).Add(float64(down))
+}
+
+func (n *Node) AddUserTraffic(uid int, up, down int64) {
+	if !perUser.Load() {
+		return
+	}
+	userTraffic.WithLabelValues(n.with(strconv.Itoa(uid), "uplink")...).Add(float64(up))
+	userTraffic.WithLabelValues(n.with(strconv.Itoa(uid), "downlink")...).Add(float64(down))
+}
+
+func (n *Node) AddConnections(count int64) {
+	nodeConnections.WithLabelValues(n.values...).Add(float64(count))
+}
+
+func (n *Node) AddRejections(reason string, count int64) {
+	rejections.WithLabelValues(n.with(reason)...).Add(float64(count))
+}
+
+func (n *Node) SetOnline(users, ips int) {
+	nodeOnlineUsers.WithLabelValues(n.values...).Set(float64(users))
+	nodeOnlineIPs.WithLabelValues(n.values...).Set(float64(ips))
+}
+
+func (n *Node) SetUsers(users int) {
+	nodeUsers.WithLabelValues(n.values...).Set(float64(users))
+}
+
+func (n *Node) ObserveTask(task string, duration time.Duration) {
+	taskDuration.WithLabelValues(n.with(task)...).Observe(duration.Seconds())
+	taskLastRun.WithLabelValues(n.with(task)...).SetToCurrentTime()
+}
+
+func (n *Node) SetCertExpiry(domain string, notAfter time.Time) {
+	certExpiry.WithLabelValues(n.with(domain)...).Set(float64(notAfter.Unix()))
+}
+
+func (n *Node) SetCertFailures(domain string, failures int) {
+	certFailures.WithLabelValues(n.with(domain)...).Set(float64(failures))
+}
+
+// Delete removes every series of the node, used when the node is closed. The series are
+// kept while another node records them.
+func (n *Node) Delete() {
+	if n.deleted.Swap(true) {
+		return
+	}
+	nodesAccess.Lock()
+	defer nodesAccess.Unlock()
+	if nodes[n.key()]--; nodes[n.key()] > 0 {
+		return
+	}
+	delete(nodes, n.key())
+	for _, v := range []interface{ DeletePartialMatch(prometheus.Labels) int }{
+		panelRequestDuration, panelRequestErrors, nodeTraffic, nodeConnections,
+		nodeOnlineUsers, nodeOnlineIPs, nodeUsers, rejections, taskDuration,
+		taskLastRun, certExpiry, certFailures, userTraffic,
+	} {
+		v.DeletePartialMatch(n.labels)
+	}
+}
@@ -0,0 +1,83 @@
+package metrics_test
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Xray-P/api"
+	"Xray-P/common/metrics"
+)
+
+type fakeAPI struct {
+	api.API
+}
+
+func (fakeAPI) Describe() api.ClientInfo {
+	return api.ClientInfo{APIHost: "http://panel", NodeID: 41}
+}
+
+func (fakeAPI) GetNodeInfo() (*api.NodeInfo, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (fakeAPI) GetUserList() (*[]api.UserInfo, error) {
+	return nil, errors.New(api.UserNotModified)
+}
+
+func scrape(t *testing.T) string {
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	client := metrics.WrapAPI(fakeAPI{})
+	client.GetNodeInfo()
+	client.GetUserList()
+
+	node := metrics.NewNode(client.Describe())
+	node.AddTraffic(100, 200)
+	node.AddRejections(metrics.RejectDeviceLimit, 3)
+	metrics.SetPerUser(false)
+	node.AddUserTraffic(1, 10, 20)
+
+	body := scrape(t)
+	for _, want := range []string{
+		`xrayp_panel_request_errors_total{endpoint="GetNodeInfo",node_id="41",panel="http://panel"} 1`,
+		`xrayp_panel_request_duration_seconds_count{endpoint="GetUserList",node_id="41",panel="http://panel"} 1`,
+		`xrayp_node_traffic_bytes_total{direction="downlink",node_id="41",panel="http://panel"} 200`,
+		`xrayp_rejections_total{node_id="41",panel="http://panel",reason="device_limit"} 3`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	// Not modified is not an error
+	if strings.Contains(body, `xrayp_panel_request_errors_total{endpoint="GetUserList"`) {
+		t.Error("not modified counted as an error")
+	}
+	if strings.Contains(body, "xrayp_user_traffic_bytes_total{") {
+		t.Error("per-user series exported while disabled")
+	}
+
+	metrics.SetPerUser(true)
+	node.AddUserTraffic(1, 10, 20)
+	if !strings.Contains(scrape(t), `xrayp_user_traffic_bytes_total{direction="uplink",node_id="41",panel="http://panel",uid="1"} 10`) {
+		t.Error("per-user series missing")
+	}
+
+	// The node of a new core records the series with the node it replaces
+	replacing := metrics.NewNode(client.Describe())
+	node.Delete()
+	if !strings.Contains(scrape(t), `xrayp_node_traffic_bytes_total{direction="downlink",node_id="41",panel="http://panel"} 200`) {
+		t.Error("series deleted while another node records them")
+	}
+	replacing.Delete()
+	if strings.Contains(scrape(t), `node_id="41"`) {
+		t.Error("node series not deleted")
+	}
+}
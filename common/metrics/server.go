@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultListen = "127.0.0.1:9100"
+	defaultPath   = "/metrics"
+)
+
+// Server serves the metrics endpoint
+type Server struct {
+	server *http.Server
+}
+
+// Start listens on config.Listen and serves the metrics on config.Path
+func Start(config *Config) (*Server, error) {
+	listen, path := config.Listen, config.Path
+	if listen == "" {
+		listen = defaultListen
+	}
+	if path == "" {
+		path = defaultPath
+	}
+	SetPerUser(config.PerUser)
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, Handler())
+	s := &Server{server: &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Metrics server stopped: %s", err)
+		}
+	}()
+	log.Printf("Metrics listening on http://%s%s", listener.Addr(), path)
+	return s, nil
+}
+
+// Handler returns the HTTP handler serving the metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -14,9 +15,6 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/go-acme/lego/v4/certcrypto"
-	"github.com/go-acme/lego/v4/lego"
-	"github.com/go-acme/lego/v4/registration"
-	"golang.org/x/crypto/acme"
 )
 
 const (
@@ -67,12 +65,16 @@ type AccountsStorage struct {
 }
 
 // NewAccountsStorage Creates a new AccountsStorage.
-func NewAccountsStorage(l *LegoCMD) *AccountsStorage {
+func NewAccountsStorage(l *LegoCMD) (*AccountsStorage, error) {
 	email := l.C.Email
 
-	serverURL, err := url.Parse(acme.LetsEncryptURL)
+	caDirURL, err := l.C.CADirURL()
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	serverURL, err := url.Parse(caDirURL)
+	if err != nil {
+		return nil, err
 	}
 
 	rootPath := filepath.Join(l.path, baseAccountsRootFolderName)
@@ -86,17 +88,17 @@ func NewAccountsStorage(l *LegoCMD) *AccountsStorage {
 		rootUserPath:    rootUserPath,
 		keysPath:        filepath.Join(rootUserPath, baseKeysFolderName),
 		accountFilePath: filepath.Join(rootUserPath, accountFileName),
-	}
+	}, nil
 }
 
-func (s *AccountsStorage) ExistsAccountFilePath() bool {
+func (s *AccountsStorage) ExistsAccountFilePath() (bool, error) {
 	accountFile := filepath.Join(s.rootUserPath, accountFileName)
 	if _, err := os.Stat(accountFile); os.IsNotExist(err) {
-		return false
+		return false, nil
 	} else if err != nil {
-		log.Panic(err)
+		return false, err
 	}
-	return true
+	return true, nil
 }
 
 func (s *AccountsStorage) GetRootPath() string {
@@ -120,64 +122,54 @@ func (s *AccountsStorage) Save(account *Account) error {
 	return os.WriteFile(s.accountFilePath, jsonBytes, filePerm)
 }
 
-func (s *AccountsStorage) LoadAccount(privateKey crypto.PrivateKey) *Account {
+func (s *AccountsStorage) LoadAccount(privateKey crypto.PrivateKey) (*Account, error) {
 	fileBytes, err := os.ReadFile(s.accountFilePath)
 	if err != nil {
-		log.Panicf("Could not load file for account %s: %v", s.userID, err)
+		return nil, fmt.Errorf("could not load file for account %s: %v", s.userID, err)
 	}
 
 	var account Account
 	err = json.Unmarshal(fileBytes, &account)
 	if err != nil {
-		log.Panicf("Could not parse file for account %s: %v", s.userID, err)
+		return nil, fmt.Errorf("could not parse file for account %s: %v", s.userID, err)
 	}
 
 	account.key = privateKey
 
-	if account.Registration == nil || account.Registration.Body.Status == "" {
-		reg, err := tryRecoverRegistration(privateKey)
-		if err != nil {
-			log.Panicf("Could not load account for %s. Registration is nil: %#v", s.userID, err)
-		}
-
-		account.Registration = reg
-		err = s.Save(&account)
-		if err != nil {
-			log.Panicf("Could not save account for %s. Registration is nil: %#v", s.userID, err)
-		}
-	}
-
-	return &account
+	return &account, nil
 }
 
-func (s *AccountsStorage) GetPrivateKey(keyType certcrypto.KeyType) crypto.PrivateKey {
+func (s *AccountsStorage) GetPrivateKey(keyType certcrypto.KeyType) (crypto.PrivateKey, error) {
 	accKeyPath := filepath.Join(s.keysPath, s.userID+".key")
 
 	if _, err := os.Stat(accKeyPath); os.IsNotExist(err) {
 		log.Printf("No key found for account %s. Generating a %s key.", s.userID, keyType)
-		s.createKeysFolder()
+		if err := s.createKeysFolder(); err != nil {
+			return nil, err
+		}
 
 		privateKey, err := generatePrivateKey(accKeyPath, keyType)
 		if err != nil {
-			log.Panicf("Could not generate RSA private account key for account %s: %v", s.userID, err)
+			return nil, fmt.Errorf("could not generate private account key for account %s: %v", s.userID, err)
 		}
 
 		log.Printf("Saved key to %s", accKeyPath)
-		return privateKey
+		return privateKey, nil
 	}
 
 	privateKey, err := loadPrivateKey(accKeyPath)
 	if err != nil {
-		log.Panicf("Could not load RSA private key from file %s: %v", accKeyPath, err)
+		return nil, fmt.Errorf("could not load private key from file %s: %v", accKeyPath, err)
 	}
 
-	return privateKey
+	return privateKey, nil
 }
 
-func (s *AccountsStorage) createKeysFolder() {
+func (s *AccountsStorage) createKeysFolder() error {
 	if err := createNonExistingFolder(s.keysPath); err != nil {
-		log.Panicf("Could not check/create directory for account %s: %v", s.userID, err)
+		return fmt.Errorf("could not check/create directory for account %s: %v", s.userID, err)
 	}
+	return nil
 }
 
 func generatePrivateKey(file string, keyType certcrypto.KeyType) (crypto.PrivateKey, error) {
@@ -208,6 +200,9 @@ func loadPrivateKey(file string) (crypto.PrivateKey, error) {
 	}
 
 	keyBlock, _ := pem.Decode(keyBytes)
+	if keyBlock == nil {
+		return nil, errors.New("no PEM data found")
+	}
 
 	switch keyBlock.Type {
 	case "RSA PRIVATE KEY":
@@ -218,21 +213,3 @@ func loadPrivateKey(file string) (crypto.PrivateKey, error) {
 
 	return nil, errors.New("unknown private key type")
 }
-
-func tryRecoverRegistration(privateKey crypto.PrivateKey) (*registration.Resource, error) {
-	// couldn't load account but got a key. Try to look the account up.
-	config := lego.NewConfig(&Account{key: privateKey})
-	config.CADirURL = acme.LetsEncryptURL
-	config.UserAgent = "lego-cli/dev"
-
-	client, err := lego.NewClient(config)
-	if err != nil {
-		return nil, err
-	}
-
-	reg, err := client.Registration.ResolveAccountByKey()
-	if err != nil {
-		return nil, err
-	}
-	return reg, nil
-}
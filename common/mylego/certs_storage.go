@@ -4,12 +4,11 @@ import (
 	"bytes"
 	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
 	"golang.org/x/net/idna"
@@ -44,31 +43,32 @@ func NewCertificatesStorage(path string) *CertificatesStorage {
 	}
 }
 
-func (s *CertificatesStorage) CreateRootFolder() {
+func (s *CertificatesStorage) CreateRootFolder() error {
 	err := createNonExistingFolder(s.rootPath)
 	if err != nil {
-		log.Panicf("Could not check/create path: %v", err)
+		return fmt.Errorf("could not check/create path: %v", err)
 	}
+	return nil
 }
 
 func (s *CertificatesStorage) GetRootPath() string {
 	return s.rootPath
 }
 
-func (s *CertificatesStorage) SaveResource(certRes *certificate.Resource) {
+func (s *CertificatesStorage) SaveResource(certRes *certificate.Resource) error {
 	domain := certRes.Domain
 
 	// We store the certificate, private key and metadata in different files
 	// as web servers would not be able to work with a combined file.
 	err := s.WriteFile(domain, ".crt", certRes.Certificate)
 	if err != nil {
-		log.Panicf("Unable to save Certificate for domain %s\n\t%v", domain, err)
+		return fmt.Errorf("unable to save Certificate for domain %s: %v", domain, err)
 	}
 
 	if certRes.IssuerCertificate != nil {
 		err = s.WriteFile(domain, ".issuer.crt", certRes.IssuerCertificate)
 		if err != nil {
-			log.Panicf("Unable to save IssuerCertificate for domain %s\n\t%v", domain, err)
+			return fmt.Errorf("unable to save IssuerCertificate for domain %s: %v", domain, err)
 		}
 	}
 
@@ -76,54 +76,55 @@ func (s *CertificatesStorage) SaveResource(certRes *certificate.Resource) {
 		// if we were given a CSR, we don't know the private key
 		err = s.WriteFile(domain, ".key", certRes.PrivateKey)
 		if err != nil {
-			log.Panicf("Unable to save PrivateKey for domain %s\n\t%v", domain, err)
+			return fmt.Errorf("unable to save PrivateKey for domain %s: %v", domain, err)
 		}
 
 		if s.pem {
 			err = s.WriteFile(domain, ".pem", bytes.Join([][]byte{certRes.Certificate, certRes.PrivateKey}, nil))
 			if err != nil {
-				log.Panicf("Unable to save Certificate and PrivateKey in .pem for domain %s\n\t%v", domain, err)
+				return fmt.Errorf("unable to save Certificate and PrivateKey in .pem for domain %s: %v", domain, err)
 			}
 		}
 	} else if s.pem {
 		// we don't have the private key; can't write the .pem file
-		log.Panicf("Unable to save pem without private key for domain %s\n\t%v; are you using a CSR?", domain, err)
+		return fmt.Errorf("unable to save pem without private key for domain %s; are you using a CSR?", domain)
 	}
 
 	jsonBytes, err := json.MarshalIndent(certRes, "", "\t")
 	if err != nil {
-		log.Panicf("Unable to marshal CertResource for domain %s\n\t%v", domain, err)
+		return fmt.Errorf("unable to marshal CertResource for domain %s: %v", domain, err)
 	}
 
 	err = s.WriteFile(domain, ".json", jsonBytes)
 	if err != nil {
-		log.Panicf("Unable to save CertResource for domain %s\n\t%v", domain, err)
+		return fmt.Errorf("unable to save CertResource for domain %s: %v", domain, err)
 	}
+	return nil
 }
 
-func (s *CertificatesStorage) ReadResource(domain string) certificate.Resource {
+func (s *CertificatesStorage) ReadResource(domain string) (certificate.Resource, error) {
+	var resource certificate.Resource
 	raw, err := s.ReadFile(domain, ".json")
 	if err != nil {
-		log.Panicf("Error while loading the meta data for domain %s\n\t%v", domain, err)
+		return resource, fmt.Errorf("error while loading the meta data for domain %s: %v", domain, err)
 	}
 
-	var resource certificate.Resource
 	if err = json.Unmarshal(raw, &resource); err != nil {
-		log.Panicf("Error while marshaling the meta data for domain %s\n\t%v", domain, err)
+		return resource, fmt.Errorf("error while marshaling the meta data for domain %s: %v", domain, err)
 	}
 
-	return resource
+	return resource, nil
 }
 
-func (s *CertificatesStorage) ExistsFile(domain, extension string) bool {
+func (s *CertificatesStorage) ExistsFile(domain, extension string) (bool, error) {
 	filePath := s.GetFileName(domain, extension)
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return false
+		return false, nil
 	} else if err != nil {
-		log.Panic(err)
+		return false, err
 	}
-	return true
+	return true, nil
 }
 
 func (s *CertificatesStorage) ReadFile(domain, extension string) ([]byte, error) {
@@ -154,10 +155,12 @@ func (s *CertificatesStorage) WriteFile(domain, extension string, data []byte) e
 }
 
 // sanitizedDomain Make sure no funny chars are in the cert names (like wildcards ;)).
+// A domain IDNA rejects is kept as it is, the CA rejects it as well.
 func sanitizedDomain(domain string) string {
-	safe, err := idna.ToASCII(strings.ReplaceAll(domain, "*", "_"))
+	domain = strings.ReplaceAll(domain, "*", "_")
+	safe, err := idna.ToASCII(domain)
 	if err != nil {
-		log.Panic(err)
+		return domain
 	}
 	return safe
 }
@@ -0,0 +1,300 @@
+package mylego
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// renewBefore is how long before the expiry a certificate is renewed, as lego does
+	renewBefore = 30 * 24 * time.Hour
+	// checkInterval is how often the expiry of a valid certificate is checked
+	checkInterval = time.Hour
+	tickInterval  = time.Minute
+	minRetryDelay = time.Minute
+	maxRetryDelay = 6 * time.Hour
+)
+
+// CertStatus is the state of a certificate kept by the manager
+type CertStatus struct {
+	Domain      string    `json:"domain"`
+	Domains     []string  `json:"domains"` // Every name of the certificate, Domain first
+	Mode        string    `json:"mode"`
+	NotAfter    time.Time `json:"not_after"`
+	LastRenewal time.Time `json:"last_renewal"`
+	LastCheck   time.Time `json:"last_check"`
+	NextCheck   time.Time `json:"next_check"`
+	Failures    int       `json:"failures"` // Renewals failed in a row
+	LastError   string    `json:"last_error,omitempty"`
+	Nodes       int       `json:"nodes"`
+}
+
+// managedCert is a certificate shared by the nodes using the same domain
+type managedCert struct {
+	config *CertConfig
+	status CertStatus
+	owners map[interface{}]func(CertStatus, error)
+}
+
+// Manager obtains the certificates of the nodes and renews them in the background.
+// The nodes using the same domain share one certificate, renewed with the config of
+// the first node acquiring it.
+type Manager struct {
+	access sync.Mutex
+	certs  map[string]*managedCert // Key: domain
+	owners map[interface{}]string  // Value: domain
+	renew  func(config *CertConfig) (bool, error)
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+var current atomic.Pointer[Manager]
+
+func newManager() *Manager {
+	return &Manager{
+		certs:  make(map[string]*managedCert),
+		owners: make(map[interface{}]string),
+		renew:  renewCert,
+		done:   make(chan struct{}),
+	}
+}
+
+// StartManager starts renewing the acquired certificates and makes the manager the
+// one Acquire registers to
+func StartManager() *Manager {
+	m := newManager()
+	m.wg.Add(1)
+	go m.run()
+	current.Store(m)
+	return m
+}
+
+// Close stops the renewals
+func (m *Manager) Close() error {
+	current.CompareAndSwap(m, nil)
+	close(m.done)
+	m.wg.Wait()
+	return nil
+}
+
+// Acquire returns the certificate files of config, obtaining the certificate when it
+// does not exist yet, and registers it for renewal on behalf of owner. onChange is called
+// after each renewal attempt. Without a running manager the certificate is only obtained.
+func Acquire(config *CertConfig, owner interface{}, onChange func(CertStatus, error)) (certFile, keyFile string, err error) {
+	if config.mainDomain() == "" {
+		return "", "", errors.New("no domain to obtain a certificate for")
+	}
+	lego, err := New(config)
+	if err != nil {
+		return "", "", err
+	}
+	certFile, keyFile, err = lego.obtain()
+	if err != nil {
+		return "", "", err
+	}
+	if m := current.Load(); m != nil {
+		m.add(config, owner, onChange, certFile)
+	}
+	return certFile, keyFile, nil
+}
+
+// Release stops renewing the certificate of owner, unless other owners share it
+func Release(owner interface{}) {
+	if m := current.Load(); m != nil {
+		m.remove(owner)
+	}
+}
+
+// Status returns the certificates of the running manager
+func Status() []CertStatus {
+	if m := current.Load(); m != nil {
+		return m.Status()
+	}
+	return nil
+}
+
+// Status returns the managed certificates sorted by domain
+func (m *Manager) Status() []CertStatus {
+	m.access.Lock()
+	defer m.access.Unlock()
+	status := make([]CertStatus, 0, len(m.certs))
+	for _, cert := range m.certs {
+		status = append(status, cert.status)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Domain < status[j].Domain })
+	return status
+}
+
+func (m *Manager) add(config *CertConfig, owner interface{}, onChange func(CertStatus, error), certFile string) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	domain := config.mainDomain()
+	if m.owners[owner] != domain {
+		m.removeLocked(owner)
+	}
+	cert, ok := m.certs[domain]
+	if !ok {
+		cert = &managedCert{
+			config: config,
+			status: CertStatus{Domain: domain, Domains: config.Domains(), Mode: config.CertMode, NextCheck: time.Now()},
+			owners: make(map[interface{}]func(CertStatus, error)),
+		}
+		if leaf, err := ReadCertificate(certFile); err == nil {
+			cert.status.NotAfter = leaf.NotAfter
+		}
+		m.certs[domain] = cert
+	}
+	cert.owners[owner] = onChange
+	cert.status.Nodes = len(cert.owners)
+	m.owners[owner] = domain
+}
+
+func (m *Manager) remove(owner interface{}) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	m.removeLocked(owner)
+}
+
+func (m *Manager) removeLocked(owner interface{}) {
+	domain, ok := m.owners[owner]
+	if !ok {
+		return
+	}
+	delete(m.owners, owner)
+	cert := m.certs[domain]
+	delete(cert.owners, owner)
+	cert.status.Nodes = len(cert.owners)
+	if len(cert.owners) == 0 {
+		delete(m.certs, domain)
+	}
+}
+
+func (m *Manager) run() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.checkDue()
+		}
+	}
+}
+
+// checkDue checks the certificates due one after the other, the ACME operations are
+// serialized anyway
+func (m *Manager) checkDue() {
+	now := time.Now()
+	m.access.Lock()
+	var due []*managedCert
+	for _, cert := range m.certs {
+		if !cert.status.NextCheck.After(now) {
+			due = append(due, cert)
+		}
+	}
+	m.access.Unlock()
+	for _, cert := range due {
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+		m.check(cert)
+	}
+}
+
+// check renews the certificate when it expires soon
+func (m *Manager) check(cert *managedCert) {
+	m.access.Lock()
+	config := cert.config
+	notAfter := cert.status.NotAfter
+	m.access.Unlock()
+
+	now := time.Now()
+	if !notAfter.IsZero() && notAfter.Sub(now) > renewBefore {
+		m.access.Lock()
+		cert.status.LastCheck = now
+		cert.status.NextCheck = now.Add(checkInterval)
+		m.access.Unlock()
+		return
+	}
+
+	renewed, err := m.renew(config)
+	m.access.Lock()
+	cert.status.LastCheck = now
+	if err != nil {
+		cert.status.Failures++
+		cert.status.LastError = err.Error()
+		cert.status.NextCheck = now.Add(retryDelay(cert.status.Failures))
+		log.WithError(err).WithField("domain", cert.status.Domain).
+			Errorf("Certificate renewal failed %d times, retry at %s", cert.status.Failures, cert.status.NextCheck.Format(time.RFC3339))
+	} else {
+		cert.status.Failures = 0
+		cert.status.LastError = ""
+		cert.status.NextCheck = now.Add(checkInterval)
+		if renewed {
+			cert.status.LastRenewal = now
+		}
+		if certFile, _, err := checkCertFile(cert.status.Domain); err == nil {
+			if leaf, err := ReadCertificate(certFile); err == nil {
+				cert.status.NotAfter = leaf.NotAfter
+			}
+		}
+	}
+	status := cert.status
+	var callbacks []func(CertStatus, error)
+	for _, onChange := range cert.owners {
+		if onChange != nil {
+			callbacks = append(callbacks, onChange)
+		}
+	}
+	m.access.Unlock()
+
+	if err == nil && !renewed {
+		return
+	}
+	for _, onChange := range callbacks {
+		onChange(status, err)
+	}
+}
+
+// retryDelay doubles the delay after each failure
+func retryDelay(failures int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+func renewCert(config *CertConfig) (bool, error) {
+	lego, err := New(config)
+	if err != nil {
+		return false, err
+	}
+	_, _, renewed, err := lego.RenewCert()
+	return renewed, err
+}
+
+// ReadCertificate returns the first certificate in a PEM file
+func ReadCertificate(certFile string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
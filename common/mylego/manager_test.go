@@ -0,0 +1,149 @@
+package mylego
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate of domain where lego stores the obtained ones
+func writeCert(t *testing.T, dir string, domain string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certsPath := filepath.Join(dir, "cert", baseCertificatesFolderName)
+	if err := os.MkdirAll(certsPath, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(certsPath, domain+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(certsPath, domain+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManager(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XRAY_LOCATION_CONFIG", dir)
+	writeCert(t, dir, "expiring.test.com", time.Now().Add(10*24*time.Hour))
+	writeCert(t, dir, "valid.test.com", time.Now().Add(60*24*time.Hour))
+
+	m := newManager()
+	current.Store(m)
+	defer current.CompareAndSwap(m, nil)
+	renewErr := errors.New("rate limited")
+	m.renew = func(config *CertConfig) (bool, error) {
+		if renewErr != nil {
+			return false, renewErr
+		}
+		writeCert(t, dir, config.CertDomain, time.Now().Add(90*24*time.Hour))
+		return true, nil
+	}
+	var changes []error
+	onChange := func(status CertStatus, err error) { changes = append(changes, err) }
+
+	expiring := &CertConfig{CertMode: "dns", CertDomain: "expiring.test.com"}
+	valid := &CertConfig{CertMode: "http", CertDomain: "valid.test.com"}
+	for owner, config := range map[string]*CertConfig{"node1": expiring, "node2": expiring, "node3": valid} {
+		certFile, _, err := Acquire(config, owner, onChange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(certFile) != config.CertDomain+".crt" {
+			t.Errorf("unexpected cert file %s", certFile)
+		}
+	}
+	status := m.Status()
+	if len(status) != 2 || status[0].Domain != "expiring.test.com" || status[0].Nodes != 2 || status[1].Nodes != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// The renewal fails, it is retried later and later
+	m.checkDue()
+	status = m.Status()
+	if status[0].Failures != 1 || status[0].LastError != "rate limited" || len(changes) != 2 {
+		t.Fatalf("unexpected status after a failure: %+v, %d changes", status[0], len(changes))
+	}
+	if delay := time.Until(status[0].NextCheck); delay <= 0 || delay > minRetryDelay {
+		t.Errorf("unexpected retry delay %s", delay)
+	}
+	// The valid certificate is only checked
+	if status[1].LastCheck.IsZero() || status[1].Failures != 0 || time.Until(status[1].NextCheck) < checkInterval-time.Minute {
+		t.Errorf("unexpected status of the valid certificate: %+v", status[1])
+	}
+	m.check(m.certs["expiring.test.com"])
+	if status = m.Status(); status[0].Failures != 2 || time.Until(status[0].NextCheck) <= minRetryDelay {
+		t.Errorf("unexpected status after two failures: %+v", status[0])
+	}
+
+	renewErr = nil
+	changes = nil
+	m.check(m.certs["expiring.test.com"])
+	status = m.Status()
+	if status[0].Failures != 0 || status[0].LastError != "" || status[0].LastRenewal.IsZero() ||
+		time.Until(status[0].NotAfter) < 80*24*time.Hour || len(changes) != 2 || changes[0] != nil {
+		t.Errorf("unexpected status after the renewal: %+v, changes %v", status[0], changes)
+	}
+
+	Release("node1")
+	Release("node3")
+	if status = m.Status(); len(status) != 1 || status[0].Nodes != 1 {
+		t.Errorf("unexpected status after the release: %+v", status)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for failures, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 20: maxRetryDelay} {
+		if delay := retryDelay(failures); delay != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", failures, delay, want)
+		}
+	}
+}
+
+func TestDomains(t *testing.T) {
+	config := &CertConfig{CertDomain: "Node.test.com", CertDomains: []string{"*.test.com", "node.test.com", " "}}
+	if domains := config.Domains(); len(domains) != 2 || domains[0] != "node.test.com" || domains[1] != "*.test.com" {
+		t.Errorf("unexpected domains %v", domains)
+	}
+
+	dir := t.TempDir()
+	writeCert(t, dir, "node.test.com", time.Now().Add(time.Hour))
+	certFile := filepath.Join(dir, "cert", baseCertificatesFolderName, "node.test.com.crt")
+	if err := certMatches(certFile, &CertConfig{CertDomain: "node.test.com"}); err != nil {
+		t.Error(err)
+	}
+	// A domain was added to the config, the certificate is obtained again
+	if err := certMatches(certFile, config); err == nil {
+		t.Error("certificate without *.test.com accepted")
+	}
+	// So it is when the key type changed
+	if err := certMatches(certFile, &CertConfig{CertDomain: "node.test.com", KeyType: "rsa2048"}); err == nil {
+		t.Error("certificate with an EC256 key accepted for RSA2048")
+	}
+}
@@ -1,14 +1,106 @@
 package mylego
 
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+)
+
 type CertConfig struct {
-	CertMode         string            `mapstructure:"CertMode"` // none, file, http, dns
+	CertMode         string            `mapstructure:"CertMode"` // none, file, http, tls, dns, self
 	CertDomain       string            `mapstructure:"CertDomain"`
+	CertDomains      []string          `mapstructure:"CertDomains"` // More names of the certificate, wildcards need CertMode dns or self
 	CertFile         string            `mapstructure:"CertFile"`
 	KeyFile          string            `mapstructure:"KeyFile"`
-	Provider         string            `mapstructure:"Provider"` // alidns, cloudflare, gandi, godaddy....
+	Certificates     []*CertFileConfig `mapstructure:"Certificates"` // More certificates of the inbound, picked by SNI
+	Provider         string            `mapstructure:"Provider"`     // alidns, cloudflare, gandi, godaddy....
 	Email            string            `mapstructure:"Email"`
 	DNSEnv           map[string]string `mapstructure:"DNSEnv"`
 	RejectUnknownSni bool              `mapstructure:"RejectUnknownSni"`
+	CAServer         string            `mapstructure:"CAServer"` // ACME directory URL or one of caServers, default letsencrypt
+	EABKeyID         string            `mapstructure:"EABKeyID"` // External Account Binding, required by ZeroSSL and Google
+	EABHMACKey       string            `mapstructure:"EABHMACKey"`
+	KeyType          string            `mapstructure:"KeyType"`         // EC256, EC384, RSA2048, RSA3072, RSA4096, RSA8192
+	PreferredChain   string            `mapstructure:"PreferredChain"`  // Common name of the root of the chain to download
+	CABundle         string            `mapstructure:"CABundle"`        // PEM roots trusted for the ACME server, e.g. of an internal CA
+	ChallengeListen  string            `mapstructure:"ChallengeListen"` // host:port of the http and tls challenge servers, default port 80 and 443
+}
+
+// caServers are the ACME directories CAServer can name
+var caServers = map[string]string{
+	"letsencrypt":         lego.LEDirectoryProduction,
+	"letsencrypt-staging": lego.LEDirectoryStaging,
+	"zerossl":             "https://acme.zerossl.com/v2/DV90",
+	"google":              "https://dv.acme-v02.api.pki.goog/directory",
+	"google-staging":      "https://dv.acme-v02.test-api.pki.goog/directory",
+}
+
+var keyTypes = map[string]certcrypto.KeyType{
+	"EC256":   certcrypto.EC256,
+	"EC384":   certcrypto.EC384,
+	"RSA2048": certcrypto.RSA2048,
+	"RSA3072": certcrypto.RSA3072,
+	"RSA4096": certcrypto.RSA4096,
+	"RSA8192": certcrypto.RSA8192,
+}
+
+// CertFileConfig is a certificate and its key given as files
+type CertFileConfig struct {
+	CertFile string `mapstructure:"CertFile"`
+	KeyFile  string `mapstructure:"KeyFile"`
+}
+
+// Domains returns the names of the certificate, CertDomain first followed by CertDomains.
+// The certificate is stored under the first one.
+func (c *CertConfig) Domains() []string {
+	var domains []string
+	seen := make(map[string]bool)
+	for _, d := range append([]string{c.CertDomain}, c.CertDomains...) {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" && !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// CADirURL returns the ACME directory of CAServer
+func (c *CertConfig) CADirURL() (string, error) {
+	if c.CAServer == "" {
+		return lego.LEDirectoryProduction, nil
+	}
+	if dirURL, ok := caServers[strings.ToLower(c.CAServer)]; ok {
+		return dirURL, nil
+	}
+	u, err := url.Parse(c.CAServer)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("CAServer %q is not an https URL nor one of letsencrypt, letsencrypt-staging, zerossl, google, google-staging", c.CAServer)
+	}
+	return c.CAServer, nil
+}
+
+// CertKeyType returns the type of the certificate key, EC256 by default
+func (c *CertConfig) CertKeyType() (certcrypto.KeyType, error) {
+	if c.KeyType == "" {
+		return certcrypto.EC256, nil
+	}
+	keyType, ok := keyTypes[strings.ToUpper(c.KeyType)]
+	if !ok {
+		return "", fmt.Errorf("unsupported KeyType %q, use EC256, EC384, RSA2048, RSA3072, RSA4096 or RSA8192", c.KeyType)
+	}
+	return keyType, nil
+}
+
+// mainDomain returns the name the certificate is stored under
+func (c *CertConfig) mainDomain() string {
+	if domains := c.Domains(); len(domains) > 0 {
+		return domains[0]
+	}
+	return ""
 }
 
 type LegoCMD struct {
@@ -1,16 +1,29 @@
 package mylego
 
 import (
-	"errors"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	log "github.com/sirupsen/logrus"
 )
 
 var defaultPath string
 
+// acmeAccess serializes the ACME operations, the DNS providers read their
+// credentials from the process environment and the challenge servers share ports.
+// The nodes of CertMode self share the local CA created under it.
+var acmeAccess sync.Mutex
+
 func New(certConf *CertConfig) (*LegoCMD, error) {
 	// Set default path to configPath/cert
 	var p = ""
@@ -42,110 +55,66 @@ func (l *LegoCMD) getCertConfig() *CertConfig {
 
 // DNSCert cert a domain using DNS API
 func (l *LegoCMD) DNSCert() (CertPath string, KeyPath string, err error) {
-	defer func() (string, string, error) {
-		// Handle any error
-		if r := recover(); r != nil {
-			switch x := r.(type) {
-			case string:
-				err = errors.New(x)
-			case error:
-				err = x
-			default:
-				err = errors.New("unknown panic")
-			}
-			return "", "", err
-		}
-		return CertPath, KeyPath, nil
-	}()
-
-	// Set Env for DNS configuration
-	for key, value := range l.C.DNSEnv {
-		os.Setenv(strings.ToUpper(key), value)
-	}
-
-	// First check if the certificate exists
-	CertPath, KeyPath, err = checkCertFile(l.C.CertDomain)
-	if err == nil {
-		return CertPath, KeyPath, err
-	}
-
-	err = l.Run()
-	if err != nil {
-		return "", "", err
-	}
-	CertPath, KeyPath, err = checkCertFile(l.C.CertDomain)
-	if err != nil {
-		return "", "", err
-	}
-	return CertPath, KeyPath, nil
+	return l.obtain()
 }
 
 // HTTPCert cert a domain using http methods
 func (l *LegoCMD) HTTPCert() (CertPath string, KeyPath string, err error) {
-	defer func() (string, string, error) {
-		// Handle any error
-		if r := recover(); r != nil {
-			switch x := r.(type) {
-			case string:
-				err = errors.New(x)
-			case error:
-				err = x
-			default:
-				err = errors.New("unknown panic")
-			}
-			return "", "", err
-		}
-		return CertPath, KeyPath, nil
-	}()
+	return l.obtain()
+}
 
-	// First check if the certificate exists
-	CertPath, KeyPath, err = checkCertFile(l.C.CertDomain)
+// obtain returns the certificate of the domain, it is obtained if it does not exist yet
+func (l *LegoCMD) obtain() (CertPath string, KeyPath string, err error) {
+	// First check if the certificate exists with every domain and the key type
+	CertPath, KeyPath, err = checkCertFile(l.C.mainDomain())
 	if err == nil {
-		return CertPath, KeyPath, err
+		if err = certMatches(CertPath, l.C); err == nil && l.C.CertMode == "self" {
+			err = l.selfIssued(CertPath)
+		}
+		if err == nil {
+			return CertPath, KeyPath, nil
+		}
+		log.WithError(err).Print("Obtaining a new certificate")
 	}
 
-	err = l.Run()
-	if err != nil {
-		return "", "", err
+	acmeAccess.Lock()
+	if l.C.CertMode == "self" {
+		err = l.issueSelf()
+	} else {
+		err = l.Run()
 	}
-
-	CertPath, KeyPath, err = checkCertFile(l.C.CertDomain)
+	acmeAccess.Unlock()
 	if err != nil {
 		return "", "", err
 	}
 
-	return CertPath, KeyPath, nil
+	return checkCertFile(l.C.mainDomain())
 }
 
 // RenewCert renew a domain cert
 func (l *LegoCMD) RenewCert() (CertPath string, KeyPath string, ok bool, err error) {
-	defer func() (string, string, bool, error) {
-		// Handle any error
-		if r := recover(); r != nil {
-			switch x := r.(type) {
-			case string:
-				err = errors.New(x)
-			case error:
-				err = x
-			default:
-				err = errors.New("unknown panic")
-			}
-			return "", "", false, err
-		}
-		return CertPath, KeyPath, ok, nil
-	}()
-
-	ok, err = l.Renew()
+	acmeAccess.Lock()
+	if l.C.CertMode == "self" {
+		ok, err = l.renewSelf()
+	} else {
+		ok, err = l.Renew()
+	}
+	acmeAccess.Unlock()
 	if err != nil {
-		return
+		return "", "", false, err
 	}
 
-	CertPath, KeyPath, err = checkCertFile(l.C.CertDomain)
+	CertPath, KeyPath, err = checkCertFile(l.C.mainDomain())
 	if err != nil {
-		return
+		return "", "", false, err
 	}
 
-	return
+	return CertPath, KeyPath, ok, nil
+}
+
+// CertFile returns the paths of the certificate and key obtained for the domain
+func (l *LegoCMD) CertFile() (CertPath string, KeyPath string, err error) {
+	return checkCertFile(l.C.mainDomain())
 }
 
 func checkCertFile(domain string) (string, string, error) {
@@ -161,3 +130,48 @@ func checkCertFile(domain string) (string, string, error) {
 	absCertPath, _ := filepath.Abs(certPath)
 	return absCertPath, absKeyPath, nil
 }
+
+// certMatches checks the certificate in certFile is valid for every domain of certConfig
+// and has a key of its type
+func certMatches(certFile string, certConfig *CertConfig) error {
+	leaf, err := ReadCertificate(certFile)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, name := range certcrypto.ExtractDomains(leaf) {
+		names[strings.ToLower(name)] = true
+	}
+	for _, ip := range leaf.IPAddresses {
+		names[ip.String()] = true
+	}
+	for _, domain := range certConfig.Domains() {
+		if !names[domain] {
+			return fmt.Errorf("certificate %s is not valid for %s", certFile, domain)
+		}
+	}
+	keyType, err := certConfig.CertKeyType()
+	if err != nil {
+		return err
+	}
+	if publicKeyType(leaf) != keyType {
+		return fmt.Errorf("certificate %s does not have a %s key", certFile, keyType)
+	}
+	return nil
+}
+
+// publicKeyType returns the key type of the certificate, empty for other keys
+func publicKeyType(cert *x509.Certificate) certcrypto.KeyType {
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return certcrypto.EC256
+		case elliptic.P384():
+			return certcrypto.EC384
+		}
+	case *rsa.PublicKey:
+		return certcrypto.KeyType(strconv.Itoa(key.N.BitLen()))
+	}
+	return ""
+}
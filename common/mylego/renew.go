@@ -3,6 +3,7 @@ package mylego
 import (
 	"crypto"
 	"crypto/x509"
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -13,26 +14,34 @@ import (
 )
 
 func (l *LegoCMD) Renew() (bool, error) {
-	account, client := setup(NewAccountsStorage(l))
-	setupChallenges(l, client)
+	_, account, client, err := setup(l)
+	if err != nil {
+		return false, err
+	}
+	if err := setupChallenges(l, client); err != nil {
+		return false, err
+	}
 
 	if account.Registration == nil {
-		log.Panicf("Account %s is not registered. Use 'run' to register a new account.\n", account.Email)
+		return false, fmt.Errorf("account %s is not registered, use 'run' to register a new account", account.Email)
 	}
 
-	return renewForDomains(l.C.CertDomain, client, NewCertificatesStorage(l.path))
+	return renewForDomains(l.C.mainDomain(), client, NewCertificatesStorage(l.path), l.C.PreferredChain)
 }
 
-func renewForDomains(domain string, client *lego.Client, certsStorage *CertificatesStorage) (bool, error) {
+func renewForDomains(domain string, client *lego.Client, certsStorage *CertificatesStorage, preferredChain string) (bool, error) {
 	// load the cert resource from files.
 	// We store the certificate, private key and metadata in different files
 	// as web servers would not be able to work with a combined file.
 	certificates, err := certsStorage.ReadCertificate(domain, ".crt")
 	if err != nil {
-		log.Panicf("Error while loading the certificate for domain %s\n\t%v", domain, err)
+		return false, fmt.Errorf("error while loading the certificate for domain %s: %v", domain, err)
 	}
 
 	cert := certificates[0]
+	if cert.IsCA {
+		return false, fmt.Errorf("[%s] certificate bundle starts with a CA certificate", domain)
+	}
 
 	if !needRenewal(cert, domain, 30) {
 		return false, nil
@@ -46,25 +55,24 @@ func renewForDomains(domain string, client *lego.Client, certsStorage *Certifica
 
 	var privateKey crypto.PrivateKey
 	request := certificate.ObtainRequest{
-		Domains:    certDomains,
-		Bundle:     true,
-		PrivateKey: privateKey,
+		Domains:        certDomains,
+		Bundle:         true,
+		PrivateKey:     privateKey,
+		PreferredChain: preferredChain,
 	}
 	certRes, err := client.Certificate.Obtain(request)
 	if err != nil {
-		log.Panic(err)
+		return false, err
 	}
 
-	certsStorage.SaveResource(certRes)
+	if err := certsStorage.SaveResource(certRes); err != nil {
+		return false, err
+	}
 
 	return true, nil
 }
 
 func needRenewal(x509Cert *x509.Certificate, domain string, days int) bool {
-	if x509Cert.IsCA {
-		log.Panicf("[%s] Certificate bundle starts with a CA certificate", domain)
-	}
-
 	if days >= 0 {
 		notAfter := int(time.Until(x509Cert.NotAfter).Hours() / 24.0)
 		if notAfter > days {
@@ -5,7 +5,6 @@ import (
 
 	"github.com/go-acme/lego/v4/certificate"
 	"github.com/go-acme/lego/v4/lego"
-	"github.com/go-acme/lego/v4/registration"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,46 +20,48 @@ backups of this folder is ideal.
 `
 
 func (l *LegoCMD) Run() error {
-	accountsStorage := NewAccountsStorage(l)
-
-	account, client := setup(accountsStorage)
-	setupChallenges(l, client)
+	accountsStorage, account, client, err := setup(l)
+	if err != nil {
+		return err
+	}
+	if err := setupChallenges(l, client); err != nil {
+		return err
+	}
 
 	if account.Registration == nil {
-		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		reg, err := register(client, l.C)
 		if err != nil {
-			log.Panicf("Could not complete registration\n\t%v", err)
+			return fmt.Errorf("could not complete registration: %v", err)
 		}
 
 		account.Registration = reg
 		if err = accountsStorage.Save(account); err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		fmt.Printf(rootPathWarningMessage, accountsStorage.GetRootPath())
+		log.Infof(rootPathWarningMessage, accountsStorage.GetRootPath())
 	}
 
 	certsStorage := NewCertificatesStorage(l.path)
-	certsStorage.CreateRootFolder()
+	if err := certsStorage.CreateRootFolder(); err != nil {
+		return err
+	}
 
-	cert, err := obtainCertificate([]string{l.C.CertDomain}, client)
+	cert, err := obtainCertificate(l.C.Domains(), client, l.C.PreferredChain)
 	if err != nil {
-		// Make sure to return a non-zero exit code if ObtainSANCertificate returned at least one error.
-		// Due to us not returning partial certificate we can just exit here instead of at the end.
-		log.Panicf("Could not obtain certificates:\n\t%v", err)
+		return fmt.Errorf("could not obtain certificates: %v", err)
 	}
 
-	certsStorage.SaveResource(cert)
-
-	return nil
+	return certsStorage.SaveResource(cert)
 }
 
-func obtainCertificate(domains []string, client *lego.Client) (*certificate.Resource, error) {
+func obtainCertificate(domains []string, client *lego.Client, preferredChain string) (*certificate.Resource, error) {
 	if len(domains) > 0 {
 		// obtain a certificate, generating a new private key
 		request := certificate.ObtainRequest{
-			Domains: domains,
-			Bundle:  true,
+			Domains:        domains,
+			Bundle:         true,
+			PreferredChain: preferredChain,
 		}
 		return client.Certificate.Obtain(request)
 	}
@@ -0,0 +1,216 @@
+package mylego
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	baseCAFolderName = "ca"
+	caValidity       = 10 * 365 * 24 * time.Hour
+	// selfValidity is the validity of the certificates of CertMode self, renewed 30 days
+	// before the expiry like the ACME ones
+	selfValidity = 90 * 24 * time.Hour
+)
+
+// SelfCert returns the certificate of the domains issued by the local CA, the CA and
+// the certificate are created when they do not exist yet
+func (l *LegoCMD) SelfCert() (CertPath string, KeyPath string, err error) {
+	return l.obtain()
+}
+
+// CAFile returns the paths of the certificate and key of the local CA issuing the
+// certificates of CertMode self. A CA of the internal PKI put there is used instead
+// of a new one.
+func (l *LegoCMD) CAFile() (CertPath string, KeyPath string) {
+	return filepath.Join(l.path, baseCAFolderName, "ca.crt"), filepath.Join(l.path, baseCAFolderName, "ca.key")
+}
+
+// issueSelf issues a certificate of the domains with the local CA
+func (l *LegoCMD) issueSelf() error {
+	domains := l.C.Domains()
+	if len(domains) == 0 {
+		return errors.New("no domain to issue a certificate for")
+	}
+	keyType, err := l.C.CertKeyType()
+	if err != nil {
+		return err
+	}
+	caCert, caKey, err := l.loadCA()
+	if err != nil {
+		return err
+	}
+	privateKey, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return fmt.Errorf("could not generate the private key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: domains[0]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if _, ok := privateKey.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	for _, domain := range domains {
+		if ip := net.ParseIP(domain); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, domain)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, privateKey.(crypto.Signer).Public(), caKey)
+	if err != nil {
+		return fmt.Errorf("could not issue the certificate: %v", err)
+	}
+
+	caPEM := certcrypto.PEMEncode(certcrypto.DERCertificateBytes(caCert.Raw))
+	certsStorage := NewCertificatesStorage(l.path)
+	if err := certsStorage.CreateRootFolder(); err != nil {
+		return err
+	}
+	log.Infof("[%s] Certificate issued by the local CA %s", domains[0], caCert.Subject.CommonName)
+	return certsStorage.SaveResource(&certificate.Resource{
+		Domain: domains[0],
+		// The chain ends with the CA, clients pinning it find it in the handshake
+		Certificate:       append(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(der)), caPEM...),
+		IssuerCertificate: caPEM,
+		PrivateKey:        certcrypto.PEMEncode(privateKey),
+	})
+}
+
+// renewSelf issues a new certificate when the one of the domain expires soon or was
+// not issued by the local CA
+func (l *LegoCMD) renewSelf() (bool, error) {
+	domain := l.C.mainDomain()
+	certificates, err := NewCertificatesStorage(l.path).ReadCertificate(domain, ".crt")
+	if err != nil {
+		return false, fmt.Errorf("error while loading the certificate for domain %s: %v", domain, err)
+	}
+	if err := l.issuedByCA(certificates[0]); err != nil {
+		log.WithError(err).Printf("[%s] Issuing a new certificate", domain)
+	} else if !needRenewal(certificates[0], domain, 30) {
+		return false, nil
+	}
+	if err := l.issueSelf(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// selfIssued checks the certificate in certFile was signed by the local CA, which
+// may have been replaced
+func (l *LegoCMD) selfIssued(certFile string) error {
+	leaf, err := ReadCertificate(certFile)
+	if err != nil {
+		return err
+	}
+	return l.issuedByCA(leaf)
+}
+
+// issuedByCA checks cert was signed by the local CA
+func (l *LegoCMD) issuedByCA(cert *x509.Certificate) error {
+	caFile, _ := l.CAFile()
+	caCert, err := ReadCertificate(caFile)
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		return fmt.Errorf("certificate not issued by the local CA: %v", err)
+	}
+	return nil
+}
+
+// loadCA returns the local CA, it is created when the files do not exist
+func (l *LegoCMD) loadCA() (*x509.Certificate, crypto.Signer, error) {
+	caFile, caKeyFile := l.CAFile()
+	certPEM, err := os.ReadFile(caFile)
+	if os.IsNotExist(err) {
+		return l.createCA()
+	} else if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(caKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	caCert, err := certcrypto.ParsePEMCertificate(certPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse the CA certificate %s: %v", caFile, err)
+	}
+	caKey, err := certcrypto.ParsePEMPrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse the CA key %s: %v", caKeyFile, err)
+	}
+	signer, ok := caKey.(crypto.Signer)
+	if !ok || !caCert.IsCA {
+		return nil, nil, fmt.Errorf("%s is not a CA", caFile)
+	}
+	return caCert, signer, nil
+}
+
+func (l *LegoCMD) createCA() (*x509.Certificate, crypto.Signer, error) {
+	caFile, caKeyFile := l.CAFile()
+	if err := createNonExistingFolder(filepath.Dir(caFile)); err != nil {
+		return nil, nil, fmt.Errorf("could not check/create path: %v", err)
+	}
+	caKey, err := certcrypto.GeneratePrivateKey(certcrypto.EC256)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Xray-P Local CA " + hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	signer := caKey.(crypto.Signer)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create the CA: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The key first, a CA certificate without its key could not be used
+	if err := os.WriteFile(caKeyFile, certcrypto.PEMEncode(caKey), filePerm); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(caFile, certcrypto.PEMEncode(certcrypto.DERCertificateBytes(der)), filePerm); err != nil {
+		return nil, nil, err
+	}
+	log.Infof("Local CA created in %s", filepath.Dir(caFile))
+	return caCert, signer, nil
+}
@@ -0,0 +1,74 @@
+package mylego
+
+import (
+	"bytes"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfCert(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XRAY_LOCATION_CONFIG", dir)
+	config := &CertConfig{CertMode: "self", CertDomain: "staging.test.com", CertDomains: []string{"*.staging.test.com", "10.0.0.1"}, KeyType: "EC384"}
+	lego, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, _, err := lego.SelfCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile, _ := lego.CAFile()
+	verify := func() {
+		t.Helper()
+		caCert, err := ReadCertificate(caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := ReadCertificate(certFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		for _, name := range []string{"staging.test.com", "a.staging.test.com", "10.0.0.1"} {
+			if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: name}); err != nil {
+				t.Errorf("%s: %s", name, err)
+			}
+		}
+	}
+	verify()
+	issued, _ := os.ReadFile(certFile)
+
+	// The certificate is kept until it expires soon
+	if certFile, _, err = lego.SelfCert(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, renewed, err := lego.RenewCert(); err != nil || renewed {
+		t.Errorf("unexpected renewal: %v %v", renewed, err)
+	}
+	if kept, _ := os.ReadFile(certFile); !bytes.Equal(kept, issued) {
+		t.Error("the certificate was issued again")
+	}
+
+	writeCert(t, dir, "staging.test.com", time.Now().Add(10*24*time.Hour))
+	if _, _, renewed, err := lego.RenewCert(); err != nil || !renewed {
+		t.Errorf("expiring certificate not renewed: %v %v", renewed, err)
+	}
+	verify()
+
+	// A CA put in place of the local one issues a new certificate
+	if err := os.RemoveAll(filepath.Dir(caFile)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := lego.loadCA(); err != nil {
+		t.Fatal(err)
+	}
+	if certFile, _, err = lego.SelfCert(); err != nil {
+		t.Fatal(err)
+	}
+	verify()
+}
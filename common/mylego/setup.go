@@ -1,11 +1,16 @@
 package mylego
 
 import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/challenge/http01"
@@ -13,30 +18,80 @@ import (
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/providers/dns"
 	"github.com/go-acme/lego/v4/registration"
-	"golang.org/x/crypto/acme"
 )
 
 const filePerm os.FileMode = 0o600
 
-func setup(accountsStorage *AccountsStorage) (*Account, *lego.Client) {
-	keyType := certcrypto.EC256
-	privateKey := accountsStorage.GetPrivateKey(keyType)
+func setup(l *LegoCMD) (*AccountsStorage, *Account, *lego.Client, error) {
+	accountsStorage, err := NewAccountsStorage(l)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	privateKey, err := accountsStorage.GetPrivateKey(certcrypto.EC256)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	account := &Account{Email: accountsStorage.GetUserID(), key: privateKey}
+	exists, err := accountsStorage.ExistsAccountFilePath()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if exists {
+		if account, err = accountsStorage.LoadAccount(privateKey); err != nil {
+			return nil, nil, nil, err
+		}
+		if account.Registration == nil || account.Registration.Body.Status == "" {
+			// couldn't load account but got a key. Try to look the account up.
+			if account.Registration, err = recoverRegistration(privateKey, l.C); err != nil {
+				return nil, nil, nil, fmt.Errorf("could not load account for %s, registration is nil: %v", account.Email, err)
+			}
+			if err = accountsStorage.Save(account); err != nil {
+				return nil, nil, nil, fmt.Errorf("could not save account for %s: %v", account.Email, err)
+			}
+		}
+	}
 
-	var account *Account
-	if accountsStorage.ExistsAccountFilePath() {
-		account = accountsStorage.LoadAccount(privateKey)
-	} else {
-		account = &Account{Email: accountsStorage.GetUserID(), key: privateKey}
+	client, err := newClient(account, l.C)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	client := newClient(account, keyType)
+	return accountsStorage, account, client, nil
+}
 
-	return account, client
+func recoverRegistration(privateKey crypto.PrivateKey, certConfig *CertConfig) (*registration.Resource, error) {
+	client, err := newClient(&Account{key: privateKey}, certConfig)
+	if err != nil {
+		return nil, err
+	}
+	return client.Registration.ResolveAccountByKey()
 }
 
-func newClient(acc registration.User, keyType certcrypto.KeyType) *lego.Client {
+// register registers the account, with the External Account Binding when configured
+func register(client *lego.Client, certConfig *CertConfig) (*registration.Resource, error) {
+	if certConfig.EABKeyID != "" {
+		return client.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
+			TermsOfServiceAgreed: true,
+			Kid:                  certConfig.EABKeyID,
+			HmacEncoded:          certConfig.EABHMACKey,
+		})
+	}
+	return client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+}
+
+func newClient(acc registration.User, certConfig *CertConfig) (*lego.Client, error) {
+	caDirURL, err := certConfig.CADirURL()
+	if err != nil {
+		return nil, err
+	}
+	keyType, err := certConfig.CertKeyType()
+	if err != nil {
+		return nil, err
+	}
+
 	config := lego.NewConfig(acc)
-	config.CADirURL = acme.LetsEncryptURL
+	config.CADirURL = caDirURL
 
 	config.Certificate = lego.CertificateConfig{
 		KeyType: keyType,
@@ -44,12 +99,36 @@ func newClient(acc registration.User, keyType certcrypto.KeyType) *lego.Client {
 	}
 	config.UserAgent = "lego-cli/dev"
 
+	if certConfig.CABundle != "" {
+		pool, err := loadCABundle(certConfig.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		config.HTTPClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+	}
+
 	client, err := lego.NewClient(config)
 	if err != nil {
-		log.Panicf("Could not create client: %v", err)
+		return nil, fmt.Errorf("could not create client: %v", err)
 	}
 
-	return client
+	return client, nil
+}
+
+// loadCABundle returns the system roots with the roots in the PEM file
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CABundle failed: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in CABundle %s", path)
+	}
+	return pool, nil
 }
 
 func createNonExistingFolder(path string) error {
@@ -61,36 +140,38 @@ func createNonExistingFolder(path string) error {
 	return nil
 }
 
-func setupChallenges(l *LegoCMD, client *lego.Client) {
+func setupChallenges(l *LegoCMD, client *lego.Client) error {
+	var host, port string
+	if l.C.ChallengeListen != "" {
+		var err error
+		if host, port, err = net.SplitHostPort(l.C.ChallengeListen); err != nil {
+			return fmt.Errorf("invalid ChallengeListen: %v", err)
+		}
+	}
 	switch l.C.CertMode {
 	case "http":
-		err := client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", ""))
-		if err != nil {
-			log.Panic(err)
-		}
+		return client.Challenge.SetHTTP01Provider(http01.NewProviderServer(host, port))
 	case "tls":
-		err := client.Challenge.SetTLSALPN01Provider(tlsalpn01.NewProviderServer("", ""))
-		if err != nil {
-			log.Panic(err)
-		}
+		return client.Challenge.SetTLSALPN01Provider(tlsalpn01.NewProviderServer(host, port))
 	case "dns":
-		setupDNS(l.C.Provider, client)
+		// The DNS providers read their credentials from the environment
+		for key, value := range l.C.DNSEnv {
+			os.Setenv(strings.ToUpper(key), value)
+		}
+		return setupDNS(l.C.Provider, client)
 	default:
-		log.Panic("No challenge selected. You must specify at least one challenge: `http`, `tls`, `dns`.")
+		return errors.New("no challenge selected, you must specify at least one challenge: `http`, `tls`, `dns`")
 	}
 }
 
-func setupDNS(p string, client *lego.Client) {
+func setupDNS(p string, client *lego.Client) error {
 	provider, err := dns.NewDNSChallengeProviderByName(p)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	err = client.Challenge.SetDNS01Provider(
+	return client.Challenge.SetDNS01Provider(
 		provider,
 		dns01.CondOption(true, dns01.AddDNSTimeout(10*time.Second)),
 	)
-	if err != nil {
-		log.Panic(err)
-	}
 }
@@ -0,0 +1,103 @@
+package mylego
+
+import (
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+)
+
+func TestCAServer(t *testing.T) {
+	for server, want := range map[string]string{
+		"":                              lego.LEDirectoryProduction,
+		"ZeroSSL":                       "https://acme.zerossl.com/v2/DV90",
+		"https://ca.internal:9000/acme": "https://ca.internal:9000/acme",
+	} {
+		if dirURL, err := (&CertConfig{CAServer: server}).CADirURL(); err != nil || dirURL != want {
+			t.Errorf("CAServer %q: got %s %v, want %s", server, dirURL, err, want)
+		}
+	}
+	for _, server := range []string{"letsencrypt2", "http://ca.internal/acme"} {
+		if _, err := (&CertConfig{CAServer: server}).CADirURL(); err == nil {
+			t.Errorf("CAServer %q accepted", server)
+		}
+	}
+
+	if keyType, err := (&CertConfig{KeyType: "rsa4096"}).CertKeyType(); err != nil || keyType != certcrypto.RSA4096 {
+		t.Errorf("unexpected key type %s %v", keyType, err)
+	}
+	if _, err := (&CertConfig{KeyType: "ED25519"}).CertKeyType(); err == nil {
+		t.Error("KeyType ED25519 accepted")
+	}
+
+	// The accounts of each CA are kept apart
+	storage, err := NewAccountsStorage(&LegoCMD{C: &CertConfig{Email: "test@me.com", CAServer: "https://localhost:14000/dir"}, path: "cert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storage.GetRootUserPath() != filepath.Join("cert", "accounts", "localhost_14000", "test@me.com") {
+		t.Errorf("unexpected account path %s", storage.GetRootUserPath())
+	}
+}
+
+// TestPebble obtains certificates from a local Pebble ACME server, started with:
+//
+//	PEBBLE_VA_ALWAYS_VALID=1 pebble -config test/config/pebble-config.json
+//	PEBBLE_URL=https://localhost:14000/dir PEBBLE_CA=test/certs/pebble.minica.pem go test -run TestPebble ./common/mylego
+//
+// PEBBLE_EAB_KID and PEBBLE_EAB_HMAC give the External Account Binding when Pebble requires it.
+func TestPebble(t *testing.T) {
+	dirURL := os.Getenv("PEBBLE_URL")
+	if dirURL == "" {
+		t.Skip("PEBBLE_URL not set")
+	}
+	dir := t.TempDir()
+	t.Setenv("XRAY_LOCATION_CONFIG", dir)
+	config := &CertConfig{
+		CertMode:        "http",
+		CertDomain:      "node.test.com",
+		CertDomains:     []string{"cdn.node.test.com"},
+		Email:           "test@me.com",
+		CAServer:        dirURL,
+		CABundle:        os.Getenv("PEBBLE_CA"),
+		EABKeyID:        os.Getenv("PEBBLE_EAB_KID"),
+		EABHMACKey:      os.Getenv("PEBBLE_EAB_HMAC"),
+		KeyType:         "RSA2048",
+		ChallengeListen: ":5002",
+	}
+	lego, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, _, err := lego.HTTPCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := ReadCertificate(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := leaf.PublicKey.(*rsa.PublicKey); !ok || len(leaf.DNSNames) != 2 {
+		t.Errorf("unexpected certificate: %v %T", leaf.DNSNames, leaf.PublicKey)
+	}
+
+	// The key type changed, a new certificate is obtained with the registered account
+	config.KeyType = "EC384"
+	if certFile, _, err = lego.HTTPCert(); err != nil {
+		t.Fatal(err)
+	}
+	if leaf, err = ReadCertificate(certFile); err != nil {
+		t.Fatal(err)
+	}
+	if publicKeyType(leaf) != certcrypto.EC384 {
+		t.Errorf("unexpected key %T", leaf.PublicKey)
+	}
+
+	// Far from the expiry, nothing to renew
+	if _, _, renewed, err := lego.RenewCert(); err != nil || renewed {
+		t.Errorf("unexpected renewal: %v %v", renewed, err)
+	}
+}
@@ -0,0 +1,204 @@
+// Package rulelist reads local audit rule files.
+//
+// Each line of a rule file is one of:
+//
+//	# comment or // comment
+//	include <file or directory>
+//	regexp:<pattern>   (untyped lines are regexps too)
+//	domain:<domain>    the domain and all its subdomains
+//	full:<domain>      exactly this domain
+//	keyword:<text>     destinations containing the text
+//	port:<port>        destinations on this port
+//
+// Rules are matched against destinations formatted like "tcp:example.com:443".
+package rulelist
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"Xray-P/api"
+)
+
+// Rule is a compiled local rule together with where it came from
+type Rule struct {
+	api.DetectRule
+	File string
+	Line int
+	Raw  string
+}
+
+// Source returns the file:line position of the rule
+func (r *Rule) Source() string {
+	return fmt.Sprintf("%s:%d", r.File, r.Line)
+}
+
+// LineError is an invalid line in a rule file
+type LineError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+// List is the merged result of a rule file and everything it includes
+type List struct {
+	Rules  []Rule
+	Errors []error
+	Files  []string // every file and directory that was read
+}
+
+// DetectRules returns the rules in the form used by the rule manager
+func (l *List) DetectRules() []api.DetectRule {
+	rules := make([]api.DetectRule, len(l.Rules))
+	for i := range l.Rules {
+		rules[i] = l.Rules[i].DetectRule
+	}
+	return rules
+}
+
+// Match returns the first rule matching the destination
+func (l *List) Match(destination string) *Rule {
+	for i := range l.Rules {
+		if l.Rules[i].Pattern.MatchString(destination) {
+			return &l.Rules[i]
+		}
+	}
+	return nil
+}
+
+// Load reads the rule file or directory at path. Invalid lines are reported
+// in List.Errors and skipped, so one bad line never drops the whole list.
+func Load(path string) *List {
+	l := &List{}
+	if path != "" {
+		l.load(path, make(map[string]bool))
+	}
+	return l
+}
+
+func (l *List) load(path string, visiting map[string]bool) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		l.Errors = append(l.Errors, &LineError{File: path, Err: err})
+		return
+	}
+	if visiting[absPath] {
+		l.Errors = append(l.Errors, &LineError{File: path, Err: fmt.Errorf("include cycle")})
+		return
+	}
+	visiting[absPath] = true
+	defer delete(visiting, absPath)
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		l.Errors = append(l.Errors, &LineError{File: path, Err: err})
+		return
+	}
+	l.Files = append(l.Files, absPath)
+
+	if info.IsDir() {
+		entries, err := os.ReadDir(absPath)
+		if err != nil {
+			l.Errors = append(l.Errors, &LineError{File: path, Err: err})
+			return
+		}
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				names = append(names, e.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			l.load(filepath.Join(absPath, name), visiting)
+		}
+		return
+	}
+
+	file, err := os.Open(absPath)
+	if err != nil {
+		l.Errors = append(l.Errors, &LineError{File: path, Err: err})
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if include, ok := strings.CutPrefix(line, "include "); ok {
+			include = strings.TrimSpace(include)
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(absPath), include)
+			}
+			l.load(include, visiting)
+			continue
+		}
+		pattern, err := compile(line)
+		if err != nil {
+			l.Errors = append(l.Errors, &LineError{File: absPath, Line: lineNum, Err: err})
+			continue
+		}
+		l.Rules = append(l.Rules, Rule{
+			DetectRule: api.DetectRule{ID: -1, Pattern: pattern},
+			File:       absPath,
+			Line:       lineNum,
+			Raw:        line,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		l.Errors = append(l.Errors, &LineError{File: absPath, Line: lineNum + 1, Err: err})
+	}
+}
+
+func compile(line string) (*regexp.Regexp, error) {
+	kind, value, found := strings.Cut(line, ":")
+	if !found {
+		return regexp.Compile(line)
+	}
+	switch kind {
+	case "regexp":
+		return regexp.Compile(value)
+	case "domain":
+		if value == "" {
+			return nil, fmt.Errorf("empty domain")
+		}
+		return regexp.Compile(`^[a-z]+:(.+\.)?` + regexp.QuoteMeta(strings.ToLower(value)) + `:\d+$`)
+	case "full":
+		if value == "" {
+			return nil, fmt.Errorf("empty domain")
+		}
+		return regexp.Compile(`^[a-z]+:` + regexp.QuoteMeta(strings.ToLower(value)) + `:\d+$`)
+	case "keyword":
+		if value == "" {
+			return nil, fmt.Errorf("empty keyword")
+		}
+		return regexp.Compile(regexp.QuoteMeta(value))
+	case "port":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid port: %s", value)
+		}
+		return regexp.Compile(`:` + strconv.FormatUint(port, 10) + `$`)
+	default:
+		// Not a known type, e.g. a regexp containing a colon
+		return regexp.Compile(line)
+	}
+}
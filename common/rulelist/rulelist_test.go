@@ -0,0 +1,103 @@
+package rulelist_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Xray-P/common/rulelist"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "rulelist")
+	writeFile(t, root, `# local rules
+(.+\.|^)(360|so)\.(cn|com)
+domain:baidu.com
+full:example.org
+keyword:torrent
+port:25
+(unclosed
+include extra
+include rulelist
+`)
+	writeFile(t, filepath.Join(dir, "extra", "a.list"), "// shared\nfull:a.com\n")
+	writeFile(t, filepath.Join(dir, "extra", "b.list"), "port:abc\n")
+
+	list := rulelist.Load(root)
+	if len(list.Rules) != 6 {
+		t.Fatalf("expected 6 rules, got %d", len(list.Rules))
+	}
+	if len(list.Errors) != 3 {
+		t.Fatalf("expected 3 errors, got %v", list.Errors)
+	}
+	if !strings.HasSuffix(list.Errors[0].Error(), "rulelist:7: error parsing regexp: missing closing ): `(unclosed`") {
+		t.Errorf("unexpected error: %s", list.Errors[0])
+	}
+	if !strings.Contains(list.Errors[2].Error(), "include cycle") {
+		t.Errorf("expected include cycle, got %s", list.Errors[2])
+	}
+
+	cases := map[string]string{
+		"tcp:www.baidu.com:443":    "domain:baidu.com",
+		"tcp:baidu.com:80":         "domain:baidu.com",
+		"tcp:example.org:443":      "full:example.org",
+		"udp:tracker.torrent.io:1": "keyword:torrent",
+		"tcp:1.2.3.4:25":           "port:25",
+		"tcp:a.com:443":            "full:a.com",
+		"tcp:www.so.com:443":       `(.+\.|^)(360|so)\.(cn|com)`,
+		"tcp:sub.example.org:443":  "",
+		"tcp:notbaidu.com:443":     "",
+		"tcp:1.2.3.4:250":          "",
+	}
+	for dest, want := range cases {
+		r := list.Match(dest)
+		got := ""
+		if r != nil {
+			got = r.Raw
+		}
+		if got != want {
+			t.Errorf("%s: expected %q, got %q", dest, want, got)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "rulelist")
+	writeFile(t, root, "include extra.list\n")
+	writeFile(t, filepath.Join(dir, "extra.list"), "full:a.com\n")
+
+	reloaded := make(chan *rulelist.List, 1)
+	list, watcher, err := rulelist.Watch(root, func(l *rulelist.List) { reloaded <- l })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	if len(list.Rules) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(list.Rules))
+	}
+
+	// Changing an included file reloads the whole list
+	writeFile(t, filepath.Join(dir, "extra.list"), "full:a.com\nfull:b.com\n")
+	select {
+	case l := <-reloaded:
+		if len(l.Rules) != 2 {
+			t.Errorf("expected 2 rules after reload, got %d", len(l.Rules))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("rule list was not reloaded")
+	}
+}
@@ -0,0 +1,128 @@
+package rulelist
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const reloadDelay = 500 * time.Millisecond
+
+// Watcher reloads a rule list when any of its files changes on disk
+type Watcher struct {
+	path     string
+	onReload func(*List)
+	watcher  *fsnotify.Watcher
+	access   sync.Mutex
+	files    map[string]bool // files and directories the current list was read from
+	dirs     map[string]bool // directories added to the fsnotify watcher
+	timer    *time.Timer
+	done     chan struct{}
+}
+
+// Watch loads the rule list at path and calls onReload with every new
+// version read after a change. The initial list is returned directly.
+func Watch(path string, onReload func(*List)) (*List, *Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return Load(path), nil, err
+	}
+	w := &Watcher{
+		path:     path,
+		onReload: onReload,
+		watcher:  fw,
+		dirs:     make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+	list := Load(path)
+	w.update(list)
+	go w.run()
+	return list, w, nil
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	w.access.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.access.Unlock()
+	close(w.done)
+	return w.watcher.Close()
+}
+
+// update watches the directories of every file used by the list
+func (w *Watcher) update(list *List) {
+	w.access.Lock()
+	defer w.access.Unlock()
+	w.files = make(map[string]bool)
+	for _, f := range list.Files {
+		w.files[f] = true
+		dir := f
+		if info, err := os.Stat(f); err != nil || !info.IsDir() {
+			dir = filepath.Dir(f)
+		}
+		if !w.dirs[dir] {
+			if err := w.watcher.Add(dir); err == nil {
+				w.dirs[dir] = true
+			}
+		}
+	}
+	// Also watch the root, so that a missing file is picked up once created
+	if abs, err := filepath.Abs(w.path); err == nil {
+		w.files[abs] = true
+		if dir := filepath.Dir(abs); !w.dirs[dir] {
+			if err := w.watcher.Add(dir); err == nil {
+				w.dirs[dir] = true
+			}
+		}
+	}
+}
+
+func (w *Watcher) relevant(name string) bool {
+	w.access.Lock()
+	defer w.access.Unlock()
+	name = filepath.Clean(name)
+	return w.files[name] || w.files[filepath.Dir(name)]
+}
+
+func (w *Watcher) run() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !w.relevant(event.Name) {
+				continue
+			}
+			// Editors write files in several steps, reload once they are done
+			w.access.Lock()
+			if w.timer != nil {
+				w.timer.Stop()
+			}
+			w.timer = time.AfterFunc(reloadDelay, w.reload)
+			w.access.Unlock()
+		case _, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	list := Load(w.path)
+	w.update(list)
+	w.onReload(list)
+}
@@ -0,0 +1,194 @@
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// includeKey lists the files merged under a mapping, the config file loader lowercases the keys
+	includeKey = "include"
+	filePrefix = "file:"
+	// Includes nested deeper are a loop
+	maxIncludeDepth = 16
+)
+
+// ${NAME}, ${NAME:-default}, and $${ for a literal ${
+var envRegexp = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// isSecretKey returns whether the value of the config key is a secret to redact
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	switch key {
+	case "apikey", "token", "secret", "privatekey", "dnsenv", "eabhmackey":
+		return true
+	}
+	return strings.Contains(key, "password")
+}
+
+type expander struct {
+	errs     []error
+	includes []string
+}
+
+// Expand returns the settings of the config file with:
+//   - the files listed by the Include key of a mapping merged under its own keys,
+//     so a shared node template can be included by many nodes
+//   - ${NAME} replaced by the environment variable NAME, or by default with ${NAME:-default}
+//   - the values written file:/path replaced by the content of the file, without the
+//     trailing newline, e.g. a Kubernetes or systemd credential
+//
+// Relative paths are relative to dir, the directory of the config file. The values of
+// the secret keys and of the files are registered for redaction. The settings are
+// returned even with errors, with the values in error left as they are.
+func Expand(settings map[string]interface{}, dir string) (map[string]interface{}, error) {
+	e := &expander{}
+	expanded := e.object(settings, dir, "", false)
+	return expanded, errors.Join(e.errs...)
+}
+
+func (e *expander) value(v interface{}, dir string, key string, secret bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return e.object(v, dir, key, secret)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = e.value(v[i], dir, fmt.Sprintf("%s[%d]", key, i), secret)
+		}
+		return values
+	case string:
+		return e.string(v, dir, key, secret)
+	default:
+		return v
+	}
+}
+
+func (e *expander) object(m map[string]interface{}, dir string, path string, secret bool) map[string]interface{} {
+	object := make(map[string]interface{}, len(m))
+	var includes interface{}
+	for k, v := range m {
+		if strings.EqualFold(k, includeKey) {
+			includes = v
+			continue
+		}
+		key := k
+		if path != "" {
+			key = path + "." + k
+		}
+		object[k] = e.value(v, dir, key, secret || isSecretKey(k))
+	}
+	if includes == nil {
+		return object
+	}
+
+	var files []string
+	switch v := includes.(type) {
+	case string:
+		files = []string{v}
+	case []interface{}:
+		for _, file := range v {
+			if s, ok := file.(string); ok {
+				files = append(files, s)
+			}
+		}
+	}
+	base := make(map[string]interface{})
+	for _, file := range files {
+		included, err := e.include(e.string(file, dir, path, false), dir)
+		if err != nil {
+			e.errs = append(e.errs, err)
+			continue
+		}
+		base = merge(base, included)
+	}
+	return merge(base, object)
+}
+
+// include reads and expands an included file, of any format of the config file
+func (e *expander) include(file string, dir string) (map[string]interface{}, error) {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	for _, f := range e.includes {
+		if f == file {
+			return nil, fmt.Errorf("include loop: %s", strings.Join(append(e.includes, file), " > "))
+		}
+	}
+	if len(e.includes) >= maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested too deep at %s", file)
+	}
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("read include %s failed: %s", file, err)
+	}
+	e.includes = append(e.includes, file)
+	defer func() { e.includes = e.includes[:len(e.includes)-1] }()
+	return e.object(v.AllSettings(), filepath.Dir(file), "", false), nil
+}
+
+func (e *expander) string(s string, dir string, key string, secret bool) string {
+	expanded := envRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		groups := envRegexp.FindStringSubmatch(match)
+		if value, ok := os.LookupEnv(groups[1]); ok {
+			return value
+		}
+		if groups[2] != "" {
+			return groups[3]
+		}
+		e.errs = append(e.errs, fmt.Errorf("%s: environment variable %s is not set", key, groups[1]))
+		return match
+	})
+	if file, ok := strings.CutPrefix(expanded, filePrefix); ok {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: read secret file failed: %s", key, err))
+			return s
+		}
+		expanded = strings.TrimRight(string(data), "\r\n")
+		secret = true
+	}
+	if secret {
+		Register(expanded)
+	}
+	return expanded
+}
+
+// merge returns base with the keys of over merged in, recursively for the mappings.
+// Keys are matched regardless of case.
+func merge(base map[string]interface{}, over map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		for existing := range merged {
+			if existing != k && strings.EqualFold(existing, k) {
+				merged[k] = merged[existing]
+				delete(merged, existing)
+				break
+			}
+		}
+		baseObject, ok1 := merged[k].(map[string]interface{})
+		overObject, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			merged[k] = merge(baseObject, overObject)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,84 @@
+// Package secret expands the environment variables, secret files and includes of the
+// config file, and redacts the secrets it read from the logs and config dumps
+package secret
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Redacted replaces the secrets
+	Redacted = "******"
+	// Shorter values are not redacted, they would mangle unrelated numbers and words
+	minLength = 4
+)
+
+var (
+	access   sync.RWMutex
+	secrets  = make(map[string]struct{})
+	replacer = strings.NewReplacer()
+)
+
+// Register adds values to the secrets redacted by Redact
+func Register(values ...string) {
+	access.Lock()
+	defer access.Unlock()
+	added := false
+	for _, v := range values {
+		if len(v) < minLength {
+			continue
+		}
+		if _, ok := secrets[v]; !ok {
+			secrets[v] = struct{}{}
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+	// The longest secrets first, so a secret containing another one is redacted whole
+	sorted := make([]string, 0, len(secrets))
+	for s := range secrets {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, s := range sorted {
+		pairs = append(pairs, s, Redacted)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces the registered secrets in s
+func Redact(s string) string {
+	access.RLock()
+	defer access.RUnlock()
+	return replacer.Replace(s)
+}
+
+// Hook redacts the registered secrets from the message and the fields of the log entries
+type Hook struct{}
+
+func (Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (Hook) Fire(entry *logrus.Entry) error {
+	entry.Message = Redact(entry.Message)
+	// The entry is a copy, its fields can be changed
+	for k, v := range entry.Data {
+		switch v := v.(type) {
+		case string:
+			entry.Data[k] = Redact(v)
+		case error:
+			if s := Redact(v.Error()); s != v.Error() {
+				entry.Data[k] = s
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "api_key"), []byte("key-from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// A shared node template, including the defaults of another file
+	if err := os.WriteFile(filepath.Join(dir, "node.yml"), []byte(`
+Include: defaults.yml
+ApiConfig:
+  ApiHost: https://${PANEL_HOST}
+  ApiKey: file:api_key
+ControllerConfig:
+  UpdatePeriodic: 60
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "defaults.yml"), []byte(`
+ApiConfig:
+  Timeout: 30
+ControllerConfig:
+  ListenIP: 0.0.0.0
+  UpdatePeriodic: 30
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PANEL_HOST", "panel.test.com")
+	t.Setenv("REDIS_PASSWORD", "redis-secret")
+
+	settings := map[string]interface{}{
+		"nodes": []interface{}{
+			map[string]interface{}{
+				"include":   "node.yml",
+				"apiconfig": map[string]interface{}{"nodeid": 41},
+				"controllerconfig": map[string]interface{}{
+					"globaldevicelimitconfig": map[string]interface{}{"redispassword": "${REDIS_PASSWORD}"},
+					"certconfig": map[string]interface{}{
+						"certdomain": "${CERT_DOMAIN:-node.test.com}",
+						"dnsenv":     map[string]interface{}{"CF_DNS_API_TOKEN": "cf-token"},
+					},
+				},
+			},
+		},
+		"logconfig": map[string]interface{}{"level": "$${LEVEL} $HOME"},
+	}
+	expanded, err := Expand(settings, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"nodes": []interface{}{
+			map[string]interface{}{
+				"apiconfig": map[string]interface{}{
+					"apihost": "https://panel.test.com",
+					"apikey":  "key-from-file",
+					"timeout": 30,
+					"nodeid":  41,
+				},
+				"controllerconfig": map[string]interface{}{
+					"listenip":                "0.0.0.0",
+					"updateperiodic":          60,
+					"globaldevicelimitconfig": map[string]interface{}{"redispassword": "redis-secret"},
+					"certconfig": map[string]interface{}{
+						"certdomain": "node.test.com",
+						"dnsenv":     map[string]interface{}{"CF_DNS_API_TOKEN": "cf-token"},
+					},
+				},
+			},
+		},
+		"logconfig": map[string]interface{}{"level": "${LEVEL} $HOME"},
+	}
+	if !reflect.DeepEqual(expanded, want) {
+		t.Errorf("unexpected settings:\n%v\nwant:\n%v", expanded, want)
+	}
+
+	redacted := Redact("ApiKey=key-from-file redis=redis-secret cf=cf-token host=panel.test.com")
+	if redacted != "ApiKey=****** redis=****** cf=****** host=panel.test.com" {
+		t.Errorf("unexpected redacted string: %s", redacted)
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.yml"), []byte("Include: b.yml\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.yml"), []byte("Include: a.yml\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]interface{}{
+		"apikey":  "${UNSET_TEST_VARIABLE}",
+		"token":   "file:missing",
+		"include": []interface{}{"a.yml"},
+	}
+	expanded, err := Expand(settings, dir)
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(errs) != 3 {
+		t.Errorf("expected 3 errors, got %d: %v", len(errs), err)
+	}
+	for _, s := range []string{"UNSET_TEST_VARIABLE is not set", "read secret file failed", "include loop"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("missing error %q in %v", s, err)
+		}
+	}
+	// The values in error are left as they are
+	if expanded["apikey"] != "${UNSET_TEST_VARIABLE}" || expanded["token"] != "file:missing" {
+		t.Errorf("unexpected settings: %v", expanded)
+	}
+}
+
+func TestHook(t *testing.T) {
+	Register("hook-secret-value", "abc")
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.AddHook(Hook{})
+	logger.WithField("key", "hook-secret-value").WithError(errors.New("auth hook-secret-value failed")).
+		Error("request with abc and hook-secret-value failed")
+	out := buf.String()
+	if strings.Contains(out, "hook-secret-value") {
+		t.Errorf("secret not redacted: %s", out)
+	}
+	// Too short to be redacted
+	if !strings.Contains(out, "abc") {
+		t.Errorf("short value redacted: %s", out)
+	}
+}
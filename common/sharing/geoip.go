@@ -0,0 +1,106 @@
+package sharing
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/xtls/xray-core/app/router"
+	"github.com/xtls/xray-core/common/platform/filesystem"
+	"google.golang.org/protobuf/proto"
+)
+
+const defaultGeoIPFile = "geoip.dat"
+
+// Locator resolves an IP address to its country code and ASN
+type Locator interface {
+	Locate(ip net.IP) (country string, asn string)
+}
+
+type geoEntry struct {
+	code    string
+	matcher *router.GeoIPMatcher
+}
+
+// GeoLocator looks up IP addresses in the bundled geoip database
+type GeoLocator struct {
+	countries []geoEntry
+	asns      []geoEntry
+}
+
+var (
+	locatorAccess sync.Mutex
+	locatorCache  = make(map[string]*GeoLocator)
+)
+
+// NewGeoLocator loads the country database and, if given, the ASN database.
+// Locators are shared between nodes using the same files.
+func NewGeoLocator(geoIPFile, asnFile string) (*GeoLocator, error) {
+	if geoIPFile == "" {
+		geoIPFile = defaultGeoIPFile
+	}
+	key := geoIPFile + "|" + asnFile
+
+	locatorAccess.Lock()
+	defer locatorAccess.Unlock()
+	if l, ok := locatorCache[key]; ok {
+		return l, nil
+	}
+
+	countries, err := loadGeoEntries(geoIPFile)
+	if err != nil {
+		return nil, err
+	}
+	l := &GeoLocator{countries: countries}
+	if asnFile != "" {
+		if l.asns, err = loadGeoEntries(asnFile); err != nil {
+			return nil, err
+		}
+	}
+	locatorCache[key] = l
+	return l, nil
+}
+
+func loadGeoEntries(file string) ([]geoEntry, error) {
+	data, err := filesystem.ReadAsset(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", file, err)
+	}
+	var list router.GeoIPList
+	if err := proto.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %s", file, err)
+	}
+
+	entries := make([]geoEntry, 0, len(list.Entry))
+	for _, geoip := range list.Entry {
+		code := strings.ToUpper(geoip.CountryCode)
+		// Private ranges are not a location
+		if code == "PRIVATE" {
+			continue
+		}
+		m := &router.GeoIPMatcher{}
+		if err := m.Init(geoip.Cidr); err != nil {
+			return nil, fmt.Errorf("failed to load %s from %s: %s", code, file, err)
+		}
+		entries = append(entries, geoEntry{code: code, matcher: m})
+	}
+	return entries, nil
+}
+
+// Locate implements Locator
+func (l *GeoLocator) Locate(ip net.IP) (country string, asn string) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return match(l.countries, ip), match(l.asns, ip)
+}
+
+func match(entries []geoEntry, ip net.IP) string {
+	for _, e := range entries {
+		if e.matcher.Match(ip) {
+			return e.code
+		}
+	}
+	return ""
+}
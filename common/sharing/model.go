@@ -0,0 +1,20 @@
+package sharing
+
+type Config struct {
+	Enable       bool   `mapstructure:"Enable"`
+	Window       int    `mapstructure:"Window"`       // second
+	MaxCountries int    `mapstructure:"MaxCountries"` // distinct countries allowed inside the window
+	CheckASN     bool   `mapstructure:"CheckASN"`
+	MaxASNs      int    `mapstructure:"MaxASNs"`   // distinct ASNs allowed inside the window
+	GeoIPFile    string `mapstructure:"GeoIPFile"` // geoip.dat in the asset location by default
+	ASNFile      string `mapstructure:"ASNFile"`   // geoip-style dat file keyed by AS number, e.g. AS13335
+	RuleID       int    `mapstructure:"RuleID"`    // detect rule ID used when reporting to the panel, 0 means log only
+}
+
+// Finding describes a user that was seen from too many places at the same time
+type Finding struct {
+	UID       int
+	IPs       []string
+	Countries []string
+	ASNs      []string
+}
@@ -0,0 +1,148 @@
+// Package sharing detects accounts that are used from geographically distant places at the same time
+package sharing
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"Xray-P/api"
+)
+
+type sighting struct {
+	country  string
+	asn      string
+	lastSeen time.Time
+}
+
+// Detector keeps the recent IPs of each user and flags users whose IPs
+// spread over more countries or ASNs than allowed inside the window.
+type Detector struct {
+	config   *Config
+	locator  Locator
+	access   sync.Mutex
+	seen     map[int]map[string]*sighting // Key: UID, value: {Key: IP, value: sighting}
+	reported map[int]time.Time            // Key: UID, value: last time the user was flagged
+	now      func() time.Time
+}
+
+// New return a Detector using the geoip database described by config
+func New(config *Config) (*Detector, error) {
+	locator, err := NewGeoLocator(config.GeoIPFile, config.ASNFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithLocator(config, locator), nil
+}
+
+// NewWithLocator return a Detector using a custom Locator
+func NewWithLocator(config *Config, locator Locator) *Detector {
+	c := *config
+	if c.Window <= 0 {
+		c.Window = 600
+	}
+	if c.MaxCountries <= 0 {
+		c.MaxCountries = 1
+	}
+	if c.MaxASNs <= 0 {
+		c.MaxASNs = 1
+	}
+	return &Detector{
+		config:   &c,
+		locator:  locator,
+		seen:     make(map[int]map[string]*sighting),
+		reported: make(map[int]time.Time),
+		now:      time.Now,
+	}
+}
+
+// RuleID returns the detect rule ID findings are reported under
+func (d *Detector) RuleID() int {
+	return d.config.RuleID
+}
+
+// Feed records the online users of a reporting period and returns the users
+// that should be flagged. A user is flagged at most once per window.
+func (d *Detector) Feed(onlineUsers *[]api.OnlineUser) []Finding {
+	d.access.Lock()
+	defer d.access.Unlock()
+
+	now := d.now()
+	window := time.Duration(d.config.Window) * time.Second
+
+	for _, u := range *onlineUsers {
+		ip := net.ParseIP(u.IP)
+		if ip == nil {
+			continue
+		}
+		ipMap, ok := d.seen[u.UID]
+		if !ok {
+			ipMap = make(map[string]*sighting)
+			d.seen[u.UID] = ipMap
+		}
+		if s, ok := ipMap[u.IP]; ok {
+			s.lastSeen = now
+			continue
+		}
+		country, asn := d.locator.Locate(ip)
+		ipMap[u.IP] = &sighting{country: country, asn: asn, lastSeen: now}
+	}
+
+	var findings []Finding
+	for uid, ipMap := range d.seen {
+		countries := make(map[string]struct{})
+		asns := make(map[string]struct{})
+		ips := make([]string, 0, len(ipMap))
+		for ip, s := range ipMap {
+			if now.Sub(s.lastSeen) > window {
+				delete(ipMap, ip)
+				continue
+			}
+			ips = append(ips, ip)
+			if s.country != "" {
+				countries[s.country] = struct{}{}
+			}
+			if s.asn != "" {
+				asns[s.asn] = struct{}{}
+			}
+		}
+		if len(ipMap) == 0 {
+			delete(d.seen, uid)
+			continue
+		}
+
+		if len(countries) <= d.config.MaxCountries && (!d.config.CheckASN || len(asns) <= d.config.MaxASNs) {
+			continue
+		}
+		if last, ok := d.reported[uid]; ok && now.Sub(last) < window {
+			continue
+		}
+		d.reported[uid] = now
+		sort.Strings(ips)
+		findings = append(findings, Finding{
+			UID:       uid,
+			IPs:       ips,
+			Countries: sortedKeys(countries),
+			ASNs:      sortedKeys(asns),
+		})
+	}
+
+	for uid, last := range d.reported {
+		if now.Sub(last) >= window {
+			delete(d.reported, uid)
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool { return findings[i].UID < findings[j].UID })
+	return findings
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,64 @@
+package sharing_test
+
+import (
+	"net"
+	"testing"
+
+	"Xray-P/api"
+	"Xray-P/common/sharing"
+)
+
+type stubLocator map[string][2]string
+
+func (s stubLocator) Locate(ip net.IP) (string, string) {
+	v := s[ip.String()]
+	return v[0], v[1]
+}
+
+var locator = stubLocator{
+	"1.1.1.1": {"US", "AS13335"},
+	"1.0.0.1": {"US", "AS13335"},
+	"8.8.8.8": {"US", "AS15169"},
+	"2.2.2.2": {"FR", "AS3215"},
+}
+
+func TestDetectCountries(t *testing.T) {
+	d := sharing.NewWithLocator(&sharing.Config{Enable: true, Window: 600, RuleID: 7}, locator)
+
+	findings := d.Feed(&[]api.OnlineUser{{UID: 1, IP: "1.1.1.1"}, {UID: 1, IP: "1.0.0.1"}, {UID: 2, IP: "8.8.8.8"}})
+	if len(findings) != 0 {
+		t.Fatalf("expected no finding, got %v", findings)
+	}
+
+	// The second country shows up in a later period, still inside the window
+	findings = d.Feed(&[]api.OnlineUser{{UID: 1, IP: "2.2.2.2"}})
+	if len(findings) != 1 || findings[0].UID != 1 {
+		t.Fatalf("expected UID 1 to be flagged, got %v", findings)
+	}
+	if len(findings[0].Countries) != 2 || len(findings[0].IPs) != 3 {
+		t.Errorf("unexpected finding: %+v", findings[0])
+	}
+	if d.RuleID() != 7 {
+		t.Errorf("expected rule ID 7, got %d", d.RuleID())
+	}
+
+	// Do not flag the same user twice inside one window
+	if findings = d.Feed(&[]api.OnlineUser{{UID: 1, IP: "2.2.2.2"}}); len(findings) != 0 {
+		t.Errorf("expected no repeated finding, got %v", findings)
+	}
+}
+
+func TestDetectASN(t *testing.T) {
+	online := &[]api.OnlineUser{{UID: 3, IP: "1.1.1.1"}, {UID: 3, IP: "8.8.8.8"}}
+
+	d := sharing.NewWithLocator(&sharing.Config{Enable: true}, locator)
+	if findings := d.Feed(online); len(findings) != 0 {
+		t.Errorf("ASN check disabled, expected no finding, got %v", findings)
+	}
+
+	d = sharing.NewWithLocator(&sharing.Config{Enable: true, CheckASN: true}, locator)
+	findings := d.Feed(online)
+	if len(findings) != 1 || len(findings[0].ASNs) != 2 {
+		t.Errorf("expected UID 3 to be flagged by ASN, got %v", findings)
+	}
+}
@@ -0,0 +1,62 @@
+package panel
+
+import "fmt"
+
+// Check builds the core sections and validates every node of config offline, it
+// returns every problem found instead of stopping at the first one
+func Check(config *Config) []error {
+	var errs []error
+	if _, err := buildCore(config); err != nil {
+		errs = append(errs, UnwrapJoined(err)...)
+	}
+	if len(config.NodesConfig) == 0 {
+		errs = append(errs, fmt.Errorf("no node is configured in Nodes"))
+	}
+	for i, nodeConfig := range config.NodesConfig {
+		if nodeConfig.ApiConfig == nil {
+			errs = append(errs, fmt.Errorf("node %d: ApiConfig is required", i))
+			continue
+		}
+		prefix := fmt.Sprintf("node %d (NodeID %d)", i, nodeConfig.ApiConfig.NodeID)
+		apiConfig := nodeConfig.ApiConfig
+		if apiConfig.APIHost == "" {
+			errs = append(errs, fmt.Errorf("%s: ApiHost is required", prefix))
+		}
+		if apiConfig.Key == "" {
+			errs = append(errs, fmt.Errorf("%s: ApiKey is required", prefix))
+		}
+		if apiConfig.NodeID <= 0 {
+			errs = append(errs, fmt.Errorf("%s: NodeID must be positive", prefix))
+		}
+		if apiConfig.NodeType == "" {
+			errs = append(errs, fmt.Errorf("%s: NodeType is required", prefix))
+		}
+	}
+	controllerConfigs, err := buildControllerConfigs(config)
+	if err != nil {
+		// A node without ApiConfig, reported above
+		return errs
+	}
+	for i, controllerConfig := range controllerConfigs {
+		if err := controllerConfig.Validate(); err != nil {
+			for _, err := range UnwrapJoined(err) {
+				errs = append(errs, fmt.Errorf("node %d (NodeID %d): %s", i, config.NodesConfig[i].ApiConfig.NodeID, err))
+			}
+		}
+	}
+	return errs
+}
+
+// UnwrapJoined splits an error built by errors.Join, recursively. Another error is
+// returned alone.
+func UnwrapJoined(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, UnwrapJoined(err)...)
+	}
+	return errs
+}
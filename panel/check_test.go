@@ -0,0 +1,39 @@
+package panel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"Xray-P/api"
+	"Xray-P/service/controller"
+)
+
+func TestCheck(t *testing.T) {
+	outbound := filepath.Join(t.TempDir(), "custom_outbound.json")
+	os.WriteFile(outbound, []byte(`[{"tag": "a", "protocol": "nonexistent"}, {"tag": "b", "protocol": "freedom"}, {"tag": "c", "protocol": "unknown"}]`), 0o600)
+	config := &Config{
+		ConnectionConfig:   &ConnectionConfig{ConnIdle: 30},
+		RouteConfigPath:    "/nonexistent/route.json",
+		OutboundConfigPath: outbound,
+		NodesConfig: []*NodesConfig{
+			{
+				PanelType:        "SSpanel",
+				ApiConfig:        &api.Config{APIHost: "http://127.0.0.1:667", Key: "123", NodeID: 41, NodeType: "V2ray"},
+				ControllerConfig: &controller.Config{ListenIP: "0.0.0.0", EnableFallback: true},
+			},
+			{PanelType: "SSpanel", ApiConfig: &api.Config{APIHost: "http://127.0.0.1:667"}},
+		},
+	}
+	// Route file, 2 outbounds, node 41 fallbacks, node 2 key, node id and type
+	if errs := Check(config); len(errs) != 7 {
+		t.Errorf("got %d problems, want 7: %v", len(errs), errs)
+	}
+
+	config.RouteConfigPath, config.OutboundConfigPath = "", ""
+	config.NodesConfig = config.NodesConfig[:1]
+	config.NodesConfig[0].ControllerConfig.EnableFallback = false
+	if errs := Check(config); len(errs) != 0 {
+		t.Errorf("valid config: %v", errs)
+	}
+}
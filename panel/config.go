@@ -1,7 +1,18 @@
 package panel
 
 import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"Xray-P/common/event"
+	"Xray-P/common/flow"
+	xraylog "Xray-P/common/log"
+	"Xray-P/common/metrics"
+	"Xray-P/common/secret"
+	"Xray-P/service/admin"
 	"Xray-P/service/controller"
+	"Xray-P/service/health"
 
 	"Xray-P/api"
 )
@@ -13,7 +24,19 @@ type Config struct {
 	OutboundConfigPath    string            `mapstructure:"OutboundConfigPath"`
 	ObservatoryConfigPath string            `mapstructure:"ObservatoryConfigPath"`
 	RouteConfigPath       string            `mapstructure:"RouteConfigPath"`
+	DnsConfig             interface{}       `mapstructure:"DnsConfig"`         // Inline DnsConfigPath, a mapping or a JSON string
+	InboundConfig         interface{}       `mapstructure:"InboundConfig"`     // Inline InboundConfigPath, a list or a JSON string
+	OutboundConfig        interface{}       `mapstructure:"OutboundConfig"`    // Inline OutboundConfigPath, a list or a JSON string
+	ObservatoryConfig     interface{}       `mapstructure:"ObservatoryConfig"` // Inline ObservatoryConfigPath, a mapping or a JSON string
+	RouteConfig           interface{}       `mapstructure:"RouteConfig"`       // Inline RouteConfigPath, a mapping or a JSON string
 	ConnectionConfig      *ConnectionConfig `mapstructure:"ConnectionConfig"`
+	MetricsConfig         *metrics.Config   `mapstructure:"Metrics"`
+	AdminConfig           *admin.Config     `mapstructure:"Admin"`
+	HealthConfig          *health.Config    `mapstructure:"Health"`
+	APIConfig             *APIConfig        `mapstructure:"Api"`
+	FlowConfig            *flow.Config      `mapstructure:"Flow"`
+	EventsConfig          *event.Config     `mapstructure:"Events"`
+	DrainTimeout          int               `mapstructure:"DrainTimeout"` // Seconds to wait for the connections to close on shutdown
 	NodesConfig           []*NodesConfig    `mapstructure:"Nodes"`
 }
 
@@ -24,9 +47,19 @@ type NodesConfig struct {
 }
 
 type LogConfig struct {
-	Level      string `mapstructure:"Level"`
-	AccessPath string `mapstructure:"AccessPath"`
-	ErrorPath  string `mapstructure:"ErrorPath"`
+	Level      string                `mapstructure:"Level"`
+	Format     string                `mapstructure:"Format"`     // text or json
+	AccessMode string                `mapstructure:"AccessMode"` // request or connection
+	AccessPath string                `mapstructure:"AccessPath"`
+	ErrorPath  string                `mapstructure:"ErrorPath"`
+	Rotate     *xraylog.RotateConfig `mapstructure:"Rotate"`
+}
+
+// APIConfig is the gRPC commander of the Xray core, used by "xray api" and other Xray tooling
+type APIConfig struct {
+	Enable   bool     `mapstructure:"Enable"`
+	Listen   string   `mapstructure:"Listen"`
+	Services []string `mapstructure:"Services"`
 }
 
 type ConnectionConfig struct {
@@ -36,3 +69,82 @@ type ConnectionConfig struct {
 	DownlinkOnly uint32 `mapstructure:"downlinkOnly"`
 	BufferSize   int32  `mapstructure:"bufferSize"`
 }
+
+// Files returns the paths of the JSON files of the core sections, a change of any
+// of them needs a reload
+func (c *Config) Files() []string {
+	var files []string
+	for _, path := range []string{c.DnsConfigPath, c.RouteConfigPath, c.InboundConfigPath, c.OutboundConfigPath, c.ObservatoryConfigPath} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// ReadInlineSections sets the inline core sections of c from data, the YAML or JSON
+// config file. The settings of the config file split the keys at the dots and lowercase
+// them, the domains of the DNS hosts or of the rules would be lost, so the sections are
+// read from the file itself. Their environment variables and secret files are expanded
+// relative to dir.
+func (c *Config) ReadInlineSections(data []byte, dir string) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	for name, section := range map[string]*interface{}{
+		"DnsConfig":         &c.DnsConfig,
+		"InboundConfig":     &c.InboundConfig,
+		"OutboundConfig":    &c.OutboundConfig,
+		"ObservatoryConfig": &c.ObservatoryConfig,
+		"RouteConfig":       &c.RouteConfig,
+	} {
+		_, node := mappingValue(doc.Content[0], name)
+		if node == nil {
+			continue
+		}
+		value, err := nodeValue(node)
+		if err != nil {
+			return fmt.Errorf("read inline %s config failed: %s", name, err)
+		}
+		// The errors are the ones of the whole config file, reported when it is expanded
+		expanded, _ := secret.Expand(map[string]interface{}{name: value}, dir)
+		*section = expanded[name]
+	}
+	return nil
+}
+
+// nodeValue returns the value of node, with the keys of its mappings as written
+func nodeValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := nodeValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[node.Content[i].Value] = value
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		values := make([]interface{}, len(node.Content))
+		for i := range node.Content {
+			value, err := nodeValue(node.Content[i])
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case yaml.AliasNode:
+		return nodeValue(node.Alias)
+	default:
+		var value interface{}
+		err := node.Decode(&value)
+		return value, err
+	}
+}
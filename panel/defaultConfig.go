@@ -5,11 +5,20 @@ import "Xray-P/service/controller"
 func getDefaultLogConfig() *LogConfig {
 	return &LogConfig{
 		Level:      "none",
+		Format:     "text",
+		AccessMode: "request",
 		AccessPath: "",
 		ErrorPath:  "",
 	}
 }
 
+func getDefaultAPIConfig() *APIConfig {
+	return &APIConfig{
+		Listen:   "127.0.0.1:10085",
+		Services: []string{"HandlerService", "StatsService", "LoggerService"},
+	}
+}
+
 func getDefaultConnectionConfig() *ConnectionConfig {
 	return &ConnectionConfig{
 		Handshake:    4,
@@ -0,0 +1,268 @@
+package panel
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/xtls/xray-core/infra/conf"
+
+	"Xray-P/api"
+	"Xray-P/api/sspanel"
+	"Xray-P/common/secret"
+	"Xray-P/service/controller"
+)
+
+// DefaultDumpUsers is the number of users of each node put in a dump
+const DefaultDumpUsers = 3
+
+// maskedSecret replaces the secrets which are not keys of a fixed length
+const maskedSecret = "******"
+
+// secretKeys are the JSON keys holding secrets in the Xray config
+var secretKeys = map[string]bool{
+	"password":     true,
+	"pass":         true,
+	"privateKey":   true,
+	"secretKey":    true,
+	"preSharedKey": true,
+	"psk":          true,
+}
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// xrayConfig is the JSON config file of xray run
+type xrayConfig struct {
+	Log              interface{}   `json:"log"`
+	API              interface{}   `json:"api,omitempty"`
+	DNS              interface{}   `json:"dns,omitempty"`
+	Routing          interface{}   `json:"routing,omitempty"`
+	Policy           interface{}   `json:"policy"`
+	Stats            interface{}   `json:"stats"`
+	Observatory      interface{}   `json:"observatory,omitempty"`
+	BurstObservatory interface{}   `json:"burstObservatory,omitempty"`
+	Inbounds         []interface{} `json:"inbounds"`
+	Outbounds        []interface{} `json:"outbounds"`
+	// Not read by Xray, the local CAs of the nodes in CertMode self for the clients
+	CertAuthorities []interface{} `json:"certAuthorities,omitempty"`
+}
+
+// Dump returns the Xray config of the running panel in the JSON format of xray run,
+// with the node info last synced by the controllers. node selects a node by its tag
+// or node ID, every node when empty. Secrets are masked.
+func (p *Panel) Dump(node string, sampleUsers int) ([]byte, error) {
+	p.access.Lock()
+	config := p.panelConfig
+	p.access.Unlock()
+	var nodes []*controller.NodeConfig
+	for _, c := range p.Controllers() {
+		if node != "" && c.CurrentTag() != node && strconv.Itoa(c.ClientInfo().NodeID) != node {
+			continue
+		}
+		nodeConfig, err := c.Dump(sampleUsers)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, nodeConfig)
+	}
+	if node != "" && len(nodes) == 0 {
+		return nil, fmt.Errorf("no such node: %s", node)
+	}
+	return dumpConfig(config, nodes)
+}
+
+// Dump returns the Xray config of config in the JSON format of xray run, the node
+// info and the users are fetched from the panels. nodeID selects a node, every node
+// when 0. nodeInfo replaces the node info of the panel, it needs a single node.
+// Secrets are masked.
+func Dump(config *Config, nodeID int, nodeInfo *api.NodeInfo, sampleUsers int) ([]byte, error) {
+	controllerConfigs, err := buildControllerConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*controller.NodeConfig
+	for i, nodeConfig := range config.NodesConfig {
+		if nodeID != 0 && nodeConfig.ApiConfig.NodeID != nodeID {
+			continue
+		}
+		if nodeInfo != nil && len(nodes) > 0 {
+			return nil, fmt.Errorf("a node info file needs a single node, select one by its node ID")
+		}
+		info, users := nodeInfo, []api.UserInfo(nil)
+		if info == nil {
+			apiClient := sspanel.New(nodeConfig.ApiConfig)
+			if info, err = apiClient.GetNodeInfo(); err != nil {
+				return nil, fmt.Errorf("get node info of node %d failed: %s", nodeConfig.ApiConfig.NodeID, err)
+			}
+			userList, err := apiClient.GetUserList()
+			if err != nil {
+				return nil, fmt.Errorf("get user list of node %d failed: %s", nodeConfig.ApiConfig.NodeID, err)
+			}
+			users = *userList
+			if len(users) > sampleUsers {
+				users = users[:sampleUsers]
+			}
+		}
+		node, err := controller.BuildNodeConfig(controllerConfigs[i], info, controller.NodeTag(controllerConfigs[i], info), users)
+		if err != nil {
+			return nil, fmt.Errorf("build node %d failed: %s", nodeConfig.ApiConfig.NodeID, err)
+		}
+		nodes = append(nodes, node)
+	}
+	if nodeID != 0 && len(nodes) == 0 {
+		return nil, fmt.Errorf("no such node: %d", nodeID)
+	}
+	return dumpConfig(config, nodes)
+}
+
+// dumpConfig puts together the core sections of config and the nodes, the custom
+// inbounds and outbounds go first like in the running core
+func dumpConfig(config *Config, nodes []*controller.NodeConfig) ([]byte, error) {
+	logConfig, _, err := buildLogConfig(config.LogConfig)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := parseConnectionConfig(config.ConnectionConfig)
+	if err != nil {
+		return nil, err
+	}
+	dump := &xrayConfig{
+		Log: map[string]string{
+			"loglevel": logConfig.Level,
+			"access":   logConfig.AccessPath,
+			"error":    logConfig.ErrorPath,
+		},
+		Policy: &conf.PolicyConfig{Levels: map[uint32]*conf.Policy{0: policy}},
+		Stats:  struct{}{},
+	}
+	if config.APIConfig != nil && config.APIConfig.Enable {
+		apiConfig := getDefaultAPIConfig()
+		if config.APIConfig.Listen != "" {
+			apiConfig.Listen = config.APIConfig.Listen
+		}
+		if len(config.APIConfig.Services) > 0 {
+			apiConfig.Services = config.APIConfig.Services
+		}
+		dump.API = &conf.APIConfig{Tag: "api", Listen: apiConfig.Listen, Services: apiConfig.Services}
+	}
+
+	// The sections are read as loadCore does
+	for _, section := range []struct {
+		name   string
+		path   string
+		inline interface{}
+		v      interface{}
+	}{
+		{"DNS", config.DnsConfigPath, config.DnsConfig, &dump.DNS},
+		{"Routing", config.RouteConfigPath, config.RouteConfig, &dump.Routing},
+		{"Observatory", config.ObservatoryConfigPath, config.ObservatoryConfig, &dump.Observatory},
+		{"Custom Inbound", config.InboundConfigPath, config.InboundConfig, &dump.Inbounds},
+		{"Custom Outbound", config.OutboundConfigPath, config.OutboundConfig, &dump.Outbounds},
+	} {
+		if err := readJSONConfig(section.name, section.path, section.inline, section.v); err != nil {
+			return nil, err
+		}
+	}
+	if dump.Observatory != nil {
+		if data, err := json.Marshal(dump.Observatory); err == nil && isBurstObservatory(data) {
+			dump.Observatory, dump.BurstObservatory = nil, dump.Observatory
+		}
+	}
+	for _, node := range nodes {
+		for _, inbound := range node.Inbounds {
+			dump.Inbounds = append(dump.Inbounds, inbound)
+		}
+		for _, outbound := range node.Outbounds {
+			dump.Outbounds = append(dump.Outbounds, outbound)
+		}
+		for _, ca := range node.CertAuthorities {
+			dump.CertAuthorities = append(dump.CertAuthorities, ca)
+		}
+	}
+
+	// Round trip to mask the secrets whatever section they are in
+	data, err := json.Marshal(dump)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	masked := maskSecrets(v).(map[string]interface{})
+	inbounds, _ := masked["inbounds"].([]interface{})
+	outbounds, _ := masked["outbounds"].([]interface{})
+	certAuthorities, _ := masked["certAuthorities"].([]interface{})
+	// Back in the section order of xrayConfig
+	dump = &xrayConfig{
+		Log:              masked["log"],
+		API:              masked["api"],
+		DNS:              masked["dns"],
+		Routing:          masked["routing"],
+		Policy:           masked["policy"],
+		Stats:            masked["stats"],
+		Observatory:      masked["observatory"],
+		BurstObservatory: masked["burstObservatory"],
+		Inbounds:         inbounds,
+		Outbounds:        outbounds,
+		CertAuthorities:  certAuthorities,
+	}
+	out, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// The secrets of the config file may be anywhere, e.g. in a custom outbound
+	return append([]byte(secret.Redact(string(out))), '\n'), nil
+}
+
+// maskSecrets masks the secrets of the JSON value v and drops its null fields
+func maskSecrets(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if value == nil {
+				delete(v, key)
+				continue
+			}
+			if s, ok := value.(string); ok {
+				v[key] = maskSecret(key, s)
+				continue
+			}
+			v[key] = maskSecrets(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = maskSecrets(v[i])
+		}
+	}
+	return v
+}
+
+// maskSecret masks the value of key if it is a secret. The masked value stays valid
+// for xray run -test: keys keep their length and encoding, UUIDs keep their first
+// group so the users can still be told apart.
+func maskSecret(key string, value string) string {
+	if value == "" {
+		return value
+	}
+	if key == "id" {
+		if uuidRegexp.MatchString(value) {
+			return value[:8] + "-0000-0000-0000-000000000000"
+		}
+		return value
+	}
+	if !secretKeys[key] {
+		return value
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawURLEncoding, base64.RawStdEncoding} {
+		if b, err := encoding.DecodeString(value); err == nil && (len(b) == 16 || len(b) == 32) {
+			return encoding.EncodeToString(make([]byte, len(b)))
+		}
+	}
+	return maskedSecret
+}
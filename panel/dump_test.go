@@ -0,0 +1,124 @@
+package panel
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xtls/xray-core/common/cmdarg"
+	"github.com/xtls/xray-core/core"
+
+	"Xray-P/api"
+	"Xray-P/common/mylego"
+	"Xray-P/service/controller"
+)
+
+func TestDump(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XRAY_LOCATION_CONFIG", dir)
+	outbound := filepath.Join(dir, "custom_outbound.json")
+	os.WriteFile(outbound, []byte(`[{"tag": "proxy", "protocol": "socks", "settings": {"servers": [
+		{"address": "127.0.0.1", "port": 1080, "users": [{"user": "admin", "pass": "hunter2"}]}
+	]}}]`), 0o600)
+	const (
+		uuid        = "b831381d-6324-4d53-ad4f-8cda48b30811"
+		privateKey  = "YFb_H4dWm8gKGJmEjmDGLzkqQZcLN9AbTkZ-P-lpYGo"
+		ss2022Key   = "c2VjcmV0a2V5b2YxNmJ5dA=="
+		ssPassword  = "ss-secret"
+		ss2022Users = "dXNlcmtleW9mMTZieXRlcw=="
+	)
+	users := []api.UserInfo{{UID: 1, Email: "a@test.com", UUID: uuid, Passwd: ssPassword, Method: "aes-128-gcm"}}
+	// Users fetched from the panel are only in the running and online dumps, add them by hand
+	nodes := map[string]*api.NodeInfo{
+		"vless reality": {NodeType: "V2ray", NodeID: 1, Port: 10001, TransportProtocol: "tcp", EnableVless: true, VlessFlow: "xtls-rprx-vision"},
+		"vmess ws":      {NodeType: "V2ray", NodeID: 2, Port: 10002, TransportProtocol: "ws", Path: "/ws", Host: "test.com"},
+		"trojan":        {NodeType: "Trojan", NodeID: 3, Port: 10003, TransportProtocol: "tcp"},
+		"shadowsocks":   {NodeType: "Shadowsocks", NodeID: 4, Port: 10004, TransportProtocol: "tcp", CypherMethod: "aes-256-gcm"},
+		"ss2022":        {NodeType: "Shadowsocks", NodeID: 5, Port: 10005, TransportProtocol: "tcp", CypherMethod: "2022-blake3-aes-128-gcm", ServerKey: ss2022Key},
+		"ss plugin":     {NodeType: "Shadowsocks-Plugin", NodeID: 6, Port: 10006, TransportProtocol: "ws", Path: "/ss"},
+		"trojan self":   {NodeType: "Trojan", NodeID: 7, Port: 10007, TransportProtocol: "tcp", EnableTLS: true},
+	}
+	// The dump only reads the certificates, issue the one of CertMode self beforehand
+	selfCert := &mylego.CertConfig{CertMode: "self", CertDomain: "staging.test.com"}
+	lego, err := mylego.New(selfCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := lego.SelfCert(); err != nil {
+		t.Fatal(err)
+	}
+	for name, nodeInfo := range nodes {
+		controllerConfig := &controller.Config{ListenIP: "127.0.0.1", CertConfig: &mylego.CertConfig{CertMode: "none"}}
+		if name == "vless reality" {
+			controllerConfig.EnableREALITY = true
+			controllerConfig.REALITYConfigs = &controller.REALITYConfig{Dest: "www.amazon.com:443", ServerNames: []string{"www.amazon.com"}, PrivateKey: privateKey, ShortIds: []string{""}}
+		}
+		if name == "trojan self" {
+			controllerConfig.CertConfig = selfCert
+		}
+		nodeUsers := users
+		if name == "ss2022" {
+			nodeUsers = []api.UserInfo{{UID: 1, Email: "a@test.com", Passwd: ss2022Users}}
+		}
+		nodeConfig, err := controller.BuildNodeConfig(controllerConfig, nodeInfo, controller.NodeTag(controllerConfig, nodeInfo), nodeUsers)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		data, err := dumpConfig(&Config{OutboundConfigPath: outbound}, []*controller.NodeConfig{nodeConfig})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		for _, secret := range []string{uuid, privateKey, ss2022Key, ssPassword, ss2022Users, "hunter2"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s: secret %s not masked:\n%s", name, secret, data)
+			}
+		}
+		if !strings.Contains(string(data), "a@test.com|1") {
+			t.Errorf("%s: no sample user:\n%s", name, data)
+		}
+		dump := xrayConfig{}
+		if err := json.Unmarshal(data, &dump); err != nil {
+			t.Fatal(err)
+		}
+		if tag := dump.Outbounds[0].(map[string]interface{})["tag"]; tag != "proxy" {
+			t.Errorf("%s: the custom outbound is not the default one: %v", name, tag)
+		}
+		if name == "trojan self" {
+			if len(dump.CertAuthorities) != 1 || len(dump.CertAuthorities[0].(map[string]interface{})["sha256Fingerprint"].(string)) != 95 {
+				t.Errorf("%s: no CA fingerprint:\n%s", name, data)
+			}
+		} else if len(dump.CertAuthorities) > 0 {
+			t.Errorf("%s: unexpected CA:\n%s", name, data)
+		}
+
+		// What xray run -test does
+		file := filepath.Join(dir, "config.json")
+		os.WriteFile(file, data, 0o600)
+		config, err := core.LoadConfig("json", cmdarg.Arg{file})
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", name, err, data)
+		}
+		server, err := core.New(config)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", name, err, data)
+		}
+		server.Close()
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	for _, c := range []struct{ key, value, want string }{
+		{"id", "b831381d-6324-4d53-ad4f-8cda48b30811", "b831381d-0000-0000-0000-000000000000"},
+		{"id", "not-a-uuid", "not-a-uuid"},
+		{"password", "c2VjcmV0a2V5b2YxNmJ5dA==", "AAAAAAAAAAAAAAAAAAAAAA=="},
+		{"privateKey", "YFb_H4dWm8gKGJmEjmDGLzkqQZcLN9AbTkZ-P-lpYGo", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"},
+		{"password", "hunter2", maskedSecret},
+		{"address", "127.0.0.1", "127.0.0.1"},
+	} {
+		if got := maskSecret(c.key, c.value); got != c.want {
+			t.Errorf("maskSecret(%s, %s) = %s, want %s", c.key, c.value, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package panel
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sources of the configs Migrate converts
+const (
+	SourceXrayR = "XrayR"
+	SourceV2bX  = "V2bX"
+)
+
+// Actions of the migration notes
+const (
+	NoteDropped = "dropped"
+	NoteChanged = "changed"
+)
+
+// MigrationNote is an option of the old config dropped or changed in meaning by a migration
+type MigrationNote struct {
+	Path   string // Path of the option in the old config, e.g. Nodes[0].ApiConfig.EnableXTLS
+	Action string // NoteDropped or NoteChanged
+	Reason string
+}
+
+func (n MigrationNote) String() string {
+	return fmt.Sprintf("%s %s: %s", n.Path, n.Action, n.Reason)
+}
+
+// Migration is an XrayR or V2bX config converted to the config of Xray-P
+type Migration struct {
+	Source string
+	Config []byte // The YAML config file
+	Notes  []MigrationNote
+}
+
+// Why the options of XrayR without an equivalent are dropped, the others are unknown
+var droppedOptions = map[string]string{
+	"enablextls": "XTLS was removed from Xray, use VlessFlow: xtls-rprx-vision",
+}
+
+// Options of the V2bX nodes without an equivalent
+var droppedV2bXOptions = map[string]string{
+	"deviceonlinemintraffic": "users are reported online whatever their traffic",
+	"minreporttraffic":       "the traffic of every user is reported",
+	"enableuot":              "UDP over TCP is set by the client",
+	"enabletfo":              "TCP Fast Open can be set with InboundOverride streamSettings.sockopt.tcpFastOpen",
+	"coretype":               "only the Xray core is supported",
+	"corename":               "there is a single Xray core",
+}
+
+// Node types of V2bX supported by the Xray core
+var v2bxNodeTypes = map[string]string{
+	"vmess":       "Vmess",
+	"vless":       "Vless",
+	"trojan":      "Trojan",
+	"shadowsocks": "Shadowsocks",
+}
+
+// Migrate converts an XrayR config file, YAML, or a V2bX config file, JSON, to the
+// config of Xray-P. The options without an equivalent are dropped, and noted along
+// with the ones whose meaning changed. The comments of an XrayR config are kept.
+func Migrate(data []byte) (*Migration, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config failed: %s", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("the config is not a mapping")
+	}
+	m := &Migration{Source: SourceXrayR}
+	root := doc.Content[0]
+	if isV2bX(root) {
+		m.Source = SourceV2bX
+		root = m.convertV2bX(root)
+		doc.Content[0] = root
+	}
+	m.prune(root, reflect.TypeOf(Config{}), "")
+	m.migratePanelTypes(root)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	m.Config = buf.Bytes()
+	return m, nil
+}
+
+func (m *Migration) note(path string, action string, format string, a ...interface{}) {
+	m.Notes = append(m.Notes, MigrationNote{Path: path, Action: action, Reason: fmt.Sprintf(format, a...)})
+}
+
+// isV2bX returns whether the config has the Cores of V2bX, or nodes without ApiConfig
+func isV2bX(root *yaml.Node) bool {
+	if _, cores := mappingValue(root, "Cores"); cores != nil {
+		return true
+	}
+	if _, nodes := mappingValue(root, "Nodes"); nodes != nil && nodes.Kind == yaml.SequenceNode {
+		for _, node := range nodes.Content {
+			if _, apiHost := mappingValue(node, "ApiHost"); apiHost != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prune drops the options of node which are not in the config type t
+func (m *Migration) prune(node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		content := node.Content[:0]
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinPath(path, key.Value)
+			if strings.EqualFold(key.Value, "Include") {
+				content = append(content, key, value)
+				continue
+			}
+			field, ok := fieldByTag(t, key.Value)
+			if !ok {
+				reason, known := droppedOptions[strings.ToLower(key.Value)]
+				if !known {
+					reason = "not an option of Xray-P"
+				}
+				m.note(keyPath, NoteDropped, "%s", reason)
+				continue
+			}
+			m.prune(value, field.Type, keyPath)
+			content = append(content, key, value)
+		}
+		node.Content = content
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			m.prune(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// migratePanelTypes sets the panel type of the nodes to the only one supported
+func (m *Migration) migratePanelTypes(root *yaml.Node) {
+	_, nodes := mappingValue(root, "Nodes")
+	if nodes == nil || nodes.Kind != yaml.SequenceNode {
+		return
+	}
+	for i, node := range nodes.Content {
+		_, panelType := mappingValue(node, "PanelType")
+		if panelType == nil {
+			continue
+		}
+		if panelType.Value != "SSpanel" {
+			m.note(fmt.Sprintf("Nodes[%d].PanelType", i), NoteChanged,
+				"%s is not supported, the node uses the SSpanel API (/mod_mu) of its ApiHost", panelType.Value)
+			panelType.Value = "SSpanel"
+		}
+	}
+}
+
+// convertV2bX returns the Xray-P config of the V2bX config root
+func (m *Migration) convertV2bX(root *yaml.Node) *yaml.Node {
+	sections := &yaml.Node{Kind: yaml.MappingNode}
+	var logConfig *yaml.Node
+	if _, log := mappingValue(root, "Log"); log != nil {
+		logConfig = &yaml.Node{Kind: yaml.MappingNode}
+		if _, level := mappingValue(log, "Level"); level != nil {
+			setValue(logConfig, "Level", level)
+		}
+		if _, output := mappingValue(log, "Output"); output != nil && output.Value != "" {
+			m.note("Log.Output", NoteDropped, "the log of V2bX itself goes to stderr, use ErrorPath for the Xray core")
+		}
+	}
+
+	// The first Xray core gives the core sections
+	_, cores := mappingValue(root, "Cores")
+	xrayCores := 0
+	if cores != nil {
+		for i, core := range cores.Content {
+			path := fmt.Sprintf("Cores[%d]", i)
+			if _, coreType := mappingValue(core, "Type"); coreType == nil || !strings.EqualFold(coreType.Value, "xray") {
+				m.note(path, NoteDropped, "only the Xray core is supported")
+				continue
+			}
+			xrayCores++
+			if xrayCores > 1 {
+				m.note(path, NoteDropped, "there is a single Xray core, the sections of the first one are kept")
+				continue
+			}
+			for i := 0; i+1 < len(core.Content); i += 2 {
+				key, value := core.Content[i], core.Content[i+1]
+				keyPath := joinPath(path, key.Value)
+				switch strings.ToLower(key.Value) {
+				case "type":
+				case "log":
+					if logConfig == nil {
+						logConfig = &yaml.Node{Kind: yaml.MappingNode}
+					}
+					for j := 0; j+1 < len(value.Content); j += 2 {
+						setValue(logConfig, value.Content[j].Value, value.Content[j+1])
+					}
+				case "assetpath":
+					m.note(keyPath, NoteDropped, "the assets are read from the directory of the config file, or XRAY_LOCATION_ASSET")
+				case "dnsconfigpath", "routeconfigpath", "inboundconfigpath", "outboundconfigpath", "connectionconfig":
+					setValue(sections, key.Value, value)
+				default:
+					m.note(keyPath, NoteDropped, "not an option of Xray-P")
+				}
+			}
+		}
+	}
+	out := &yaml.Node{Kind: yaml.MappingNode}
+	if logConfig != nil {
+		setValue(out, "Log", logConfig)
+	}
+	out.Content = append(out.Content, sections.Content...)
+
+	_, nodes := mappingValue(root, "Nodes")
+	outNodes := &yaml.Node{Kind: yaml.SequenceNode}
+	if nodes != nil {
+		for i, node := range nodes.Content {
+			if converted := m.convertV2bXNode(node, fmt.Sprintf("Nodes[%d]", i)); converted != nil {
+				outNodes.Content = append(outNodes.Content, converted)
+			}
+		}
+	}
+	setValue(out, "Nodes", outNodes)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		switch strings.ToLower(root.Content[i].Value) {
+		case "log", "cores", "nodes":
+		default:
+			m.note(root.Content[i].Value, NoteDropped, "not an option of Xray-P")
+		}
+	}
+	clearStyle(out)
+	return out
+}
+
+// convertV2bXNode returns the Xray-P node of the V2bX node, nil when it is not supported
+func (m *Migration) convertV2bXNode(node *yaml.Node, path string) *yaml.Node {
+	if _, core := mappingValue(node, "Core"); core != nil && !strings.EqualFold(core.Value, "xray") {
+		m.note(path, NoteDropped, "the node runs on the %s core, only the Xray core is supported", core.Value)
+		return nil
+	}
+	if _, nodeType := mappingValue(node, "NodeType"); nodeType != nil {
+		if _, ok := v2bxNodeTypes[strings.ToLower(nodeType.Value)]; !ok {
+			m.note(path, NoteDropped, "the %s node type is not supported by the Xray core", nodeType.Value)
+			return nil
+		}
+	}
+	apiConfig := &yaml.Node{Kind: yaml.MappingNode}
+	controllerConfig := &yaml.Node{Kind: yaml.MappingNode}
+
+	// The options of newer V2bX versions are grouped in Options or XrayOptions
+	var fields [][2]*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch strings.ToLower(key.Value) {
+		case "options", "xrayoptions", "limitconfig":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				fields = append(fields, [2]*yaml.Node{value.Content[j], value.Content[j+1]})
+			}
+		default:
+			fields = append(fields, [2]*yaml.Node{key, value})
+		}
+	}
+	for _, field := range fields {
+		key, value := field[0], field[1]
+		keyPath := joinPath(path, key.Value)
+		switch strings.ToLower(key.Value) {
+		case "core", "singoptions":
+		case "apihost", "apikey", "nodeid", "timeout":
+			setValue(apiConfig, key.Value, value)
+		case "nodetype":
+			setValue(apiConfig, "NodeType", &yaml.Node{Kind: yaml.ScalarNode, Value: v2bxNodeTypes[strings.ToLower(value.Value)]})
+		case "speedlimit":
+			setValue(apiConfig, "SpeedLimit", value)
+		case "iplimit", "devicelimit":
+			setValue(apiConfig, "DeviceLimit", value)
+			m.note(keyPath, NoteChanged, "the IP limit of V2bX is the DeviceLimit of Xray-P, set locally it replaces the one of the panel")
+		case "listenip", "sendip", "dnstype", "enabledns", "enableproxyprotocol", "enablefallback", "fallbackconfigs", "certconfig":
+			setValue(controllerConfig, key.Value, value)
+		case "sniffenabled":
+			disabled := "false"
+			if value.Value == "false" {
+				disabled = "true"
+			}
+			setValue(controllerConfig, "DisableSniffing", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: disabled})
+		default:
+			reason, known := droppedV2bXOptions[strings.ToLower(key.Value)]
+			if !known {
+				reason = "not an option of Xray-P"
+			}
+			m.note(keyPath, NoteDropped, "%s", reason)
+		}
+	}
+	m.note(path, NoteChanged, "V2bX nodes use the V2board UniProxy API, the node uses the SSpanel API (/mod_mu) of its ApiHost")
+	converted := &yaml.Node{Kind: yaml.MappingNode}
+	setValue(converted, "PanelType", &yaml.Node{Kind: yaml.ScalarNode, Value: "SSpanel"})
+	setValue(converted, "ApiConfig", apiConfig)
+	setValue(converted, "ControllerConfig", controllerConfig)
+	return converted
+}
+
+// fieldByTag returns the field of the struct type t decoded from the config key
+func fieldByTag(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.EqualFold(t.Field(i).Tag.Get("mapstructure"), key) {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// mappingValue returns the key and the value of key in the mapping node, regardless of case
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// setValue sets key in the mapping node, the case of an existing key is kept
+func setValue(node *yaml.Node, key string, value *yaml.Node) {
+	if k, _ := mappingValue(node, key); k != nil {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i] == k {
+				node.Content[i+1] = value
+				return
+			}
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// clearStyle writes the JSON of a V2bX config in the block style of YAML
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, n := range node.Content {
+		clearStyle(n)
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,114 @@
+package panel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// decodeMigrated decodes the converted config as the config file loader does
+func decodeMigrated(t *testing.T, m *Migration) *Config {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(m.Config)); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{}
+	if err := v.UnmarshalExact(config); err != nil {
+		t.Fatalf("converted config has unknown options: %s\n%s", err, m.Config)
+	}
+	return config
+}
+
+func notePaths(m *Migration) string {
+	var paths []string
+	for _, note := range m.Notes {
+		paths = append(paths, note.Action+" "+note.Path)
+	}
+	return strings.Join(paths, ", ")
+}
+
+func TestMigrateXrayR(t *testing.T) {
+	m, err := Migrate([]byte(`Log:
+  Level: warning # Log level
+ConnectionConfig:
+  Handshake: 4
+Nodes:
+  - PanelType: "NewV2board" # Panel type
+    ApiConfig:
+      ApiHost: "http://127.0.0.1:667"
+      ApiKey: "123"
+      NodeID: 41
+      NodeType: V2ray
+      EnableXTLS: false
+    ControllerConfig:
+      ListenIP: 0.0.0.0
+      LegacyOption: 1
+      CertConfig:
+        CertMode: dns
+        DNSEnv:
+          ALICLOUD_ACCESS_KEY: aaa
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Source != SourceXrayR {
+		t.Errorf("unexpected source %s", m.Source)
+	}
+	want := "dropped Nodes[0].ApiConfig.EnableXTLS, dropped Nodes[0].ControllerConfig.LegacyOption, changed Nodes[0].PanelType"
+	if paths := notePaths(m); paths != want {
+		t.Errorf("unexpected notes: %s", paths)
+	}
+	// The comments are kept
+	if !strings.Contains(string(m.Config), "# Log level") {
+		t.Errorf("comments dropped:\n%s", m.Config)
+	}
+	config := decodeMigrated(t, m)
+	node := config.NodesConfig[0]
+	if node.PanelType != "SSpanel" || node.ApiConfig.Key != "123" || node.ControllerConfig.CertConfig.DNSEnv["alicloud_access_key"] != "aaa" {
+		t.Errorf("unexpected node: %+v", node)
+	}
+}
+
+func TestMigrateV2bX(t *testing.T) {
+	m, err := Migrate([]byte(`{
+  "Log": {"Level": "error"},
+  "Cores": [
+    {"Type": "xray", "Log": {"ErrorPath": "/etc/V2bX/error.log"}, "RouteConfigPath": "/etc/V2bX/route.json", "AssetPath": "/etc/V2bX"},
+    {"Type": "sing"}
+  ],
+  "Nodes": [
+    {"Core": "xray", "ApiHost": "http://127.0.0.1", "ApiKey": "123", "NodeID": 33, "NodeType": "vless", "Timeout": 30,
+     "ListenIP": "0.0.0.0", "EnableTFO": true, "DNSType": "UseIPv4", "CertConfig": {"CertMode": "none"},
+     "LimitConfig": {"SpeedLimit": 100, "IPLimit": 3}},
+    {"Core": "sing", "ApiHost": "http://127.0.0.1", "ApiKey": "123", "NodeID": 34, "NodeType": "hysteria2"}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Source != SourceV2bX {
+		t.Errorf("unexpected source %s", m.Source)
+	}
+	want := "dropped Cores[0].AssetPath, dropped Cores[1], dropped Nodes[0].EnableTFO, changed Nodes[0].IPLimit, changed Nodes[0], dropped Nodes[1]"
+	if paths := notePaths(m); paths != want {
+		t.Errorf("unexpected notes: %s", paths)
+	}
+	config := decodeMigrated(t, m)
+	if config.LogConfig.Level != "error" || config.LogConfig.ErrorPath != "/etc/V2bX/error.log" || config.RouteConfigPath != "/etc/V2bX/route.json" {
+		t.Errorf("unexpected core sections:\n%s", m.Config)
+	}
+	if len(config.NodesConfig) != 1 {
+		t.Fatalf("got %d nodes, want 1", len(config.NodesConfig))
+	}
+	node := config.NodesConfig[0]
+	if node.PanelType != "SSpanel" || node.ApiConfig.NodeType != "Vless" || node.ApiConfig.NodeID != 33 || node.ApiConfig.Key != "123" ||
+		node.ApiConfig.SpeedLimit != 100 || node.ApiConfig.DeviceLimit != 3 {
+		t.Errorf("unexpected api config: %+v", node.ApiConfig)
+	}
+	if node.ControllerConfig.ListenIP != "0.0.0.0" || node.ControllerConfig.DNSType != "UseIPv4" || node.ControllerConfig.CertConfig.CertMode != "none" {
+		t.Errorf("unexpected controller config: %+v", node.ControllerConfig)
+	}
+}
@@ -1,36 +1,61 @@
 package panel
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"dario.cat/mergo"
 	"github.com/r3labs/diff/v2"
 	log "github.com/sirupsen/logrus"
+	"github.com/xtls/xray-core/app/commander"
 	"github.com/xtls/xray-core/app/dispatcher"
 	"github.com/xtls/xray-core/app/proxyman"
 	"github.com/xtls/xray-core/app/stats"
 	"github.com/xtls/xray-core/common/serial"
 	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/routing"
 	"github.com/xtls/xray-core/infra/conf"
 	"google.golang.org/protobuf/proto"
 
-	"Xray-P/api"
 	"Xray-P/api/sspanel"
 	_ "Xray-P/cmd/distro/all"
+	"Xray-P/common/event"
+	"Xray-P/common/flow"
+	xraylog "Xray-P/common/log"
+	"Xray-P/common/metrics"
+	"Xray-P/common/mylego"
 	"Xray-P/service"
 	"Xray-P/service/controller"
 )
 
 // Panel Structure
 type Panel struct {
-	access      sync.Mutex
-	panelConfig *Config
-	Server      *core.Instance
-	Service     []service.Service
-	Running     bool
+	access        sync.Mutex
+	panelConfig   *Config
+	Server        *core.Instance
+	Service       []service.Service
+	Running       bool
+	metricsServer *metrics.Server
+	flowExporter  *flow.Exporter
+	eventManager  *event.Manager
+	certManager   *mylego.Manager
+	nodeConfigs   []*NodesConfig // Config of each node of Service
+	core          *coreConfig    // Core built from panelConfig, to tell if a reload changes it
+	ready         atomic.Bool    // Started and not closing nor shutting down
+}
+
+// coreConfig is the Xray core built from the panel config
+type coreConfig struct {
+	config *core.Config
+	log    *LogConfig // Merged with the defaults, the log handlers of the core are set from it
 }
 
 func New(panelConfig *Config) *Panel {
@@ -38,223 +63,576 @@ func New(panelConfig *Config) *Panel {
 	return p
 }
 
-func (p *Panel) loadCore(panelConfig *Config) *core.Instance {
-	// Log Config
-	coreLogConfig := &conf.LogConfig{}
-	logConfig := getDefaultLogConfig()
-	if panelConfig.LogConfig != nil {
-		if _, err := diff.Merge(logConfig, panelConfig.LogConfig, logConfig); err != nil {
-			log.Panicf("Read Log config failed: %s", err)
+// buildCore builds the Xray core config of panelConfig, the error holds every
+// problem found in the sections
+func buildCore(panelConfig *Config) (*coreConfig, error) {
+	var errs []error
+	logConfig, coreLogConfig, err := buildLogConfig(panelConfig.LogConfig)
+	errs = append(errs, err)
+	dnsConfig, err := buildDNSConfig(panelConfig.DnsConfigPath, panelConfig.DnsConfig)
+	errs = append(errs, err)
+	routeConfig, err := buildRouterConfig(panelConfig.RouteConfigPath, panelConfig.RouteConfig)
+	errs = append(errs, err)
+	observatoryConfig, err := buildObservatoryConfig(panelConfig.ObservatoryConfigPath, panelConfig.ObservatoryConfig)
+	errs = append(errs, err)
+	inBoundConfig, err := buildInboundConfigs(panelConfig.InboundConfigPath, panelConfig.InboundConfig)
+	errs = append(errs, err)
+	outBoundConfig, err := buildOutboundConfigs(panelConfig.OutboundConfigPath, panelConfig.OutboundConfig)
+	errs = append(errs, err)
+	// API config
+	var apiConfig proto.Message
+	if panelConfig.APIConfig != nil && panelConfig.APIConfig.Enable {
+		apiConfig, err = buildAPIConfig(panelConfig.APIConfig)
+		errs = append(errs, err)
+	}
+	// Policy config
+	levelPolicyConfig, err := parseConnectionConfig(panelConfig.ConnectionConfig)
+	errs = append(errs, err)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	corePolicyConfig := &conf.PolicyConfig{}
+	corePolicyConfig.Levels = map[uint32]*conf.Policy{0: levelPolicyConfig}
+	policyConfig, _ := corePolicyConfig.Build()
+	// Build Core Config
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(coreLogConfig.Build()),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&stats.Config{}),
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(policyConfig),
+			serial.ToTypedMessage(dnsConfig),
+			serial.ToTypedMessage(routeConfig),
+		},
+		Inbound:  inBoundConfig,
+		Outbound: outBoundConfig,
+	}
+	// Optional apps, the core does not accept empty ones
+	for _, app := range []proto.Message{observatoryConfig, apiConfig} {
+		if app != nil {
+			config.App = append(config.App, serial.ToTypedMessage(app))
 		}
 	}
-	coreLogConfig.LogLevel = logConfig.Level
-	coreLogConfig.AccessLog = logConfig.AccessPath
-	coreLogConfig.ErrorLog = logConfig.ErrorPath
+	return &coreConfig{config: config, log: logConfig}, nil
+}
 
-	// DNS config
-	coreDnsConfig := &conf.DNSConfig{}
-	if panelConfig.DnsConfigPath != "" {
-		if data, err := os.ReadFile(panelConfig.DnsConfigPath); err != nil {
-			log.Panicf("Failed to read DNS config file at: %s", panelConfig.DnsConfigPath)
-		} else {
-			if err = json.Unmarshal(data, coreDnsConfig); err != nil {
-				log.Panicf("Failed to unmarshal DNS config: %s", panelConfig.DnsConfigPath)
-			}
+// sectionData returns the JSON config of the section name, read from the file at path
+// or given inline in the config file as a mapping, a list or a JSON string. It returns
+// nil when the section is not set.
+func sectionData(name string, path string, inline interface{}) ([]byte, error) {
+	if s, ok := inline.(string); ok && strings.TrimSpace(s) == "" {
+		inline = nil
+	}
+	switch {
+	case path != "" && inline != nil:
+		return nil, fmt.Errorf("the %s config is set both inline and by a file path, keep one of them", name)
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s config file at %s: %s", name, path, err)
+		}
+		return data, nil
+	case inline == nil:
+		return nil, nil
+	}
+	if s, ok := inline.(string); ok {
+		return []byte(s), nil
+	}
+	data, err := json.Marshal(inline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inline %s config: %s", name, err)
+	}
+	return data, nil
+}
+
+// readJSONConfig unmarshals the config of the section name into v, v is left as it
+// is when the section is not set
+func readJSONConfig(name string, path string, inline interface{}, v interface{}) error {
+	data, err := sectionData(name, path, inline)
+	if err != nil || data == nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		if path == "" {
+			path = "inline"
 		}
+		return fmt.Errorf("failed to unmarshal %s config %s: %s", name, path, err)
 	}
+	return nil
+}
 
-	// init controller's DNS config
-	// for _, config := range p.panelConfig.NodesConfig {
-	// 	config.ControllerConfig.DNSConfig = coreDnsConfig
-	// }
+// buildLogConfig merges the log config with the defaults
+func buildLogConfig(c *LogConfig) (*LogConfig, *conf.LogConfig, error) {
+	logConfig := getDefaultLogConfig()
+	if c != nil {
+		if _, err := diff.Merge(logConfig, c, logConfig); err != nil {
+			return nil, nil, fmt.Errorf("read Log config failed: %s", err)
+		}
+	}
+	if logConfig.Rotate != nil {
+		if err := logConfig.Rotate.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("read Log config failed: %s", err)
+		}
+	}
+	switch logConfig.AccessMode {
+	case "":
+		// The merge copies the empty AccessMode of a Log section without it
+		logConfig.AccessMode = getDefaultLogConfig().AccessMode
+	case xraylog.AccessModeRequest, xraylog.AccessModeConnection:
+	default:
+		return nil, nil, fmt.Errorf("read Log config failed: unknown AccessMode %s", logConfig.AccessMode)
+	}
+	return logConfig, &conf.LogConfig{
+		LogLevel:  logConfig.Level,
+		AccessLog: logConfig.AccessPath,
+		ErrorLog:  logConfig.ErrorPath,
+	}, nil
+}
 
+func buildDNSConfig(path string, inline interface{}) (proto.Message, error) {
+	coreDnsConfig := &conf.DNSConfig{}
+	if err := readJSONConfig("DNS", path, inline, coreDnsConfig); err != nil {
+		return nil, err
+	}
 	dnsConfig, err := coreDnsConfig.Build()
 	if err != nil {
-		log.Panicf("Failed to understand DNS config, Please check: https://xtls.github.io/config/dns.html for help: %s", err)
+		return nil, fmt.Errorf("failed to understand DNS config, please check https://xtls.github.io/config/dns.html for help: %s", err)
 	}
+	return dnsConfig, nil
+}
 
-	// Routing config
+func buildRouterConfig(path string, inline interface{}) (proto.Message, error) {
 	coreRouterConfig := &conf.RouterConfig{}
-	if panelConfig.RouteConfigPath != "" {
-		if data, err := os.ReadFile(panelConfig.RouteConfigPath); err != nil {
-			log.Panicf("Failed to read Routing config file at: %s", panelConfig.RouteConfigPath)
-		} else {
-			if err = json.Unmarshal(data, coreRouterConfig); err != nil {
-				log.Panicf("Failed to unmarshal Routing config: %s", panelConfig.RouteConfigPath)
-			}
-		}
+	if err := readJSONConfig("Routing", path, inline, coreRouterConfig); err != nil {
+		return nil, err
 	}
 	routeConfig, err := coreRouterConfig.Build()
 	if err != nil {
-		log.Panicf("Failed to understand Routing config  Please check: https://xtls.github.io/config/routing.html for help: %s", err)
+		return nil, fmt.Errorf("failed to understand Routing config, please check https://xtls.github.io/config/routing.html for help: %s", err)
+	}
+	return routeConfig, nil
+}
+
+// buildObservatoryConfig returns nil when there is no observatory
+func buildObservatoryConfig(path string, inline interface{}) (proto.Message, error) {
+	data, err := sectionData("Observatory", path, inline)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	if path == "" {
+		path = "inline"
 	}
 
-	// Observatory config
 	var observatoryConfig proto.Message
-	if panelConfig.ObservatoryConfigPath != "" {
-		data, err := os.ReadFile(panelConfig.ObservatoryConfigPath)
-		if err != nil {
-			log.Panicf("Failed to read Observatory config file at: %s", panelConfig.ObservatoryConfigPath)
+	// Auto-detect Burst config by checking for "pingConfig" field
+	if isBurstObservatory(data) {
+		coreBurstObservatoryConfig := &conf.BurstObservatoryConfig{}
+		if err = json.Unmarshal(data, coreBurstObservatoryConfig); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal Burst Observatory config %s: %s", path, err)
 		}
-
-		// Auto-detect Burst config by checking for "pingConfig" field
-		if strings.Contains(string(data), "\"pingConfig\"") {
-			coreBurstObservatoryConfig := &conf.BurstObservatoryConfig{}
-			if err = json.Unmarshal(data, coreBurstObservatoryConfig); err != nil {
-				log.Panicf("Failed to unmarshal Burst Observatory config: %s", panelConfig.ObservatoryConfigPath)
-			}
-			observatoryConfig, err = coreBurstObservatoryConfig.Build()
-		} else {
-			coreObservatoryConfig := &conf.ObservatoryConfig{}
-			if err = json.Unmarshal(data, coreObservatoryConfig); err != nil {
-				log.Panicf("Failed to unmarshal Observatory config: %s", panelConfig.ObservatoryConfigPath)
-			}
-			observatoryConfig, err = coreObservatoryConfig.Build()
+		observatoryConfig, err = coreBurstObservatoryConfig.Build()
+	} else {
+		coreObservatoryConfig := &conf.ObservatoryConfig{}
+		if err = json.Unmarshal(data, coreObservatoryConfig); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal Observatory config %s: %s", path, err)
 		}
+		observatoryConfig, err = coreObservatoryConfig.Build()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to understand Observatory config: %s", err)
+	}
+	return observatoryConfig, nil
+}
 
-		if err != nil {
-			log.Panicf("Failed to understand Observatory config: %s", err)
+// isBurstObservatory returns whether the observatory config has a pingConfig, the keys
+// of inline configs are lowercased by the config file loader
+func isBurstObservatory(data []byte) bool {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return false
+	}
+	for key := range fields {
+		if strings.EqualFold(key, "pingConfig") {
+			return true
 		}
 	}
+	return false
+}
 
-	// Custom Inbound config
+func buildInboundConfigs(path string, inline interface{}) ([]*core.InboundHandlerConfig, error) {
 	var coreCustomInboundConfig []conf.InboundDetourConfig
-	if panelConfig.InboundConfigPath != "" {
-		if data, err := os.ReadFile(panelConfig.InboundConfigPath); err != nil {
-			log.Panicf("Failed to read Custom Inbound config file at: %s", panelConfig.OutboundConfigPath)
-		} else {
-			if err = json.Unmarshal(data, &coreCustomInboundConfig); err != nil {
-				log.Panicf("Failed to unmarshal Custom Inbound config: %s", panelConfig.OutboundConfigPath)
-			}
-		}
+	if err := readJSONConfig("Custom Inbound", path, inline, &coreCustomInboundConfig); err != nil {
+		return nil, err
 	}
 	var inBoundConfig []*core.InboundHandlerConfig
-	for _, config := range coreCustomInboundConfig {
+	var errs []error
+	for i, config := range coreCustomInboundConfig {
 		oc, err := config.Build()
 		if err != nil {
-			log.Panicf("Failed to understand Inbound config, Please check: https://xtls.github.io/config/inbound.html for help: %s", err)
+			errs = append(errs, fmt.Errorf("failed to understand Inbound config %d (%s), please check https://xtls.github.io/config/inbound.html for help: %s", i, config.Tag, err))
+			continue
 		}
 		inBoundConfig = append(inBoundConfig, oc)
 	}
-	// Custom Outbound config
+	return inBoundConfig, errors.Join(errs...)
+}
+
+func buildOutboundConfigs(path string, inline interface{}) ([]*core.OutboundHandlerConfig, error) {
 	var coreCustomOutboundConfig []conf.OutboundDetourConfig
-	if panelConfig.OutboundConfigPath != "" {
-		if data, err := os.ReadFile(panelConfig.OutboundConfigPath); err != nil {
-			log.Panicf("Failed to read Custom Outbound config file at: %s", panelConfig.OutboundConfigPath)
-		} else {
-			if err = json.Unmarshal(data, &coreCustomOutboundConfig); err != nil {
-				log.Panicf("Failed to unmarshal Custom Outbound config: %s", panelConfig.OutboundConfigPath)
-			}
-		}
+	if err := readJSONConfig("Custom Outbound", path, inline, &coreCustomOutboundConfig); err != nil {
+		return nil, err
 	}
 	var outBoundConfig []*core.OutboundHandlerConfig
-	for _, config := range coreCustomOutboundConfig {
+	var errs []error
+	for i, config := range coreCustomOutboundConfig {
 		oc, err := config.Build()
 		if err != nil {
-			log.Panicf("Failed to understand Outbound config, Please check: https://xtls.github.io/config/outbound.html for help: %s", err)
+			errs = append(errs, fmt.Errorf("failed to understand Outbound config %d (%s), please check https://xtls.github.io/config/outbound.html for help: %s", i, config.Tag, err))
+			continue
 		}
 		outBoundConfig = append(outBoundConfig, oc)
 	}
-	// Policy config
-	levelPolicyConfig := parseConnectionConfig(panelConfig.ConnectionConfig)
-	corePolicyConfig := &conf.PolicyConfig{}
-	corePolicyConfig.Levels = map[uint32]*conf.Policy{0: levelPolicyConfig}
-	policyConfig, _ := corePolicyConfig.Build()
-	// Build Core Config
-	config := &core.Config{
-		App: []*serial.TypedMessage{
-			serial.ToTypedMessage(coreLogConfig.Build()),
-			serial.ToTypedMessage(&dispatcher.Config{}),
-			serial.ToTypedMessage(&stats.Config{}),
-			serial.ToTypedMessage(&proxyman.InboundConfig{}),
-			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
-			serial.ToTypedMessage(policyConfig),
-			serial.ToTypedMessage(dnsConfig),
-			serial.ToTypedMessage(routeConfig),
-			serial.ToTypedMessage(observatoryConfig),
-		},
-		Inbound:  inBoundConfig,
-		Outbound: outBoundConfig,
+	return outBoundConfig, errors.Join(errs...)
+}
+
+// loadCore builds the core of panelConfig, and sets the log handlers of the core
+func (p *Panel) loadCore(panelConfig *Config) *core.Instance {
+	c, err := buildCore(panelConfig)
+	if err != nil {
+		log.Panic(err)
 	}
-	server, err := core.New(config)
+	server, err := p.newCore(c)
 	if err != nil {
-		log.Panicf("failed to create instance: %s", err)
+		log.Panic(err)
 	}
-
+	p.core = c
 	return server
 }
 
+func (p *Panel) newCore(c *coreConfig) (*core.Instance, error) {
+	connectionLog := c.log.AccessMode == xraylog.AccessModeConnection
+	xraylog.SetCoreLog(&xraylog.CoreConfig{
+		Format:        c.log.Format,
+		Rotate:        c.log.Rotate,
+		ConnectionLog: connectionLog,
+	})
+	server, err := core.New(c.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instance: %s", err)
+	}
+	server.GetFeature(routing.DispatcherType()).(*dispatcher.DefaultDispatcher).ConnTrack.SetAccessLog(connectionLog)
+	return server, nil
+}
+
+// buildControllerConfigs merges the controller config of each node with the defaults
+func buildControllerConfigs(panelConfig *Config) ([]*controller.Config, error) {
+	configs := make([]*controller.Config, len(panelConfig.NodesConfig))
+	for i, nodeConfig := range panelConfig.NodesConfig {
+		if nodeConfig.ApiConfig == nil {
+			return nil, fmt.Errorf("node %d has no ApiConfig", i)
+		}
+		controllerConfig := getDefaultControllerConfig()
+		if nodeConfig.ControllerConfig != nil {
+			if err := mergo.Merge(controllerConfig, nodeConfig.ControllerConfig, mergo.WithOverride); err != nil {
+				return nil, fmt.Errorf("read Controller config of node %d failed: %s", nodeConfig.ApiConfig.NodeID, err)
+			}
+		}
+		// Pass Observatory Config Path to Controller
+		controllerConfig.ObservatoryConfigPath = panelConfig.ObservatoryConfigPath
+		configs[i] = controllerConfig
+	}
+	return configs, nil
+}
+
 // Start the panel
 func (p *Panel) Start() {
 	p.access.Lock()
 	defer p.access.Unlock()
+	c, err := buildCore(p.panelConfig)
+	if err != nil {
+		log.Panic(err)
+	}
+	controllerConfigs, err := buildControllerConfigs(p.panelConfig)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := p.start(c, controllerConfigs); err != nil {
+		log.Panicf("Panel Start failed: %s", err)
+	}
+}
+
+// start starts the core and the nodes of panelConfig, everything started is closed again
+// when a part fails
+func (p *Panel) start(c *coreConfig, controllerConfigs []*controller.Config) error {
 	log.Print("Start the panel..")
 	// Load Core
-	server := p.loadCore(p.panelConfig)
+	server, err := p.newCore(c)
+	if err != nil {
+		return err
+	}
 	if err := server.Start(); err != nil {
-		log.Panicf("Failed to start instance: %s", err)
+		server.Close()
+		return fmt.Errorf("failed to start instance: %s", err)
 	}
 	p.Server = server
+	p.core = c
 
-	// Load Nodes config
-	for _, nodeConfig := range p.panelConfig.NodesConfig {
-		var apiClient api.API
-		// Forcing SSPanel support only
-		if nodeConfig.PanelType != "SSpanel" {
-			log.Warnf("Force using SSPanel logic for configured type: %s", nodeConfig.PanelType)
-		}
-		apiClient = sspanel.New(nodeConfig.ApiConfig)
+	p.startFlow(p.panelConfig.FlowConfig)
+	p.startEvents(p.panelConfig.EventsConfig)
+	p.startMetrics(p.panelConfig.MetricsConfig)
+	p.certManager = mylego.StartManager()
 
-		var controllerService service.Service
-		// Register controller service
-		controllerConfig := getDefaultControllerConfig()
-		if nodeConfig.ControllerConfig != nil {
-			if err := mergo.Merge(controllerConfig, nodeConfig.ControllerConfig, mergo.WithOverride); err != nil {
-				log.Panicf("Read Controller Config Failed")
+	// Load and start the nodes
+	services, err := startNodes(server, p.panelConfig.NodesConfig, controllerConfigs)
+	if err != nil {
+		p.close()
+		return err
+	}
+	p.Service = services
+	p.nodeConfigs = append([]*NodesConfig(nil), p.panelConfig.NodesConfig...)
+	p.Running = true
+	p.ready.Store(true)
+	return nil
+}
+
+// startNodes starts the nodes on server, the started ones are closed when one fails
+func startNodes(server *core.Instance, nodesConfig []*NodesConfig, controllerConfigs []*controller.Config) ([]service.Service, error) {
+	services := make([]service.Service, 0, len(nodesConfig))
+	for i, nodeConfig := range nodesConfig {
+		s := newNode(server, nodeConfig, controllerConfigs[i])
+		if err := s.Start(); err != nil {
+			removeNode(s)
+			for _, s := range services {
+				if err := s.Close(); err != nil {
+					log.Errorf("Node close failed: %s", err)
+				}
 			}
+			return nil, fmt.Errorf("start node %d failed: %s", nodeConfig.ApiConfig.NodeID, err)
 		}
-		// Pass Observatory Config Path to Controller
-		controllerConfig.ObservatoryConfigPath = p.panelConfig.ObservatoryConfigPath
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+// newNode returns the controller of a node running on server
+func newNode(server *core.Instance, nodeConfig *NodesConfig, controllerConfig *controller.Config) service.Service {
+	// Forcing SSPanel support only
+	if nodeConfig.PanelType != "SSpanel" {
+		log.Warnf("Force using SSPanel logic for configured type: %s", nodeConfig.PanelType)
+	}
+	apiClient := metrics.WrapAPI(sspanel.New(nodeConfig.ApiConfig))
+	return controller.New(server, apiClient, controllerConfig)
+}
 
-		controllerService = controller.New(server, apiClient, controllerConfig)
-		p.Service = append(p.Service, controllerService)
+// Flow records
+func (p *Panel) startFlow(c *flow.Config) {
+	if c == nil || !c.Enable {
+		return
+	}
+	if exporter, err := flow.New(c); err != nil {
+		log.Errorf("Failed to start flow exporter: %s", err)
+	} else {
+		p.flowExporter = exporter
+		p.Server.GetFeature(routing.DispatcherType()).(*dispatcher.DefaultDispatcher).ConnTrack.SetExporter(exporter)
+	}
+}
 
+func (p *Panel) closeFlow() {
+	if p.flowExporter == nil {
+		return
+	}
+	p.Server.GetFeature(routing.DispatcherType()).(*dispatcher.DefaultDispatcher).ConnTrack.SetExporter(nil)
+	if err := p.flowExporter.Close(); err != nil {
+		log.Errorf("Flow exporter close failed: %s", err)
 	}
+	p.flowExporter = nil
+}
 
-	// Start all the service
-	for _, s := range p.Service {
-		err := s.Start()
-		if err != nil {
-			log.Panicf("Panel Start failed: %s", err)
-		}
+// Event webhooks
+func (p *Panel) startEvents(c *event.Config) {
+	if c == nil || !c.Enable {
+		return
+	}
+	if manager, err := event.Start(c); err != nil {
+		log.Errorf("Failed to start event webhooks: %s", err)
+	} else {
+		p.eventManager = manager
+		p.Server.GetFeature(routing.DispatcherType()).(*dispatcher.DefaultDispatcher).Limiter.SetRejectHook(event.DeviceLimitRejected)
 	}
-	p.Running = true
+}
+
+func (p *Panel) closeEvents() {
+	if p.eventManager == nil {
+		return
+	}
+	if err := p.eventManager.Close(); err != nil {
+		log.Errorf("Event webhooks close failed: %s", err)
+	}
+	p.eventManager = nil
+}
+
+// attach makes the dispatcher of server export the flows and publish the rejections of
+// the running exporter and webhooks
+func (p *Panel) attach(server *core.Instance) {
+	d := server.GetFeature(routing.DispatcherType()).(*dispatcher.DefaultDispatcher)
+	if p.flowExporter != nil {
+		d.ConnTrack.SetExporter(p.flowExporter)
+	}
+	if p.eventManager != nil {
+		d.Limiter.SetRejectHook(event.DeviceLimitRejected)
+	}
+}
+
+// Metrics endpoint
+func (p *Panel) startMetrics(c *metrics.Config) {
+	if c == nil || !c.Enable {
+		return
+	}
+	if metricsServer, err := metrics.Start(c); err != nil {
+		log.Errorf("Failed to start metrics server: %s", err)
+	} else {
+		p.metricsServer = metricsServer
+	}
+}
+
+func (p *Panel) closeMetrics() {
+	if p.metricsServer == nil {
+		return
+	}
+	if err := p.metricsServer.Close(); err != nil {
+		log.Errorf("Metrics server close failed: %s", err)
+	}
+	p.metricsServer = nil
 }
 
 // Close the panel
 func (p *Panel) Close() {
+	p.ready.Store(false)
 	p.access.Lock()
 	defer p.access.Unlock()
+	p.close()
+}
+
+func (p *Panel) close() {
 	for _, s := range p.Service {
-		err := s.Close()
-		if err != nil {
-			log.Panicf("Panel Close failed: %s", err)
+		if err := s.Close(); err != nil {
+			log.Errorf("Panel Close failed: %s", err)
 		}
 	}
 	p.Service = nil
+	p.nodeConfigs = nil
+	// After the nodes, they release their certificates
+	p.certManager.Close()
+	p.certManager = nil
+	p.closeMetrics()
 	p.Server.Close()
+	// After the core, so the records of the connections it closed are written
+	p.closeFlow()
+	p.closeEvents()
 	p.Running = false
+}
+
+// Shutdown stops the nodes from accepting connections, waits up to drainTimeout
+// for the open ones to be closed, and reports the last traffic to the panels
+func (p *Panel) Shutdown(drainTimeout time.Duration) {
+	p.ready.Store(false)
+	controllers := p.Controllers()
+	if drainTimeout > 0 {
+		log.Printf("Draining the connections for up to %s", drainTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		var wg sync.WaitGroup
+		for _, c := range controllers {
+			wg.Add(1)
+			go func(c *controller.Controller) {
+				defer wg.Done()
+				if err := c.Drain(ctx); err != nil {
+					log.Errorf("Drain node %s failed: %s", c.CurrentTag(), err)
+				}
+			}(c)
+		}
+		wg.Wait()
+	}
+	for _, c := range controllers {
+		if err := c.FlushTraffic(); err != nil {
+			log.Errorf("Report the last traffic of node %s failed: %s", c.CurrentTag(), err)
+		}
+	}
+}
+
+// Ready returns whether the panel is started and not closing nor shutting down
+func (p *Panel) Ready() bool {
+	return p.ready.Load()
+}
+
+// Stalled returns the periodic tasks of the nodes that stopped running, as tag: task
+func (p *Panel) Stalled() []string {
+	var stalled []string
+	for _, c := range p.Controllers() {
+		for _, task := range c.Stalled() {
+			stalled = append(stalled, c.CurrentTag()+": "+task)
+		}
+	}
+	return stalled
+}
+
+// Controllers returns the controllers of the running nodes
+func (p *Panel) Controllers() []*controller.Controller {
+	p.access.Lock()
+	defer p.access.Unlock()
+	var controllers []*controller.Controller
+	for _, s := range p.Service {
+		if c, ok := s.(*controller.Controller); ok {
+			controllers = append(controllers, c)
+		}
+	}
+	return controllers
+}
 
+// buildAPIConfig builds the commander listening on its own address, so no api inbound
+// nor routing rule is needed
+func buildAPIConfig(c *APIConfig) (*commander.Config, error) {
+	apiConfig := getDefaultAPIConfig()
+	if c.Listen != "" {
+		apiConfig.Listen = c.Listen
+	}
+	if len(c.Services) > 0 {
+		apiConfig.Services = c.Services
+	}
+	for _, s := range apiConfig.Services {
+		switch strings.ToLower(s) {
+		case "handlerservice", "statsservice", "loggerservice", "routingservice", "observatoryservice", "reflectionservice":
+		default:
+			log.Warnf("Unknown API service: %s", s)
+		}
+	}
+	if host, _, err := net.SplitHostPort(apiConfig.Listen); err == nil {
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			log.Warnf("The API has no authentication but listens on %s", apiConfig.Listen)
+		}
+	}
+	config, err := (&conf.APIConfig{
+		Tag:      "api",
+		Listen:   apiConfig.Listen,
+		Services: apiConfig.Services,
+	}).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to understand API config: %s", err)
+	}
+	return config, nil
 }
 
-func parseConnectionConfig(c *ConnectionConfig) (policy *conf.Policy) {
+func parseConnectionConfig(c *ConnectionConfig) (*conf.Policy, error) {
 	connectionConfig := getDefaultConnectionConfig()
 	if c != nil {
 		if _, err := diff.Merge(connectionConfig, c, connectionConfig); err != nil {
-			log.Panicf("Read ConnectionConfig failed: %s", err)
+			return nil, fmt.Errorf("read ConnectionConfig failed: %s", err)
 		}
 	}
-	policy = &conf.Policy{
+	policy := &conf.Policy{
 		StatsUserUplink:   true,
 		StatsUserDownlink: true,
 		StatsUserOnline:   true,
@@ -264,6 +642,5 @@ func parseConnectionConfig(c *ConnectionConfig) (policy *conf.Policy) {
 		DownlinkOnly:      &connectionConfig.DownlinkOnly,
 		BufferSize:        &connectionConfig.BufferSize,
 	}
-
-	return
+	return policy, nil
 }
@@ -0,0 +1,153 @@
+package panel
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	handlerservice "github.com/xtls/xray-core/app/proxyman/command"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestAPIHandlerService(t *testing.T) {
+	listen := freeAddress(t)
+	server := New(nil).loadCore(&Config{
+		APIConfig: &APIConfig{Enable: true, Listen: listen},
+	})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// Inbounds added at runtime, like the ones of the controllers
+	inbound := &conf.InboundDetourConfig{}
+	if err := json.Unmarshal([]byte(`{
+		"tag": "Vless_127.0.0.1_0",
+		"listen": "127.0.0.1",
+		"port": 0,
+		"protocol": "vless",
+		"settings": {"decryption": "none", "clients": [{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "email": "Vless_127.0.0.1_0|a@test.com|1"}]}
+	}`), inbound); err != nil {
+		t.Fatal(err)
+	}
+	inboundConfig, err := inbound.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.AddInboundHandler(server, inboundConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := grpc.NewClient(listen, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := handlerservice.NewHandlerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	inbounds, err := client.ListInbounds(ctx, &handlerservice.ListInboundsRequest{IsOnlyTags: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbounds.Inbounds) != 1 || inbounds.Inbounds[0].Tag != "Vless_127.0.0.1_0" {
+		t.Errorf("unexpected inbounds: %v", inbounds.Inbounds)
+	}
+	users, err := client.GetInboundUsers(ctx, &handlerservice.GetInboundUserRequest{Tag: "Vless_127.0.0.1_0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users.Users) != 1 || users.Users[0].Email != "Vless_127.0.0.1_0|a@test.com|1" {
+		t.Errorf("unexpected users: %v", users.Users)
+	}
+}
+
+func TestInlineSections(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"route.json":       `{"domainStrategy": "IPIfNonMatch", "rules": [{"type": "field", "outboundTag": "block", "ip": ["10.0.0.0/8"]}]}`,
+		"outbound.json":    `[{"tag": "direct", "protocol": "freedom", "settings": {"domainStrategy": "UseIPv4"}}, {"tag": "block", "protocol": "blackhole"}]`,
+		"observatory.json": `{"subjectSelector": ["direct"], "pingConfig": {"destination": "https://www.google.com/generate_204", "interval": "1m"}}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fromFiles, err := buildCore(&Config{
+		RouteConfigPath:       filepath.Join(dir, "route.json"),
+		OutboundConfigPath:    filepath.Join(dir, "outbound.json"),
+		ObservatoryConfigPath: filepath.Join(dir, "observatory.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keys of YAML mappings come lowercased from the config file
+	inline, err := buildCore(&Config{
+		RouteConfig: map[string]interface{}{
+			"domainstrategy": "IPIfNonMatch",
+			"rules": []interface{}{
+				map[string]interface{}{"type": "field", "outboundtag": "block", "ip": []interface{}{"10.0.0.0/8"}},
+			},
+		},
+		OutboundConfig: files["outbound.json"],
+		ObservatoryConfig: map[string]interface{}{
+			"subjectselector": []interface{}{"direct"},
+			"pingconfig":      map[string]interface{}{"destination": "https://www.google.com/generate_204", "interval": "1m"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fromFiles.equal(inline) {
+		t.Error("inline sections differ from the same sections in files")
+	}
+
+	// The domains of the hosts keep their dots, the settings of the config file split them
+	dnsFile := filepath.Join(dir, "dns.json")
+	if err := os.WriteFile(dnsFile, []byte(`{"hosts": {"dns.google": "8.8.8.8"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := buildDNSConfig(dnsFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{}
+	if err := config.ReadInlineSections([]byte("DnsConfig:\n  hosts:\n    dns.google: 8.8.8.8\n"), dir); err != nil {
+		t.Fatal(err)
+	}
+	fromInline, err := buildDNSConfig("", config.DnsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(fromFile, fromInline) {
+		t.Errorf("inline hosts %v differ from the file ones %v", fromInline, fromFile)
+	}
+
+	_, err = buildCore(&Config{
+		RouteConfigPath: filepath.Join(dir, "route.json"),
+		RouteConfig:     map[string]interface{}{"rules": []interface{}{}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "both inline and by a file path") {
+		t.Errorf("expected a conflict error, got %v", err)
+	}
+}
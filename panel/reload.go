@@ -0,0 +1,205 @@
+package panel
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xtls/xray-core/app/commander"
+	"github.com/xtls/xray-core/core"
+	"google.golang.org/protobuf/proto"
+
+	xraylog "Xray-P/common/log"
+	"Xray-P/service"
+	"Xray-P/service/controller"
+)
+
+// equal returns whether the cores of c and other are the same. A map serialized in
+// another order makes them differ, at worst the core is restarted for nothing.
+func (c *coreConfig) equal(other *coreConfig) bool {
+	return proto.Equal(c.config, other.config) && reflect.DeepEqual(c.log, other.log)
+}
+
+// Reload applies newConfig to the running panel. Only the nodes whose config changed
+// are restarted, nodes are added or removed as needed, and a new core with every node is
+// started only when the core sections (log, DNS, route, custom inbounds and outbounds,
+// observatory, API, connection policy) changed. The running config stays in place when
+// newConfig is invalid or the new core fails to start.
+func (p *Panel) Reload(newConfig *Config) error {
+	newCore, err := buildCore(newConfig)
+	if err != nil {
+		return err
+	}
+	controllerConfigs, err := buildControllerConfigs(newConfig)
+	if err != nil {
+		return err
+	}
+	if err := validateNodes(newConfig, controllerConfigs); err != nil {
+		return err
+	}
+
+	p.access.Lock()
+	defer p.access.Unlock()
+	oldConfig := p.panelConfig
+	if !p.Running {
+		p.panelConfig = newConfig
+		if err := p.start(newCore, controllerConfigs); err != nil {
+			p.panelConfig = oldConfig
+			return err
+		}
+		return nil
+	}
+	coreChanged := !p.core.equal(newCore)
+	if coreChanged {
+		log.Print("Core config changed, starting a new core with every node")
+		if err := p.replaceCore(newCore, newConfig, controllerConfigs); err != nil {
+			return err
+		}
+	}
+
+	if !reflect.DeepEqual(oldConfig.FlowConfig, newConfig.FlowConfig) {
+		log.Print("Flow config changed, restarting the flow exporter")
+		p.closeFlow()
+		p.startFlow(newConfig.FlowConfig)
+	}
+	if !reflect.DeepEqual(oldConfig.EventsConfig, newConfig.EventsConfig) {
+		log.Print("Events config changed, restarting the event webhooks")
+		p.closeEvents()
+		p.startEvents(newConfig.EventsConfig)
+	}
+	if !reflect.DeepEqual(oldConfig.MetricsConfig, newConfig.MetricsConfig) {
+		log.Print("Metrics config changed, restarting the metrics server")
+		p.closeMetrics()
+		p.startMetrics(newConfig.MetricsConfig)
+	}
+	if coreChanged {
+		p.panelConfig = newConfig
+		return nil
+	}
+	err = p.reloadNodes(newConfig, controllerConfigs)
+	p.panelConfig = newConfig
+	return err
+}
+
+// validateNodes checks the controller config of every node, so an invalid node does
+// not stop the running one
+func validateNodes(config *Config, controllerConfigs []*controller.Config) error {
+	var errs []error
+	for i, controllerConfig := range controllerConfigs {
+		if err := controllerConfig.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("node %d (NodeID %d): %w", i, config.NodesConfig[i].ApiConfig.NodeID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// replaceCore starts c with the nodes of newConfig next to the running core, and closes
+// the running core and nodes once every new node started. They keep running when the
+// new core or a new node fails to start.
+func (p *Panel) replaceCore(c *coreConfig, newConfig *Config, controllerConfigs []*controller.Config) error {
+	// The API may listen on a fixed address, it is released for the new core
+	api, _ := p.Server.GetFeature((*commander.Commander)(nil)).(*commander.Commander)
+	if api != nil {
+		api.Close()
+	}
+	services, server, err := p.startCore(c, newConfig, controllerConfigs)
+	if err != nil {
+		if api != nil {
+			if err := api.Start(); err != nil {
+				log.Errorf("Restart API failed: %s", err)
+			}
+		}
+		return err
+	}
+
+	// The inbounds of both cores share the ports until the running core is closed
+	for _, s := range p.Service {
+		if err := s.Close(); err != nil {
+			log.Errorf("Node close failed: %s", err)
+		}
+	}
+	if err := p.Server.Close(); err != nil {
+		log.Errorf("Core close failed: %s", err)
+	}
+	p.Server, p.core = server, c
+	p.Service = services
+	p.nodeConfigs = append([]*NodesConfig(nil), newConfig.NodesConfig...)
+	return nil
+}
+
+// startCore starts a core with the nodes of newConfig, it is closed again when a node
+// fails to start
+func (p *Panel) startCore(c *coreConfig, newConfig *Config, controllerConfigs []*controller.Config) ([]service.Service, *core.Instance, error) {
+	server, err := p.newCore(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := server.Start(); err != nil {
+		server.Close()
+		return nil, nil, fmt.Errorf("failed to start instance: %s", err)
+	}
+	p.attach(server)
+	services, err := startNodes(server, newConfig.NodesConfig, controllerConfigs)
+	if err != nil {
+		server.Close()
+		return nil, nil, err
+	}
+	return services, server, nil
+}
+
+// reloadNodes keeps the nodes whose config did not change, stops the removed or changed
+// ones and starts the added or changed ones. A node failing to start is left out until
+// the next reload, and reported in the returned error.
+func (p *Panel) reloadNodes(newConfig *Config, controllerConfigs []*controller.Config) error {
+	// The controllers are given the observatory config path
+	observatoryChanged := p.panelConfig.ObservatoryConfigPath != newConfig.ObservatoryConfigPath
+	kept := make([]service.Service, len(newConfig.NodesConfig))
+	for i, oldNode := range p.nodeConfigs {
+		found := false
+		for j, newNode := range newConfig.NodesConfig {
+			if kept[j] == nil && !observatoryChanged && reflect.DeepEqual(oldNode, newNode) {
+				kept[j] = p.Service[i]
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		log.WithField(xraylog.FieldNodeID, oldNode.ApiConfig.NodeID).Print("Node config changed or removed, stopping the node")
+		if err := removeNode(p.Service[i]); err != nil {
+			log.Errorf("Stop node %d failed: %s", oldNode.ApiConfig.NodeID, err)
+		}
+	}
+
+	var errs []error
+	services := make([]service.Service, 0, len(kept))
+	nodeConfigs := make([]*NodesConfig, 0, len(kept))
+	for j, nodeConfig := range newConfig.NodesConfig {
+		s := kept[j]
+		if s == nil {
+			log.WithField(xraylog.FieldNodeID, nodeConfig.ApiConfig.NodeID).Print("Node config changed or added, starting the node")
+			s = newNode(p.Server, nodeConfig, controllerConfigs[j])
+			if err := s.Start(); err != nil {
+				// Left out, it is started again by the next reload
+				log.Errorf("Start node %d failed: %s", nodeConfig.ApiConfig.NodeID, err)
+				errs = append(errs, fmt.Errorf("start node %d failed: %s", nodeConfig.ApiConfig.NodeID, err))
+				removeNode(s)
+				continue
+			}
+		}
+		services = append(services, s)
+		nodeConfigs = append(nodeConfigs, nodeConfig)
+	}
+	p.Service, p.nodeConfigs = services, nodeConfigs
+	return errors.Join(errs...)
+}
+
+// removeNode stops the node and removes it from the running core
+func removeNode(s service.Service) error {
+	if c, ok := s.(*controller.Controller); ok {
+		return c.Remove()
+	}
+	return s.Close()
+}
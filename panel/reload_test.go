@@ -0,0 +1,159 @@
+package panel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Xray-P/api"
+	"Xray-P/api/sspanel"
+	"Xray-P/common/mylego"
+	"Xray-P/service/controller"
+)
+
+// testPanel serves the node info of every node on the port given by ports
+func testPanel(t *testing.T, ports map[int]string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var nodeID int
+		if _, err := fmt.Sscanf(r.URL.Path, "/mod_mu/nodes/%d/info", &nodeID); err == nil {
+			nodeInfo, _ := json.Marshal(sspanel.NodeInfoResponse{
+				RawServerString: fmt.Sprintf("127.0.0.1;%s;0;none;tcp;server=127.0.0.1", ports[nodeID]),
+				CustomConfig:    json.RawMessage(fmt.Sprintf(`{"offset_port_node": "%s"}`, ports[nodeID])),
+				Type:            "1",
+			})
+			json.NewEncoder(w).Encode(sspanel.Response{Ret: 1, Data: json.RawMessage(nodeInfo)})
+			return
+		}
+		if r.URL.Path == "/mod_mu/users" {
+			users, _ := json.Marshal([]sspanel.UserResponse{{ID: 7, UUID: "b831381d-6324-4d53-ad4f-8cda48b30811"}})
+			json.NewEncoder(w).Encode(sspanel.Response{Ret: 1, Data: json.RawMessage(users)})
+			return
+		}
+		json.NewEncoder(w).Encode(struct {
+			Ret int `json:"ret"`
+		}{Ret: 1})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func freePort(t *testing.T) string {
+	_, port, _ := net.SplitHostPort(freeAddress(t))
+	return port
+}
+
+func TestReload(t *testing.T) {
+	ports := map[int]string{43: freePort(t), 44: freePort(t), 45: freePort(t)}
+	ts := testPanel(t, ports)
+	node := func(nodeID int, updatePeriodic int) *NodesConfig {
+		return &NodesConfig{
+			PanelType: "SSpanel",
+			ApiConfig: &api.Config{APIHost: ts.URL, Key: "123", NodeID: nodeID, NodeType: "V2ray"},
+			ControllerConfig: &controller.Config{
+				ListenIP:       "127.0.0.1",
+				UpdatePeriodic: updatePeriodic,
+				CertConfig:     &mylego.CertConfig{CertMode: "none"},
+			},
+		}
+	}
+	config := func(nodes ...*NodesConfig) *Config {
+		return &Config{ConnectionConfig: &ConnectionConfig{ConnIdle: 30}, NodesConfig: nodes}
+	}
+	controllers := func(p *Panel) map[int]*controller.Controller {
+		m := make(map[int]*controller.Controller)
+		for _, c := range p.Controllers() {
+			m[c.ClientInfo().NodeID] = c
+		}
+		return m
+	}
+
+	p := New(config(node(43, 60), node(44, 60)))
+	p.Start()
+	defer func() { p.Close() }()
+	server, before := p.Server, controllers(p)
+
+	// Node 44 changed, node 45 added, node 43 kept
+	if err := p.Reload(config(node(43, 60), node(44, 30), node(45, 60))); err != nil {
+		t.Fatal(err)
+	}
+	after := controllers(p)
+	if p.Server != server {
+		t.Error("the core was restarted for a node change")
+	}
+	if len(after) != 3 || after[43] != before[43] || after[44] == before[44] || after[45] == nil {
+		t.Errorf("unexpected nodes after the reload: %v, before %v", after, before)
+	}
+
+	// Node 44 removed
+	if err := p.Reload(config(node(43, 60), node(45, 60))); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := controllers(p); len(nodes) != 2 || nodes[44] != nil || nodes[43] != before[43] {
+		t.Errorf("unexpected nodes after removing one: %v", nodes)
+	}
+	if conn, err := net.DialTimeout("tcp", "127.0.0.1:"+ports[44], time.Second); err == nil {
+		conn.Close()
+		t.Error("the inbound of the removed node still accepts connections")
+	}
+
+	// An invalid config keeps the running one
+	invalid := config(node(43, 60))
+	invalid.RouteConfigPath = "/nonexistent/route.json"
+	if err := p.Reload(invalid); err == nil {
+		t.Error("invalid config accepted")
+	}
+	if nodes := controllers(p); len(nodes) != 2 || p.Server != server {
+		t.Errorf("the running config changed after an invalid reload: %v", nodes)
+	}
+
+	// An invalid node config is rejected before the running node is stopped
+	invalidNode := node(43, 30)
+	invalidNode.ControllerConfig.ListenIP = "0.0.0"
+	if err := p.Reload(config(invalidNode, node(45, 60))); err == nil {
+		t.Error("invalid node config accepted")
+	}
+	if nodes := controllers(p); len(nodes) != 2 || nodes[43] != before[43] {
+		t.Errorf("the running node changed after an invalid node reload: %v", nodes)
+	}
+	if conn, err := net.DialTimeout("tcp", "127.0.0.1:"+ports[43], time.Second); err != nil {
+		t.Errorf("the running node stopped accepting connections: %s", err)
+	} else {
+		conn.Close()
+	}
+
+	// A new core whose node fails to start, the panel has no port for node 46, is
+	// closed and the running core is kept
+	changed := config(node(43, 60), node(45, 60), node(46, 60))
+	changed.ConnectionConfig.ConnIdle = 60
+	if err := p.Reload(changed); err == nil {
+		t.Error("core with a failing node accepted")
+	}
+	if nodes := controllers(p); p.Server != server || len(nodes) != 2 || nodes[43] != before[43] {
+		t.Errorf("the running core changed after a failed restart: %v", nodes)
+	}
+	if conn, err := net.DialTimeout("tcp", "127.0.0.1:"+ports[43], time.Second); err != nil {
+		t.Errorf("the running core stopped accepting connections: %s", err)
+	} else {
+		conn.Close()
+	}
+
+	// A core section changed restarts everything
+	changed = config(node(43, 60), node(45, 60))
+	changed.ConnectionConfig.ConnIdle = 60
+	if err := p.Reload(changed); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := controllers(p); p.Server == server || len(nodes) != 2 || nodes[43] == before[43] {
+		t.Errorf("the core was not restarted: %v", nodes)
+	}
+	if conn, err := net.DialTimeout("tcp", "127.0.0.1:"+ports[43], time.Second); err != nil {
+		t.Errorf("the new core does not accept connections: %s", err)
+	} else {
+		conn.Close()
+	}
+}
@@ -0,0 +1,380 @@
+// Package admin serves a local HTTP API to inspect and control the running nodes
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"Xray-P/api"
+	"Xray-P/common/mylego"
+	"Xray-P/service/controller"
+)
+
+// DefaultListen is the socket of the API when Listen is not set
+const DefaultListen = "unix:/run/xrayp/admin.sock"
+
+type Config struct {
+	Enable bool   `mapstructure:"Enable"`
+	Listen string `mapstructure:"Listen"` // unix:/path/to/socket or a loopback address like 127.0.0.1:9200
+	Token  string `mapstructure:"Token"`
+}
+
+// Node is a running node as listed by the API
+type Node struct {
+	Tag      string        `json:"tag"`
+	Panel    string        `json:"panel"`
+	NodeID   int           `json:"node_id"`
+	NodeType string        `json:"node_type"`
+	NodeInfo *api.NodeInfo `json:"node_info"`
+}
+
+// NodeStatus sums up a running node
+type NodeStatus struct {
+	Tag         string `json:"tag"`
+	Panel       string `json:"panel"`
+	NodeID      int    `json:"node_id"`
+	NodeType    string `json:"node_type"`
+	Users       int    `json:"users"`
+	OnlineUsers int    `json:"online_users"`
+	OnlineIPs   int    `json:"online_ips"`
+	Limited     int    `json:"limited"`
+	Warned      int    `json:"warned"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Status sums up the running process
+type Status struct {
+	StartedAt time.Time    `json:"started_at"`
+	Nodes     []NodeStatus `json:"nodes"`
+}
+
+// Server serves the admin API
+type Server struct {
+	config      *Config
+	controllers func() []*controller.Controller
+	reload      func() error
+	dump        func(node string, users int) ([]byte, error)
+	server      *http.Server
+	startedAt   time.Time
+}
+
+// New returns the admin API over the controllers, reload is called to reload the config file
+// and dump returns the Xray config of a node, or of every node when node is empty
+func New(config *Config, controllers func() []*controller.Controller, reload func() error, dump func(node string, users int) ([]byte, error)) *Server {
+	s := &Server{
+		config:      config,
+		controllers: controllers,
+		reload:      reload,
+		dump:        dump,
+		startedAt:   time.Now(),
+	}
+	s.server = &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	return s
+}
+
+// Start listens on config.Listen and serves the API
+func (s *Server) Start() error {
+	listener, err := listen(s.config)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Admin API stopped: %s", err)
+		}
+	}()
+	log.Printf("Admin API listening on %s", listener.Addr())
+	return nil
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func listen(config *Config) (net.Listener, error) {
+	address := config.Listen
+	if address == "" {
+		address = DefaultListen
+	}
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		// Remove the socket left by an unclean exit
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0o600); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("admin API must listen on a unix socket or a loopback address: %s", address)
+	}
+	if config.Token == "" {
+		return nil, errors.New("admin API on a TCP address requires a Token")
+	}
+	return net.Listen("tcp", address)
+}
+
+// Handler returns the HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.status)
+	mux.HandleFunc("GET /nodes", s.listNodes)
+	mux.HandleFunc("POST /sync", s.syncAll)
+	mux.HandleFunc("POST /reload", s.reloadConfig)
+	mux.HandleFunc("GET /dump", s.dumpConfig)
+	mux.HandleFunc("GET /certs", s.listCerts)
+	mux.HandleFunc("GET /nodes/{node}/users", s.listUsers)
+	mux.HandleFunc("GET /nodes/{node}/limited", s.listLimited)
+	mux.HandleFunc("POST /nodes/{node}/sync", s.syncNode)
+	mux.HandleFunc("POST /nodes/{node}/users/{uid}/kick", s.kickUser)
+	mux.HandleFunc("POST /nodes/{node}/users/{uid}/speed", s.overrideSpeed)
+	return s.authorize(mux)
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.Token != "" {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// findNode returns the controller of the node given by its tag or node ID
+func (s *Server) findNode(w http.ResponseWriter, r *http.Request) (*controller.Controller, bool) {
+	name := r.PathValue("node")
+	for _, c := range s.controllers() {
+		if c.CurrentTag() == name || strconv.Itoa(c.ClientInfo().NodeID) == name {
+			return c, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("no such node: %s", name))
+	return nil, false
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	status := Status{StartedAt: s.startedAt, Nodes: make([]NodeStatus, 0)}
+	for _, c := range s.controllers() {
+		clientInfo := c.ClientInfo()
+		node := NodeStatus{
+			Tag:      c.CurrentTag(),
+			Panel:    clientInfo.APIHost,
+			NodeID:   clientInfo.NodeID,
+			NodeType: clientInfo.NodeType,
+		}
+		if users, err := c.Users(); err != nil {
+			node.Error = err.Error()
+		} else {
+			node.Users = len(users)
+			for _, u := range users {
+				if len(u.OnlineIPs) > 0 {
+					node.OnlineUsers++
+					node.OnlineIPs += len(u.OnlineIPs)
+				}
+			}
+		}
+		limited, warned := c.LimitedUsers()
+		node.Limited, node.Warned = len(limited), len(warned)
+		status.Nodes = append(status.Nodes, node)
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) listNodes(w http.ResponseWriter, r *http.Request) {
+	nodes := make([]Node, 0)
+	for _, c := range s.controllers() {
+		clientInfo := c.ClientInfo()
+		nodes = append(nodes, Node{
+			Tag:      c.CurrentTag(),
+			Panel:    clientInfo.APIHost,
+			NodeID:   clientInfo.NodeID,
+			NodeType: clientInfo.NodeType,
+			NodeInfo: c.NodeInfo(),
+		})
+	}
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.findNode(w, r)
+	if !ok {
+		return
+	}
+	users, err := c.Users()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (s *Server) listLimited(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.findNode(w, r)
+	if !ok {
+		return
+	}
+	limited, warned := c.LimitedUsers()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"limited": limited, "warned": warned})
+}
+
+func (s *Server) syncNode(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.findNode(w, r)
+	if !ok {
+		return
+	}
+	if err := c.Sync(); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "synced"})
+}
+
+func (s *Server) syncAll(w http.ResponseWriter, r *http.Request) {
+	failed := make(map[string]string)
+	for _, c := range s.controllers() {
+		if err := c.Sync(); err != nil {
+			failed[c.CurrentTag()] = err.Error()
+		}
+	}
+	if len(failed) > 0 {
+		writeJSON(w, http.StatusBadGateway, map[string]interface{}{"error": "sync failed", "nodes": failed})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "synced"})
+}
+
+func (s *Server) kickUser(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.findNode(w, r)
+	if !ok {
+		return
+	}
+	uid, err := strconv.Atoi(r.PathValue("uid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid uid: %s", r.PathValue("uid")))
+		return
+	}
+	kicked, err := c.Kick(uid)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"kicked": kicked})
+}
+
+type speedRequest struct {
+	Speed    int    `json:"speed"`    // Mbps, 0 removes the user speed limit
+	Duration string `json:"duration"` // e.g. 30m
+}
+
+func (s *Server) overrideSpeed(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.findNode(w, r)
+	if !ok {
+		return
+	}
+	uid, err := strconv.Atoi(r.PathValue("uid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid uid: %s", r.PathValue("uid")))
+		return
+	}
+	req := speedRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || req.Speed < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid speed override: %d Mbps for %q", req.Speed, req.Duration))
+		return
+	}
+	if err := c.OverrideSpeed(uid, req.Speed, duration); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "overridden"})
+}
+
+func (s *Server) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	// Reloading may start a new core with every node, answer first
+	go func() {
+		if err := s.reload(); err != nil {
+			log.Errorf("Reload config failed: %s", err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "reloading"})
+}
+
+// dumpConfig returns the Xray config of the node given by the node query, with the
+// number of sample users given by the users query
+func (s *Server) dumpConfig(w http.ResponseWriter, r *http.Request) {
+	users := 3
+	if q := r.URL.Query().Get("users"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid users: %s", q))
+			return
+		}
+		users = n
+	}
+	data, err := s.dump(r.URL.Query().Get("node"), users)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// listCerts returns the certificates kept renewed by the certificate manager
+func (s *Server) listCerts(w http.ResponseWriter, r *http.Request) {
+	certs := mylego.Status()
+	if certs == nil {
+		certs = make([]mylego.CertStatus, 0)
+	}
+	writeJSON(w, http.StatusOK, certs)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugf("Write admin API response failed: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,120 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Xray-P/service/controller"
+)
+
+func TestHandler(t *testing.T) {
+	reloaded := make(chan struct{}, 1)
+	s := New(&Config{Token: "secret"}, func() []*controller.Controller { return nil }, func() error {
+		reloaded <- struct{}{}
+		return nil
+	}, nil)
+	handler := s.Handler()
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("GET", "/nodes", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("missing token: got %d", rec.Code)
+	}
+	if rec := do("GET", "/nodes", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: got %d", rec.Code)
+	}
+	if rec := do("GET", "/nodes", "secret"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("list nodes: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("POST", "/nodes/12/users/1/kick", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown node: got %d", rec.Code)
+	}
+	if rec := do("GET", "/dump?users=-1", "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("dump with invalid users: got %d", rec.Code)
+	}
+	if rec := do("GET", "/reload", "secret"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("reload with GET: got %d", rec.Code)
+	}
+	if rec := do("POST", "/reload", "secret"); rec.Code != http.StatusAccepted {
+		t.Errorf("reload: got %d", rec.Code)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Error("reload not called")
+	}
+}
+
+func TestListen(t *testing.T) {
+	for _, c := range []*Config{
+		{Listen: "0.0.0.0:9200", Token: "secret"},
+		{Listen: "10.0.0.1:9200", Token: "secret"},
+		{Listen: "127.0.0.1:0"},
+	} {
+		if l, err := listen(c); err == nil {
+			l.Close()
+			t.Errorf("listen %s with token %q accepted", c.Listen, c.Token)
+		}
+	}
+
+	l, err := listen(&Config{Listen: "unix:" + filepath.Join(t.TempDir(), "admin.sock")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
+
+func TestClient(t *testing.T) {
+	config := &Config{Listen: "unix:" + filepath.Join(t.TempDir(), "admin.sock"), Token: "secret"}
+	dump := func(node string, users int) ([]byte, error) {
+		if node != "" {
+			return nil, fmt.Errorf("no such node: %s", node)
+		}
+		return []byte(fmt.Sprintf(`{"users": %d}`, users)), nil
+	}
+	s := New(config, func() []*controller.Controller { return nil }, func() error { return nil }, dump)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	client := NewClient(config)
+	status, err := client.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.StartedAt.IsZero() || len(status.Nodes) != 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if err := client.Sync(""); err != nil {
+		t.Error(err)
+	}
+	if _, err := client.Kick("12", 1); err == nil || err.Error() != "no such node: 12" {
+		t.Errorf("kick on unknown node: %v", err)
+	}
+	if data, err := client.Dump("", 5); err != nil || string(data) != `{"users": 5}` {
+		t.Errorf("dump: %s %v", data, err)
+	}
+	if _, err := client.Dump("12", 5); err == nil || err.Error() != "no such node: 12" {
+		t.Errorf("dump of unknown node: %v", err)
+	}
+	if certs, err := client.Certs(); err != nil || certs == nil || len(certs) != 0 {
+		t.Errorf("certs without certificate manager: %v %v", certs, err)
+	}
+	if _, err := NewClient(&Config{Listen: config.Listen, Token: "wrong"}).Nodes(); err == nil || err.Error() != "invalid token" {
+		t.Errorf("wrong token: %v", err)
+	}
+}
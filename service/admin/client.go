@@ -0,0 +1,170 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"Xray-P/common/mylego"
+	"Xray-P/service/controller"
+)
+
+// Client talks to the admin API of a running process
+type Client struct {
+	client *http.Client
+	base   string
+	token  string
+}
+
+// NewClient returns a client of the API served with config
+func NewClient(config *Config) *Client {
+	address := config.Listen
+	if address == "" {
+		address = DefaultListen
+	}
+	c := &Client{
+		client: &http.Client{Timeout: 2 * time.Minute},
+		base:   "http://" + address,
+		token:  config.Token,
+	}
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		c.base = "http://unix"
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+func (c *Client) do(method string, path string, body interface{}, result interface{}) error {
+	data, err := c.request(method, path, body)
+	if err != nil || result == nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+// request returns the body of the response
+func (c *Client) request(method string, path string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := struct {
+			Error string            `json:"error"`
+			Nodes map[string]string `json:"nodes"`
+		}{}
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("admin API returned %s", resp.Status)
+		}
+		for tag, err := range apiErr.Nodes {
+			apiErr.Error += fmt.Sprintf("; %s: %s", tag, err)
+		}
+		return nil, fmt.Errorf("%s", apiErr.Error)
+	}
+	return data, nil
+}
+
+func nodePath(node string) string {
+	return "/nodes/" + url.PathEscape(node)
+}
+
+func (c *Client) Status() (*Status, error) {
+	status := &Status{}
+	err := c.do(http.MethodGet, "/status", nil, status)
+	return status, err
+}
+
+func (c *Client) Nodes() ([]Node, error) {
+	var nodes []Node
+	err := c.do(http.MethodGet, "/nodes", nil, &nodes)
+	return nodes, err
+}
+
+func (c *Client) Users(node string) ([]controller.UserStatus, error) {
+	var users []controller.UserStatus
+	err := c.do(http.MethodGet, nodePath(node)+"/users", nil, &users)
+	return users, err
+}
+
+func (c *Client) LimitedUsers(node string) ([]controller.LimitedUser, []controller.WarnedUser, error) {
+	result := struct {
+		Limited []controller.LimitedUser `json:"limited"`
+		Warned  []controller.WarnedUser  `json:"warned"`
+	}{}
+	err := c.do(http.MethodGet, nodePath(node)+"/limited", nil, &result)
+	return result.Limited, result.Warned, err
+}
+
+// Sync syncs the node with the panel now, every node when node is empty
+func (c *Client) Sync(node string) error {
+	if node == "" {
+		return c.do(http.MethodPost, "/sync", nil, nil)
+	}
+	return c.do(http.MethodPost, nodePath(node)+"/sync", nil, nil)
+}
+
+// Kick closes the connections of the user and returns how many were closed
+func (c *Client) Kick(node string, uid int) (int, error) {
+	result := map[string]int{}
+	err := c.do(http.MethodPost, fmt.Sprintf("%s/users/%d/kick", nodePath(node), uid), nil, &result)
+	return result["kicked"], err
+}
+
+// OverrideSpeed limits the user to speed Mbps for the duration
+func (c *Client) OverrideSpeed(node string, uid int, speed int, duration time.Duration) error {
+	return c.do(http.MethodPost, fmt.Sprintf("%s/users/%d/speed", nodePath(node), uid),
+		speedRequest{Speed: speed, Duration: duration.String()}, nil)
+}
+
+// Reload asks the process to reload its config file, it returns before the reload is done
+func (c *Client) Reload() error {
+	return c.do(http.MethodPost, "/reload", nil, nil)
+}
+
+// Dump returns the Xray config of the node with sample users, every node when node is empty
+func (c *Client) Dump(node string, users int) ([]byte, error) {
+	query := url.Values{"users": {strconv.Itoa(users)}}
+	if node != "" {
+		query.Set("node", node)
+	}
+	return c.request(http.MethodGet, "/dump?"+query.Encode(), nil)
+}
+
+// Certs returns the certificates kept renewed by the process
+func (c *Client) Certs() ([]mylego.CertStatus, error) {
+	var certs []mylego.CertStatus
+	err := c.do(http.MethodGet, "/certs", nil, &certs)
+	return certs, err
+}
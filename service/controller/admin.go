@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"Xray-P/api"
+)
+
+// UserStatus is the live state of a user on the node
+type UserStatus struct {
+	UID         int      `json:"uid"`
+	Email       string   `json:"email"`
+	SpeedLimit  uint64   `json:"speed_limit"` // Bps
+	DeviceLimit int      `json:"device_limit"`
+	Upload      int64    `json:"upload"`   // Not reported to the panel yet
+	Download    int64    `json:"download"` // Not reported to the panel yet
+	OnlineIPs   []string `json:"online_ips"`
+	Sessions    int      `json:"sessions"`
+}
+
+// LimitedUser is a user whose speed is limited for a while, by the auto speed limit or an override
+type LimitedUser struct {
+	UID        int       `json:"uid"`
+	Email      string    `json:"email"`
+	SpeedLimit int       `json:"speed_limit"` // Mbps
+	End        time.Time `json:"end"`
+	Override   bool      `json:"override"`
+}
+
+// WarnedUser is a user over the auto speed limit not limited yet
+type WarnedUser struct {
+	UID      int    `json:"uid"`
+	Email    string `json:"email"`
+	Warnings int    `json:"warnings"`
+}
+
+// ClientInfo returns the panel and node the controller serves
+func (c *Controller) ClientInfo() api.ClientInfo {
+	return c.apiClient.Describe()
+}
+
+// CurrentTag returns the inbound tag, it changes with the node info
+func (c *Controller) CurrentTag() string {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.Tag
+}
+
+// NodeInfo returns the node info last synced from the panel
+func (c *Controller) NodeInfo() *api.NodeInfo {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.nodeInfo
+}
+
+// Users returns the users of the node with their traffic and online IPs
+func (c *Controller) Users() ([]UserStatus, error) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	onlineIPs, err := c.GetOnlineIPs(c.Tag)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := c.GetSessionCount(c.Tag)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]UserStatus, 0, len(*c.userList))
+	for _, user := range *c.userList {
+		userTag := c.buildUserTag(&user)
+		up, down, _, _ := c.getTraffic(userTag)
+		users = append(users, UserStatus{
+			UID:         user.UID,
+			Email:       user.Email,
+			SpeedLimit:  user.SpeedLimit,
+			DeviceLimit: user.DeviceLimit,
+			Upload:      up,
+			Download:    down,
+			OnlineIPs:   onlineIPs[userTag],
+			Sessions:    sessions[userTag],
+		})
+	}
+	return users, nil
+}
+
+// LimitedUsers returns the users limited and warned by the auto speed limit and the speed overrides
+func (c *Controller) LimitedUsers() ([]LimitedUser, []WarnedUser) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	limited := make([]LimitedUser, 0)
+	for user, limitInfo := range c.limitedUsers {
+		limited = append(limited, LimitedUser{
+			UID:        user.UID,
+			Email:      user.Email,
+			SpeedLimit: limitInfo.currentSpeedLimit,
+			End:        time.Unix(limitInfo.end, 0),
+		})
+	}
+	for uid, limitInfo := range c.overrides {
+		user, _ := c.findUser(uid)
+		limited = append(limited, LimitedUser{
+			UID:        uid,
+			Email:      user.Email,
+			SpeedLimit: limitInfo.currentSpeedLimit,
+			End:        time.Unix(limitInfo.end, 0),
+			Override:   true,
+		})
+	}
+	warned := make([]WarnedUser, 0)
+	for user, times := range c.warnedUsers {
+		warned = append(warned, WarnedUser{UID: user.UID, Email: user.Email, Warnings: times})
+	}
+	sort.Slice(limited, func(i, j int) bool { return limited[i].UID < limited[j].UID })
+	sort.Slice(warned, func(i, j int) bool { return warned[i].UID < warned[j].UID })
+	return limited, warned
+}
+
+// Sync fetches the node and users from the panel and reports the traffic now
+func (c *Controller) Sync() error {
+	c.syncing.Lock()
+	defer c.syncing.Unlock()
+	if err := c.nodeInfoMonitor(); err != nil {
+		return err
+	}
+	return c.userInfoMonitor()
+}
+
+// Kick closes every connection of the user, it returns the number of connections closed
+func (c *Controller) Kick(uid int) (int, error) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	user, ok := c.findUser(uid)
+	if !ok {
+		return 0, fmt.Errorf("no such user: %d", uid)
+	}
+	userTag := c.buildUserTag(&user)
+	kicked, err := c.KickUser(c.Tag, userTag)
+	if err != nil {
+		return 0, err
+	}
+	c.userLogger(&user).WithField("connections", kicked).Print("User kicked")
+	return kicked, nil
+}
+
+// OverrideSpeed limits the user to speed Mbps for the duration, 0 removes the user speed limit.
+// The user gets back its panel speed limit when the override ends.
+func (c *Controller) OverrideSpeed(uid int, speed int, duration time.Duration) error {
+	c.access.Lock()
+	defer c.access.Unlock()
+	user, ok := c.findUser(uid)
+	if !ok {
+		return fmt.Errorf("no such user: %d", uid)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("invalid override duration: %s", duration)
+	}
+	c.overrides[uid] = LimitInfo{
+		end:               time.Now().Add(duration).Unix(),
+		currentSpeedLimit: speed,
+		originSpeedLimit:  user.SpeedLimit,
+	}
+	c.userLogger(&user).WithFields(log.Fields{
+		"speed": speed,
+		"end":   time.Unix(c.overrides[uid].end, 0).Format(time.RFC3339),
+	}).Print("User speed overridden")
+	return c.applySpeedOverrides()
+}
+
+// applySpeedOverrides pushes the active speed overrides to the limiter
+// and restores the users whose override has ended
+func (c *Controller) applySpeedOverrides() error {
+	if len(c.overrides) == 0 {
+		return nil
+	}
+	updatedUsers := make([]api.UserInfo, 0, len(c.overrides))
+	for uid, limitInfo := range c.overrides {
+		user, ok := c.findUser(uid)
+		if !ok {
+			delete(c.overrides, uid)
+			continue
+		}
+		if time.Now().Unix() > limitInfo.end {
+			user.SpeedLimit = limitInfo.originSpeedLimit
+			c.userLogger(&user).WithField("speed_limit", user.SpeedLimit).Print("User speed override ended")
+			delete(c.overrides, uid)
+		} else {
+			user.SpeedLimit = uint64((limitInfo.currentSpeedLimit * 1000000) / 8)
+		}
+		updatedUsers = append(updatedUsers, user)
+	}
+	if len(updatedUsers) == 0 {
+		return nil
+	}
+	return c.UpdateInboundLimiter(c.Tag, &updatedUsers)
+}
+
+func (c *Controller) findUser(uid int) (api.UserInfo, bool) {
+	for _, user := range *c.userList {
+		if user.UID == uid {
+			return user, true
+		}
+	}
+	return api.UserInfo{}, false
+}
+
+// Dump returns the Xray config of the node from the last synced node info, with the
+// first sampleUsers users
+func (c *Controller) Dump(sampleUsers int) (*NodeConfig, error) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	if c.nodeInfo == nil {
+		return nil, fmt.Errorf("node info of %s not synced yet", c.Tag)
+	}
+	var users []api.UserInfo
+	if c.userList != nil {
+		users = *c.userList
+	}
+	if len(users) > sampleUsers {
+		users = users[:sampleUsers]
+	}
+	return BuildNodeConfig(c.config, c.nodeInfo, c.Tag, users)
+}
@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/xtls/xray-core/xrayr/certstore"
+
+	"Xray-P/common/event"
+	"Xray-P/common/mylego"
+)
+
+// Certificate tools write the certificate and the key one after the other, the pairs are
+// checked once both are written
+const certChangeDelay = 500 * time.Millisecond
+
+// certWatcher calls onChange when the content of a certificate pair changes. The
+// directories are watched and any change in them checks their pairs: certificate tools
+// replace the files, and Kubernetes secret volumes or certbot swap the symlinks to them
+// without a change of the file names.
+type certWatcher struct {
+	watcher  *fsnotify.Watcher
+	onChange func(*mylego.CertFileConfig)
+	dirs     map[string][]*mylego.CertFileConfig // Key: watched directory, the pairs with a file in it
+	access   sync.Mutex
+	sums     map[*mylego.CertFileConfig][sha256.Size]byte // Content of each pair last seen
+	timers   map[string]*time.Timer                       // Key: directory
+	closed   bool
+}
+
+func newCertWatcher(pairs []*mylego.CertFileConfig, onChange func(*mylego.CertFileConfig)) (*certWatcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &certWatcher{
+		watcher:  fw,
+		onChange: onChange,
+		dirs:     make(map[string][]*mylego.CertFileConfig),
+		sums:     make(map[*mylego.CertFileConfig][sha256.Size]byte),
+		timers:   make(map[string]*time.Timer),
+	}
+	for _, pair := range pairs {
+		w.sums[pair] = pairSum(pair)
+		for _, f := range []string{pair.CertFile, pair.KeyFile} {
+			abs, err := filepath.Abs(f)
+			if err != nil {
+				fw.Close()
+				return nil, err
+			}
+			dir := filepath.Dir(abs)
+			if n := len(w.dirs[dir]); n == 0 || w.dirs[dir][n-1] != pair {
+				w.dirs[dir] = append(w.dirs[dir], pair)
+			}
+		}
+	}
+	for dir := range w.dirs {
+		if err := fw.Add(dir); err != nil {
+			fw.Close()
+			return nil, err
+		}
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *certWatcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			dir := filepath.Dir(filepath.Clean(event.Name))
+			if w.dirs[dir] == nil {
+				continue
+			}
+			w.access.Lock()
+			if timer := w.timers[dir]; timer != nil {
+				timer.Stop()
+			}
+			w.timers[dir] = time.AfterFunc(certChangeDelay, func() { w.changed(dir) })
+			w.access.Unlock()
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("Watch certificate files failed: %s", err)
+		}
+	}
+}
+
+// changed calls onChange for the pairs of dir whose content changed
+func (w *certWatcher) changed(dir string) {
+	for _, pair := range w.dirs[dir] {
+		sum := pairSum(pair)
+		w.access.Lock()
+		closed, same := w.closed, w.sums[pair] == sum
+		w.sums[pair] = sum
+		w.access.Unlock()
+		if closed {
+			return
+		}
+		if !same {
+			w.onChange(pair)
+		}
+	}
+}
+
+// pairSum returns the hash of the content of the pair, the files which could not be
+// read count as empty so their loss is reported once
+func pairSum(pair *mylego.CertFileConfig) [sha256.Size]byte {
+	h := sha256.New()
+	for _, f := range []string{pair.CertFile, pair.KeyFile} {
+		data, _ := os.ReadFile(f)
+		h.Write(data)
+		h.Write([]byte{0})
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func (w *certWatcher) Close() error {
+	w.access.Lock()
+	w.closed = true
+	for _, timer := range w.timers {
+		timer.Stop()
+	}
+	w.access.Unlock()
+	return w.watcher.Close()
+}
+
+// userCertFiles returns the certificate pairs the user manages, the ones of CertMode file
+// and Certificates. The manager renews the ACME certificates itself.
+func userCertFiles(certConfig *mylego.CertConfig) []*mylego.CertFileConfig {
+	var pairs []*mylego.CertFileConfig
+	if certConfig.CertMode == "file" && certConfig.CertFile != "" && certConfig.KeyFile != "" {
+		pairs = append(pairs, &mylego.CertFileConfig{CertFile: certConfig.CertFile, KeyFile: certConfig.KeyFile})
+	}
+	for _, f := range certConfig.Certificates {
+		if f.CertFile != "" && f.KeyFile != "" {
+			pairs = append(pairs, f)
+		}
+	}
+	return pairs
+}
+
+// watchCerts reloads the certificate files of the node when they change on disk
+func (c *Controller) watchCerts() {
+	if c.config.CertConfig == nil || c.config.EnableREALITY {
+		return
+	}
+	pairs := userCertFiles(c.config.CertConfig)
+	if len(pairs) == 0 {
+		return
+	}
+	watcher, err := newCertWatcher(pairs, c.reloadCert)
+	if err != nil {
+		c.logger.WithError(err).Error("Watch certificate files failed, they are reloaded hourly")
+		return
+	}
+	c.certWatcher = watcher
+}
+
+// reloadCert serves the new certificate of the files to the next handshakes, the open
+// connections are kept. An invalid pair is rejected and the loaded one is still served.
+func (c *Controller) reloadCert(pair *mylego.CertFileConfig) {
+	logger := c.logger.WithField("cert_file", pair.CertFile)
+	leaf, err := certstore.Reload(pair.CertFile, pair.KeyFile)
+	if err != nil {
+		logger.WithError(err).Error("Certificate file rejected, the loaded certificate is still served")
+		c.events.Publish(&event.Event{
+			Type:    event.CertRejected,
+			Message: "Certificate file rejected",
+			Data:    map[string]interface{}{"cert_file": pair.CertFile, "key_file": pair.KeyFile, "error": err.Error()},
+		})
+		return
+	}
+	logger.Infof("Certificate reloaded, expires at %s", leaf.NotAfter.Format(time.RFC3339))
+	c.events.Publish(&event.Event{
+		Type:    event.CertRenewed,
+		Message: "Certificate reloaded",
+		Data:    map[string]interface{}{"domain": certName(leaf), "cert_file": pair.CertFile},
+	})
+	c.updateCertExpiry()
+}
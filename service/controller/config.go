@@ -2,7 +2,9 @@ package controller
 
 import (
 	"Xray-P/common/mylego"
+	"Xray-P/common/sharing"
 
+	"github.com/xtls/xray-core/xrayr/abuse"
 	"github.com/xtls/xray-core/xrayr/limiter"
 )
 
@@ -21,11 +23,15 @@ type Config struct {
 	DisableSniffing           bool                             `mapstructure:"DisableSniffing"`
 	AutoSpeedLimitConfig      *AutoSpeedLimitConfig            `mapstructure:"AutoSpeedLimitConfig"`
 	GlobalDeviceLimitConfig   *limiter.GlobalDeviceLimitConfig `mapstructure:"GlobalDeviceLimitConfig"`
+	AccountSharingConfig      *sharing.Config                  `mapstructure:"AccountSharingConfig"`
+	AbuseGuardConfig          *abuse.Config                    `mapstructure:"AbuseGuardConfig"`
 	FallBackConfigs           []*FallBackConfig                `mapstructure:"FallBackConfigs"`
 	DisableLocalREALITYConfig bool                             `mapstructure:"DisableLocalREALITYConfig"`
 	EnableREALITY             bool                             `mapstructure:"EnableREALITY"`
 	REALITYConfigs            *REALITYConfig                   `mapstructure:"REALITYConfigs"`
 	ObservatoryConfigPath     string                           `mapstructure:"ObservatoryConfigPath"`
+	InboundOverride           interface{}                      `mapstructure:"InboundOverride"`  // JSON merge patch of the generated inbound, a mapping or inline JSON
+	OutboundOverride          interface{}                      `mapstructure:"OutboundOverride"` // JSON merge patch of the generated outbound, a mapping or inline JSON
 }
 
 type AutoSpeedLimitConfig struct {
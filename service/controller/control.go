@@ -16,6 +16,7 @@ import (
 
 	"Xray-P/api"
 
+	"github.com/xtls/xray-core/xrayr/abuse"
 	"github.com/xtls/xray-core/xrayr/limiter"
 )
 
@@ -189,3 +190,29 @@ func (c *Controller) UpdateRule(tag string, newRuleList []api.DetectRule) error
 func (c *Controller) GetDetectResult(tag string) (*[]api.DetectResult, error) {
 	return c.dispatcher.RuleManager.GetDetectResult(tag)
 }
+
+func (c *Controller) AddInboundGuard(tag string, abuseGuardConfig *abuse.Config) error {
+	err := c.dispatcher.AbuseGuard.AddInboundGuard(tag, abuseGuardConfig)
+	return err
+}
+
+func (c *Controller) DeleteInboundGuard(tag string) error {
+	err := c.dispatcher.AbuseGuard.DeleteInboundGuard(tag)
+	return err
+}
+
+func (c *Controller) GetAbuseResult(tag string) (*[]api.DetectResult, error) {
+	return c.dispatcher.AbuseGuard.GetDetectResult(tag)
+}
+
+func (c *Controller) GetOnlineIPs(tag string) (map[string][]string, error) {
+	return c.dispatcher.Limiter.GetOnlineIPs(tag)
+}
+
+func (c *Controller) GetSessionCount(tag string) (map[string]int, error) {
+	return c.dispatcher.Limiter.GetSessionCount(tag)
+}
+
+func (c *Controller) KickUser(tag string, email string) (int, error) {
+	return c.dispatcher.Limiter.KickUser(tag, email)
+}
@@ -1,9 +1,13 @@
 package controller
 
 import (
+	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -18,8 +22,12 @@ import (
 	"github.com/xtls/xray-core/features/stats"
 
 	"Xray-P/api"
+	"Xray-P/common/event"
+	xraylog "Xray-P/common/log"
+	"Xray-P/common/metrics"
 	"Xray-P/common/mylego"
 	"Xray-P/common/serverstatus"
+	"Xray-P/common/sharing"
 )
 
 type LimitInfo struct {
@@ -29,6 +37,8 @@ type LimitInfo struct {
 }
 
 type Controller struct {
+	access       sync.Mutex
+	syncing      sync.Mutex // Serializes the panel syncs, they take access only to apply their results
 	server       *core.Instance
 	config       *Config
 	clientInfo   api.ClientInfo
@@ -37,8 +47,12 @@ type Controller struct {
 	Tag          string
 	userList     *[]api.UserInfo
 	tasks        []periodicTask
+	taskRuns     sync.Map // Key: task tag, Value: time.Time of the last run
 	limitedUsers map[api.UserInfo]LimitInfo
 	warnedUsers  map[api.UserInfo]int
+	overrides    map[int]LimitInfo // Key: UID, speed overrides set by the admin API
+	sharing      *sharing.Detector
+	certWatcher  *certWatcher
 	panelType    string
 	ibm          inbound.Manager
 	obm          outbound.Manager
@@ -47,6 +61,10 @@ type Controller struct {
 	dispatcher   *dispatcher.DefaultDispatcher
 	startAt      time.Time
 	logger       *log.Entry
+	metrics      *metrics.Node
+	events       *event.Node
+	status       sync.Mutex // Guards health, read by the health endpoints without waiting for a sync
+	health       nodeHealth
 }
 
 type periodicTask struct {
@@ -57,9 +75,9 @@ type periodicTask struct {
 // New return a Controller service with default parameters.
 func New(server *core.Instance, api api.API, config *Config) *Controller {
 	logger := log.NewEntry(log.StandardLogger()).WithFields(log.Fields{
-		"Host": api.Describe().APIHost,
-		"Type": api.Describe().NodeType,
-		"ID":   api.Describe().NodeID,
+		xraylog.FieldPanel:    api.Describe().APIHost,
+		xraylog.FieldNodeType: api.Describe().NodeType,
+		xraylog.FieldNodeID:   api.Describe().NodeID,
 	})
 	controller := &Controller{
 		server:     server,
@@ -73,6 +91,8 @@ func New(server *core.Instance, api api.API, config *Config) *Controller {
 		dispatcher: server.GetFeature(routing.DispatcherType()).(*dispatcher.DefaultDispatcher),
 		startAt:    time.Now(),
 		logger:     logger,
+		metrics:    metrics.NewNode(api.Describe()),
+		events:     event.NewNode(api.Describe()),
 	}
 
 	return controller
@@ -90,7 +110,7 @@ func (c *Controller) Start() error {
 		return errors.New("server port must > 0")
 	}
 	c.nodeInfo = newNodeInfo
-	c.Tag = c.buildNodeTag()
+	c.setTag(c.buildNodeTag())
 
 	// Add new tag
 	err = c.addNewTag(newNodeInfo)
@@ -106,6 +126,7 @@ func (c *Controller) Start() error {
 
 	// sync controller userList
 	c.userList = userInfo
+	c.panelSynced()
 
 	err = c.addNewUser(userInfo, newNodeInfo)
 	if err != nil {
@@ -116,15 +137,28 @@ func (c *Controller) Start() error {
 	if err := c.AddInboundLimiter(c.Tag, newNodeInfo.SpeedLimit, userInfo, c.config.GlobalDeviceLimitConfig); err != nil {
 		c.logger.Print(err)
 	}
+	c.dispatcher.ConnTrack.SetTransport(c.Tag, newNodeInfo.TransportProtocol)
+
+	// Add Abuse Guard
+	if err := c.AddInboundGuard(c.Tag, c.config.AbuseGuardConfig); err != nil {
+		c.logger.Print(err)
+	}
 
 	// Add Rule Manager
 	if !c.config.DisableGetRule {
 		if ruleList, err := c.apiClient.GetNodeRule(); err != nil {
-			c.logger.Printf("Get rule list filed: %s", err)
-		} else if len(*ruleList) > 0 {
-			if err := c.UpdateRule(c.Tag, *ruleList); err != nil {
-				c.logger.Print(err)
-			}
+			c.logger.WithError(err).Print("Get rule list failed")
+		} else if err := c.UpdateRule(c.Tag, *ruleList); err != nil {
+			c.logger.Print(err)
+		}
+	}
+
+	// Add account sharing detector
+	if c.config.AccountSharingConfig != nil && c.config.AccountSharingConfig.Enable {
+		if detector, err := sharing.New(c.config.AccountSharingConfig); err != nil {
+			c.logger.WithError(err).Print("Load account sharing detector failed")
+		} else {
+			c.sharing = detector
 		}
 	}
 
@@ -136,6 +170,7 @@ func (c *Controller) Start() error {
 		c.limitedUsers = make(map[api.UserInfo]LimitInfo)
 		c.warnedUsers = make(map[api.UserInfo]int)
 	}
+	c.overrides = make(map[int]LimitInfo)
 
 	// Add periodic tasks
 	c.tasks = append(c.tasks,
@@ -143,29 +178,25 @@ func (c *Controller) Start() error {
 			tag: "node monitor",
 			Periodic: &task.Periodic{
 				Interval: time.Duration(c.config.UpdatePeriodic) * time.Second,
-				Execute:  c.nodeInfoMonitor,
+				Execute:  c.timedTask("node monitor", c.syncTask(c.nodeInfoMonitor)),
 			}},
 		periodicTask{
 			tag: "user monitor",
 			Periodic: &task.Periodic{
 				Interval: time.Duration(c.config.UpdatePeriodic) * time.Second,
-				Execute:  c.userInfoMonitor,
+				Execute:  c.timedTask("user monitor", c.syncTask(c.userInfoMonitor)),
 			}},
 	)
 
-	// Check cert service in need
-	if c.nodeInfo.EnableTLS && c.config.EnableREALITY == false {
-		c.tasks = append(c.tasks, periodicTask{
-			tag: "cert monitor",
-			Periodic: &task.Periodic{
-				Interval: time.Duration(c.config.UpdatePeriodic) * time.Second * 60,
-				Execute:  c.certMonitor,
-			}})
-	}
+	// The certificates are renewed by the certificate manager of the panel, the files
+	// of CertMode file are reloaded when they change
+	c.updateCertExpiry()
+	c.watchCerts()
+	c.metrics.SetUsers(len(*c.userList))
 
 	// Start periodic tasks
 	for i := range c.tasks {
-		c.logger.Printf("Start %s periodic task", c.tasks[i].tag)
+		c.logger.WithField("task", c.tasks[i].tag).Print("Start periodic task")
 		go c.tasks[i].Start()
 	}
 
@@ -174,36 +205,169 @@ func (c *Controller) Start() error {
 
 // Close implement the Close() function of the service interface
 func (c *Controller) Close() error {
+	var closeErr error
 	for i := range c.tasks {
 		if c.tasks[i].Periodic != nil {
 			if err := c.tasks[i].Periodic.Close(); err != nil {
-				c.logger.Panicf("%s periodic task close failed: %s", c.tasks[i].tag, err)
+				c.logger.WithError(err).WithField("task", c.tasks[i].tag).Error("Periodic task close failed")
+				closeErr = fmt.Errorf("%s periodic task close failed: %s", c.tasks[i].tag, err)
 			}
 		}
 	}
+	mylego.Release(c)
+	if c.certWatcher != nil {
+		c.certWatcher.Close()
+	}
+	c.metrics.Delete()
+	c.events.Delete()
 
-	return nil
+	// Stop the background work of the api client, e.g. rule list watchers
+	if closer, ok := c.apiClient.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			c.logger.Print(err)
+		}
+	}
+
+	return closeErr
 }
 
-func (c *Controller) nodeInfoMonitor() (err error) {
-	// delay to start
-	if time.Since(c.startAt) < time.Duration(c.config.UpdatePeriodic)*time.Second {
-		return nil
+// Remove closes the node and removes its inbounds, outbounds, limiter and abuse guard,
+// so the core keeps running without the node
+func (c *Controller) Remove() error {
+	err := c.Close()
+	c.access.Lock()
+	defer c.access.Unlock()
+	if c.nodeInfo == nil {
+		return err
+	}
+	for _, tag := range c.inboundTags() {
+		// The inbound is already removed after a drain
+		if _, e := c.ibm.GetHandler(context.Background(), tag); e == nil {
+			if e := c.removeInbound(tag); e != nil && err == nil {
+				err = e
+			}
+		}
+		if c.obm.GetHandler(tag) != nil {
+			if e := c.removeOutbound(tag); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	if e := c.DeleteInboundLimiter(c.Tag); e != nil && err == nil {
+		err = e
+	}
+	c.dispatcher.ConnTrack.SetTransport(c.Tag, "")
+	if e := c.DeleteInboundGuard(c.Tag); e != nil && err == nil {
+		err = e
 	}
+	return err
+}
 
-	// First fetch Node Info
+// Drain stops the periodic tasks and the inbound of the node, then waits
+// for the open connections to be closed by the clients until ctx is done
+func (c *Controller) Drain(ctx context.Context) error {
+	for i := range c.tasks {
+		c.tasks[i].Periodic.Close()
+	}
+	c.access.Lock()
+	for _, tag := range c.inboundTags() {
+		if err := c.removeInbound(tag); err != nil {
+			c.access.Unlock()
+			return err
+		}
+	}
+	c.access.Unlock()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		count, err := c.GetSessionCount(c.Tag)
+		if err != nil {
+			return err
+		}
+		sessions := 0
+		for _, n := range count {
+			sessions += n
+		}
+		if sessions == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			c.logger.WithField("connections", sessions).Warn("Drain timed out")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// inboundTags returns the tags of the inbounds of the node, a Shadowsocks-Plugin node
+// has a dokodemo-door inbound in front of the Shadowsocks one
+func (c *Controller) inboundTags() []string {
+	tags := []string{c.Tag}
+	if c.nodeInfo != nil && c.nodeInfo.NodeType == "Shadowsocks-Plugin" {
+		tags = append(tags, fmt.Sprintf("dokodemo-door_%s+1", c.Tag))
+	}
+	return tags
+}
+
+// FlushTraffic reports the traffic counted since the last report to the panel
+func (c *Controller) FlushTraffic() error {
+	c.syncing.Lock()
+	defer c.syncing.Unlock()
+
+	c.access.Lock()
+	var userTraffic []api.UserTraffic
+	var upCounterList []stats.Counter
+	var downCounterList []stats.Counter
+	for _, user := range *c.userList {
+		up, down, upCounter, downCounter := c.getTraffic(c.buildUserTag(&user))
+		if up > 0 || down > 0 {
+			userTraffic = append(userTraffic, api.UserTraffic{
+				UID:      user.UID,
+				Email:    user.Email,
+				Upload:   up,
+				Download: down})
+			if upCounter != nil {
+				upCounterList = append(upCounterList, upCounter)
+			}
+			if downCounter != nil {
+				downCounterList = append(downCounterList, downCounter)
+			}
+		}
+	}
+	c.access.Unlock()
+	return c.reportTraffic(userTraffic, upCounterList, downCounterList)
+}
+
+// Stalled returns the periodic tasks that have not run for three of their intervals
+func (c *Controller) Stalled() []string {
+	var stalled []string
+	for i := range c.tasks {
+		lastRun := c.startAt
+		if v, ok := c.taskRuns.Load(c.tasks[i].tag); ok {
+			lastRun = v.(time.Time)
+		}
+		if time.Since(lastRun) > 3*c.tasks[i].Interval {
+			stalled = append(stalled, c.tasks[i].tag)
+		}
+	}
+	return stalled
+}
+
+func (c *Controller) nodeInfoMonitor() (err error) {
+	// The panel is fetched without the lock, which would block the admin API for the whole timeout
 	var nodeInfoChanged = true
 	newNodeInfo, err := c.apiClient.GetNodeInfo()
 	if err != nil {
 		if err.Error() == api.NodeNotModified {
 			nodeInfoChanged = false
-			newNodeInfo = c.nodeInfo
 		} else {
 			c.logger.Print(err)
+			c.panelFailed(err)
 			return nil
 		}
-	}
-	if newNodeInfo.Port == 0 {
+	} else if newNodeInfo.Port == 0 {
 		return errors.New("server port must > 0")
 	}
 
@@ -213,12 +377,47 @@ func (c *Controller) nodeInfoMonitor() (err error) {
 	if err != nil {
 		if err.Error() == api.UserNotModified {
 			usersChanged = false
-			newUserInfo = c.userList
 		} else {
 			c.logger.Print(err)
+			c.panelFailed(err)
 			return nil
 		}
 	}
+	c.panelSynced()
+
+	c.access.Lock()
+	updated := c.updateNode(newNodeInfo, nodeInfoChanged, newUserInfo, usersChanged)
+	c.access.Unlock()
+	if !updated || c.config.DisableGetRule {
+		return nil
+	}
+
+	// Check Rule
+	ruleList, err := c.apiClient.GetNodeRule()
+	if err != nil {
+		if err.Error() != api.RuleNotModified {
+			c.logger.WithError(err).Print("Get rule list failed")
+		}
+		return nil
+	}
+	c.access.Lock()
+	defer c.access.Unlock()
+	if err := c.UpdateRule(c.Tag, *ruleList); err != nil {
+		c.logger.Print(err)
+	}
+	return nil
+}
+
+// updateNode applies the node and users fetched from the panel, it returns false
+// if the node could not be updated. The caller holds c.access.
+func (c *Controller) updateNode(newNodeInfo *api.NodeInfo, nodeInfoChanged bool, newUserInfo *[]api.UserInfo, usersChanged bool) bool {
+	var err error
+	if !nodeInfoChanged {
+		newNodeInfo = c.nodeInfo
+	}
+	if !usersChanged {
+		newUserInfo = c.userList
+	}
 
 	// If nodeInfo changed
 	if nodeInfoChanged {
@@ -228,44 +427,52 @@ func (c *Controller) nodeInfoMonitor() (err error) {
 			err := c.removeOldTag(oldTag)
 			if err != nil {
 				c.logger.Print(err)
-				return nil
+				c.inboundFailed(err)
+				return false
 			}
 			if c.nodeInfo.NodeType == "Shadowsocks-Plugin" {
 				err = c.removeOldTag(fmt.Sprintf("dokodemo-door_%s+1", c.Tag))
 			}
 			if err != nil {
 				c.logger.Print(err)
-				return nil
+				c.inboundFailed(err)
+				return false
 			}
 			// Add new tag
 			c.nodeInfo = newNodeInfo
-			c.Tag = c.buildNodeTag()
+			c.setTag(c.buildNodeTag())
 			err = c.addNewTag(newNodeInfo)
 			if err != nil {
 				c.logger.Print(err)
-				return nil
+				c.inboundFailed(err)
+				return false
 			}
+			c.events.Publish(&event.Event{
+				Type:    event.NodeInfoChanged,
+				Message: "Node info changed, inbound rebuilt",
+				Data: map[string]interface{}{
+					"old_tag":     oldTag,
+					"node_type":   newNodeInfo.NodeType,
+					"port":        newNodeInfo.Port,
+					"transport":   newNodeInfo.TransportProtocol,
+					"tls":         newNodeInfo.EnableTLS,
+					"speed_limit": newNodeInfo.SpeedLimit,
+				},
+			})
 			nodeInfoChanged = true
 			// Remove Old limiter
 			if err = c.DeleteInboundLimiter(oldTag); err != nil {
 				c.logger.Print(err)
-				return nil
+				return false
 			}
-		} else {
-			nodeInfoChanged = false
-		}
-	}
-
-	// Check Rule
-	if !c.config.DisableGetRule {
-		if ruleList, err := c.apiClient.GetNodeRule(); err != nil {
-			if err.Error() != api.RuleNotModified {
-				c.logger.Printf("Get rule list filed: %s", err)
-			}
-		} else if len(*ruleList) > 0 {
-			if err := c.UpdateRule(c.Tag, *ruleList); err != nil {
+			c.dispatcher.ConnTrack.SetTransport(oldTag, "")
+			// Remove Old abuse guard
+			if err = c.DeleteInboundGuard(oldTag); err != nil {
 				c.logger.Print(err)
+				return false
 			}
+		} else {
+			nodeInfoChanged = false
 		}
 	}
 
@@ -273,13 +480,23 @@ func (c *Controller) nodeInfoMonitor() (err error) {
 		err = c.addNewUser(newUserInfo, newNodeInfo)
 		if err != nil {
 			c.logger.Print(err)
-			return nil
+			return false
 		}
 
 		// Add Limiter
 		if err := c.AddInboundLimiter(c.Tag, newNodeInfo.SpeedLimit, newUserInfo, c.config.GlobalDeviceLimitConfig); err != nil {
 			c.logger.Print(err)
-			return nil
+			return false
+		}
+		c.dispatcher.ConnTrack.SetTransport(c.Tag, newNodeInfo.TransportProtocol)
+		// Keep the speed overrides on the new limiter
+		if err := c.applySpeedOverrides(); err != nil {
+			c.logger.Print(err)
+		}
+
+		// Add Abuse Guard
+		if err := c.AddInboundGuard(c.Tag, c.config.AbuseGuardConfig); err != nil {
+			c.logger.Print(err)
 		}
 
 	} else {
@@ -307,10 +524,11 @@ func (c *Controller) nodeInfoMonitor() (err error) {
 				}
 			}
 		}
-		c.logger.Printf("%d user deleted, %d user added", len(deleted), len(added))
+		c.logger.WithFields(log.Fields{"deleted": len(deleted), "added": len(added)}).Print("Users updated")
 	}
 	c.userList = newUserInfo
-	return nil
+	c.metrics.SetUsers(len(*c.userList))
+	return true
 }
 
 func (c *Controller) removeOldTag(oldTag string) (err error) {
@@ -327,7 +545,7 @@ func (c *Controller) removeOldTag(oldTag string) (err error) {
 
 func (c *Controller) addNewTag(newNodeInfo *api.NodeInfo) (err error) {
 	if newNodeInfo.NodeType != "Shadowsocks-Plugin" {
-		inboundConfig, err := InboundBuilder(c.config, newNodeInfo, c.Tag)
+		inboundConfig, err := c.buildInbound(newNodeInfo, c.Tag)
 		if err != nil {
 			return err
 		}
@@ -359,7 +577,7 @@ func (c *Controller) addInboundForSSPlugin(newNodeInfo api.NodeInfo) (err error)
 	fakeNodeInfo.TransportProtocol = "tcp"
 	fakeNodeInfo.EnableTLS = false
 	// Add a regular Shadowsocks inbound and outbound
-	inboundConfig, err := InboundBuilder(c.config, &fakeNodeInfo, c.Tag)
+	inboundConfig, err := c.buildInbound(&fakeNodeInfo, c.Tag)
 	if err != nil {
 		return err
 	}
@@ -383,7 +601,7 @@ func (c *Controller) addInboundForSSPlugin(newNodeInfo api.NodeInfo) (err error)
 	fakeNodeInfo.Port++
 	fakeNodeInfo.NodeType = "dokodemo-door"
 	dokodemoTag := fmt.Sprintf("dokodemo-door_%s+1", c.Tag)
-	inboundConfig, err = InboundBuilder(c.config, &fakeNodeInfo, dokodemoTag)
+	inboundConfig, err = c.buildInbound(&fakeNodeInfo, dokodemoTag)
 	if err != nil {
 		return err
 	}
@@ -428,7 +646,7 @@ func (c *Controller) addNewUser(userInfo *[]api.UserInfo, nodeInfo *api.NodeInfo
 	if err != nil {
 		return err
 	}
-	c.logger.Printf("Added %d new users", len(*userInfo))
+	c.logger.WithField("added", len(*userInfo)).Print("Users added")
 	return nil
 }
 
@@ -476,17 +694,25 @@ func limitUser(c *Controller, user api.UserInfo, silentUsers *[]api.UserInfo) {
 		currentSpeedLimit: c.config.AutoSpeedLimitConfig.LimitSpeed,
 		originSpeedLimit:  user.SpeedLimit,
 	}
-	c.logger.Printf("Limit User: %s Speed: %d End: %s", c.buildUserTag(&user), c.config.AutoSpeedLimitConfig.LimitSpeed, time.Unix(c.limitedUsers[user].end, 0).Format("01-02 15:04:05"))
+	c.userLogger(&user).WithFields(log.Fields{
+		"speed": c.config.AutoSpeedLimitConfig.LimitSpeed,
+		"end":   time.Unix(c.limitedUsers[user].end, 0).Format(time.RFC3339),
+	}).Print("User speed limited")
+	c.events.Publish(&event.Event{
+		Type:    event.UserLimited,
+		UID:     user.UID,
+		Email:   user.Email,
+		Message: "User speed limited",
+		Data: map[string]interface{}{
+			"speed": c.config.AutoSpeedLimitConfig.LimitSpeed,
+			"end":   time.Unix(c.limitedUsers[user].end, 0).Format(time.RFC3339),
+		},
+	})
 	user.SpeedLimit = uint64((c.config.AutoSpeedLimitConfig.LimitSpeed * 1000000) / 8)
 	*silentUsers = append(*silentUsers, user)
 }
 
 func (c *Controller) userInfoMonitor() (err error) {
-	// delay to start
-	if time.Since(c.startAt) < time.Duration(c.config.UpdatePeriodic)*time.Second {
-		return nil
-	}
-
 	// Get server status
 	CPU, Mem, Disk, Uptime, err := serverstatus.GetSystemInfo()
 	if err != nil {
@@ -502,18 +728,66 @@ func (c *Controller) userInfoMonitor() (err error) {
 	if err != nil {
 		c.logger.Print(err)
 	}
+
+	c.access.Lock()
+	report := c.updateUsers()
+	c.access.Unlock()
+
+	// The reports are sent without the lock, which would block the admin API for the whole timeout
+	if err := c.reportTraffic(report.traffic, report.upCounters, report.downCounters); err != nil {
+		c.logger.Print(err)
+	}
+	if len(report.onlineDevice) > 0 {
+		if err = c.apiClient.ReportNodeOnlineUsers(&report.onlineDevice); err != nil {
+			c.logger.Print(err)
+		} else {
+			c.logger.WithField("online", len(report.onlineDevice)).Print("Reported online users")
+		}
+	}
+	if len(report.illegal) > 0 {
+		if err = c.apiClient.ReportIllegal(&report.illegal); err != nil {
+			c.logger.Print(err)
+		} else {
+			c.logger.WithField("illegal", len(report.illegal)).Print("Reported illegal behaviors")
+		}
+	}
+	return nil
+}
+
+// userReport is what a user monitor run reports to the panel
+type userReport struct {
+	traffic      []api.UserTraffic
+	upCounters   []stats.Counter
+	downCounters []stats.Counter
+	onlineDevice []api.OnlineUser
+	illegal      []api.DetectResult
+}
+
+// updateUsers releases and limits the users, and collects their traffic, online
+// devices and illegal behaviors to report. The caller holds c.access.
+func (c *Controller) updateUsers() *userReport {
+	report := &userReport{}
 	// Unlock users
 	if c.config.AutoSpeedLimitConfig.Limit > 0 && len(c.limitedUsers) > 0 {
-		c.logger.Printf("Limited users:")
 		toReleaseUsers := make([]api.UserInfo, 0)
 		for user, limitInfo := range c.limitedUsers {
 			if time.Now().Unix() > limitInfo.end {
 				user.SpeedLimit = limitInfo.originSpeedLimit
 				toReleaseUsers = append(toReleaseUsers, user)
-				c.logger.Printf("User: %s Speed: %d End: nil (Unlimit)", c.buildUserTag(&user), user.SpeedLimit)
+				c.userLogger(&user).WithField("speed_limit", user.SpeedLimit).Print("User speed limit released")
+				c.events.Publish(&event.Event{
+					Type:    event.UserReleased,
+					UID:     user.UID,
+					Email:   user.Email,
+					Message: "User speed limit released",
+					Data:    map[string]interface{}{"speed_limit": user.SpeedLimit},
+				})
 				delete(c.limitedUsers, user)
 			} else {
-				c.logger.Printf("User: %s Speed: %d End: %s", c.buildUserTag(&user), limitInfo.currentSpeedLimit, time.Unix(c.limitedUsers[user].end, 0).Format("01-02 15:04:05"))
+				c.userLogger(&user).WithFields(log.Fields{
+					"speed": limitInfo.currentSpeedLimit,
+					"end":   time.Unix(limitInfo.end, 0).Format(time.RFC3339),
+				}).Print("User speed still limited")
 			}
 		}
 		if len(toReleaseUsers) > 0 {
@@ -522,11 +796,12 @@ func (c *Controller) userInfoMonitor() (err error) {
 			}
 		}
 	}
+	// Release the speed overrides ended
+	if err := c.applySpeedOverrides(); err != nil {
+		c.logger.Print(err)
+	}
 
 	// Get User traffic
-	var userTraffic []api.UserTraffic
-	var upCounterList []stats.Counter
-	var downCounterList []stats.Counter
 	AutoSpeedLimit := int64(c.config.AutoSpeedLimitConfig.Limit)
 	UpdatePeriodic := int64(c.config.UpdatePeriodic)
 	limitedUsers := make([]api.UserInfo, 0)
@@ -534,7 +809,7 @@ func (c *Controller) userInfoMonitor() (err error) {
 		userTag := c.buildUserTag(&user)
 		up, down, upCounter, downCounter := c.getTraffic(userTag)
 		if down > 0 {
-			c.logger.Printf("Traffic counted: tag=%s up=%d down=%d", userTag, up, down)
+			c.userLogger(&user).WithFields(log.Fields{"up": up, "down": down}).Debug("Traffic counted")
 		}
 		if up > 0 || down > 0 {
 			// Over speed users
@@ -555,17 +830,18 @@ func (c *Controller) userInfoMonitor() (err error) {
 					delete(c.warnedUsers, user)
 				}
 			}
-			userTraffic = append(userTraffic, api.UserTraffic{
+			report.traffic = append(report.traffic, api.UserTraffic{
 				UID:      user.UID,
 				Email:    user.Email,
 				Upload:   up,
 				Download: down})
+			c.metrics.AddUserTraffic(user.UID, up, down)
 
 			if upCounter != nil {
-				upCounterList = append(upCounterList, upCounter)
+				report.upCounters = append(report.upCounters, upCounter)
 			}
 			if downCounter != nil {
-				downCounterList = append(downCounterList, downCounter)
+				report.downCounters = append(report.downCounters, downCounter)
 			}
 		} else {
 			delete(c.warnedUsers, user)
@@ -576,68 +852,240 @@ func (c *Controller) userInfoMonitor() (err error) {
 			c.logger.Print(err)
 		}
 	}
-	if len(userTraffic) > 0 {
-		c.logger.Printf("Reporting %d user(s) traffic to panel; example: UID=%d up=%d down=%d", len(userTraffic), userTraffic[0].UID, userTraffic[0].Upload, userTraffic[0].Download)
-		var err error // Define an empty error
-		if !c.config.DisableUploadTraffic {
-			err = c.apiClient.ReportUserTraffic(&userTraffic)
-		}
-		// If report traffic error, not clear the traffic
-		if err != nil {
-			c.logger.Print(err)
-		} else {
-			c.resetTraffic(&upCounterList, &downCounterList)
-		}
-	}
 
 	// Report Online info
+	var sharingResult []api.DetectResult
 	if onlineDevice, err := c.GetOnlineDevice(c.Tag); err != nil {
 		c.logger.Print(err)
-	} else if len(*onlineDevice) > 0 {
-		if err = c.apiClient.ReportNodeOnlineUsers(onlineDevice); err != nil {
-			c.logger.Print(err)
-		} else {
-			c.logger.Printf("Report %d online users", len(*onlineDevice))
+	} else {
+		if c.sharing != nil {
+			sharingResult = c.detectSharing(onlineDevice)
 		}
+		onlineUID := make(map[int]struct{})
+		for _, u := range *onlineDevice {
+			onlineUID[u.UID] = struct{}{}
+		}
+		c.metrics.SetOnline(len(onlineUID), len(*onlineDevice))
+		report.onlineDevice = *onlineDevice
 	}
 
+	c.recordInboundStats()
+
 	// Report Illegal user
 	if detectResult, err := c.GetDetectResult(c.Tag); err != nil {
 		c.logger.Print(err)
-	} else if len(*detectResult) > 0 {
-		if err = c.apiClient.ReportIllegal(detectResult); err != nil {
+	} else {
+		for _, r := range *detectResult {
+			c.events.Publish(&event.Event{
+				Type:    event.RuleHit,
+				UID:     r.UID,
+				Message: "Audit rule hit",
+				Data:    map[string]interface{}{"rule_id": r.RuleID},
+			})
+		}
+		*detectResult = append(*detectResult, sharingResult...)
+		if abuseResult, err := c.GetAbuseResult(c.Tag); err != nil {
 			c.logger.Print(err)
 		} else {
-			c.logger.Printf("Report %d illegal behaviors", len(*detectResult))
+			*detectResult = append(*detectResult, *abuseResult...)
 		}
+		report.illegal = *detectResult
+	}
+	return report
+}
 
+// reportTraffic reports the user traffic to the panel, and resets the counters once reported
+func (c *Controller) reportTraffic(userTraffic []api.UserTraffic, upCounterList, downCounterList []stats.Counter) error {
+	if len(userTraffic) == 0 {
+		return nil
+	}
+	c.logger.WithField("users", len(userTraffic)).Print("Reporting user traffic to panel")
+	if !c.config.DisableUploadTraffic {
+		// If report traffic error, not clear the traffic
+		if err := c.apiClient.ReportUserTraffic(&userTraffic); err != nil {
+			return err
+		}
 	}
+	c.resetTraffic(&upCounterList, &downCounterList)
+	var totalUp, totalDown int64
+	for _, t := range userTraffic {
+		totalUp += t.Upload
+		totalDown += t.Download
+	}
+	c.metrics.AddTraffic(totalUp, totalDown)
 	return nil
 }
 
+// detectSharing feeds the online IPs to the account sharing detector and
+// returns the findings to report to the panel
+func (c *Controller) detectSharing(onlineDevice *[]api.OnlineUser) []api.DetectResult {
+	var result []api.DetectResult
+	for _, f := range c.sharing.Feed(onlineDevice) {
+		c.logger.WithFields(log.Fields{
+			xraylog.FieldUID: f.UID,
+			"ips":            f.IPs,
+			"countries":      f.Countries,
+			"asns":           f.ASNs,
+		}).Warn("Account sharing detected")
+		if ruleID := c.sharing.RuleID(); ruleID != 0 {
+			result = append(result, api.DetectResult{UID: f.UID, RuleID: ruleID})
+		}
+	}
+	return result
+}
+
 func (c *Controller) buildNodeTag() string {
-	return fmt.Sprintf("%s_%s_%d", c.nodeInfo.NodeType, c.config.ListenIP, c.nodeInfo.Port)
+	return NodeTag(c.config, c.nodeInfo)
+}
+
+// NodeTag is the tag of the inbound and outbound of the node
+func NodeTag(config *Config, nodeInfo *api.NodeInfo) string {
+	return fmt.Sprintf("%s_%s_%d", nodeInfo.NodeType, config.ListenIP, nodeInfo.Port)
 }
 
 // func (c *Controller) logPrefix() string {
 // 	return fmt.Sprintf("[%s] %s(ID=%d)", c.clientInfo.APIHost, c.nodeInfo.NodeType, c.nodeInfo.NodeID)
 // }
 
-// Check Cert
-func (c *Controller) certMonitor() error {
-	if c.nodeInfo.EnableTLS && c.config.EnableREALITY == false {
-		switch c.config.CertConfig.CertMode {
-		case "dns", "http", "tls":
-			lego, err := mylego.New(c.config.CertConfig)
-			if err != nil {
-				c.logger.Print(err)
-			}
-			// Xray-core supports the OcspStapling certification hot renew
-			_, _, _, err = lego.RenewCert()
-			if err != nil {
-				c.logger.Print(err)
-			}
+// syncTask runs a panel sync task, one sync at a time. The first period is
+// skipped as Start has just synced the node.
+func (c *Controller) syncTask(execute func() error) func() error {
+	return func() error {
+		if time.Since(c.startAt) < time.Duration(c.config.UpdatePeriodic)*time.Second {
+			return nil
 		}
+		c.syncing.Lock()
+		defer c.syncing.Unlock()
+		return execute()
 	}
-	return nil
+}
+
+// timedTask records the duration of each run of a periodic task
+func (c *Controller) timedTask(tag string, execute func() error) func() error {
+	return func() error {
+		start := time.Now()
+		err := execute()
+		c.taskRuns.Store(tag, time.Now())
+		c.metrics.ObserveTask(tag, time.Since(start))
+		c.logger.WithFields(log.Fields{"task": tag, xraylog.FieldDuration: time.Since(start).String()}).Debug("Periodic task done")
+		return err
+	}
+}
+
+// panelFailed counts a failed panel sync, the first one of a row publishes the panel unreachable
+func (c *Controller) panelFailed(err error) {
+	c.status.Lock()
+	c.health.panelErrors++
+	c.health.panelError = err.Error()
+	failed := c.health.panelErrors
+	c.status.Unlock()
+	if failed == 1 {
+		c.events.Publish(&event.Event{
+			Type:    event.PanelUnreachable,
+			Message: "Panel unreachable",
+			Data:    map[string]interface{}{"error": err.Error()},
+		})
+	}
+}
+
+// panelSynced resets the failed panel syncs, and publishes the recovery if there were any
+func (c *Controller) panelSynced() {
+	c.status.Lock()
+	failed := c.health.panelErrors
+	c.health.panelErrors = 0
+	c.health.panelError = ""
+	c.health.lastSync = time.Now()
+	c.status.Unlock()
+	if failed > 0 {
+		c.events.Publish(&event.Event{
+			Type:    event.PanelRecovered,
+			Message: "Panel recovered",
+			Data:    map[string]interface{}{"failed_syncs": failed},
+		})
+	}
+}
+
+func (c *Controller) inboundFailed(err error) {
+	c.events.Publish(&event.Event{
+		Type:    event.InboundRebuildFailed,
+		Message: "Inbound rebuild failed",
+		Data:    map[string]interface{}{"error": err.Error()},
+	})
+}
+
+// certChanged is called by the certificate manager after each renewal attempt of the node certificate
+func (c *Controller) certChanged(status mylego.CertStatus, err error) {
+	c.metrics.SetCertFailures(status.Domain, status.Failures)
+	c.status.Lock()
+	c.health.certFailures = status.Failures
+	c.health.certError = status.LastError
+	c.status.Unlock()
+	if err != nil {
+		c.events.Publish(&event.Event{
+			Type:    event.CertFailing,
+			Message: "Certificate renewal failed",
+			Data:    map[string]interface{}{"domain": status.Domain, "error": err.Error(), "failures": status.Failures},
+		})
+		return
+	}
+	// Xray-core supports the OcspStapling certification hot renew
+	c.events.Publish(&event.Event{
+		Type:    event.CertRenewed,
+		Message: "Certificate renewed",
+		Data:    map[string]interface{}{"domain": status.Domain},
+	})
+	c.updateCertExpiry()
+}
+
+// userLogger returns the logger of the node with the fields of the user
+func (c *Controller) userLogger(user *api.UserInfo) *log.Entry {
+	return c.logger.WithFields(log.Fields{xraylog.FieldUID: user.UID, xraylog.FieldEmail: user.Email})
+}
+
+// recordInboundStats moves the connection and rejection counters of the inbound to the metrics
+func (c *Controller) recordInboundStats() {
+	if connections, deviceRejects, err := c.dispatcher.Limiter.GetInboundStats(c.Tag); err != nil {
+		c.logger.Debug(err)
+	} else {
+		c.metrics.AddConnections(connections)
+		c.metrics.AddRejections(metrics.RejectDeviceLimit, deviceRejects)
+	}
+	c.metrics.AddRejections(metrics.RejectAuditRule, c.dispatcher.RuleManager.GetRejectCount(c.Tag))
+	c.metrics.AddRejections(metrics.RejectAbuseGuard, c.dispatcher.AbuseGuard.GetRejectCount(c.Tag))
+}
+
+// updateCertExpiry records the expiry time of the certificates used by the inbound,
+// the health reports the one expiring first
+func (c *Controller) updateCertExpiry() {
+	if !c.nodeInfo.EnableTLS || c.config.EnableREALITY || c.config.CertConfig == nil {
+		return
+	}
+	files, err := obtainedCertFiles(c.config.CertConfig)
+	if err != nil {
+		c.logger.Print(err)
+		return
+	}
+	var notAfter time.Time
+	for _, f := range files {
+		leaf, err := mylego.ReadCertificate(f.CertFile)
+		if err != nil {
+			c.logger.WithError(err).WithField("cert_file", f.CertFile).Print("Read certificate failed")
+			continue
+		}
+		c.metrics.SetCertExpiry(certName(leaf), leaf.NotAfter)
+		if notAfter.IsZero() || leaf.NotAfter.Before(notAfter) {
+			notAfter = leaf.NotAfter
+		}
+	}
+	c.status.Lock()
+	c.health.certNotAfter = notAfter
+	c.status.Unlock()
+}
+
+// certName returns the name a certificate is reported under
+func certName(leaf *x509.Certificate) string {
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0]
+	}
+	return leaf.Subject.CommonName
 }
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sagernet/sing-shadowsocks/shadowaead_2022"
+	C "github.com/sagernet/sing/common"
+	"github.com/xtls/xray-core/infra/conf"
+
+	"Xray-P/api"
+	"Xray-P/common/mylego"
+)
+
+// NodeConfig is the Xray config the controller adds to the core for a node, in the
+// JSON format of xray run
+type NodeConfig struct {
+	Inbounds        []*conf.InboundDetourConfig  `json:"inbounds"`
+	Outbounds       []*conf.OutboundDetourConfig `json:"outbounds"`
+	CertAuthorities []*CertAuthority             `json:"certAuthorities,omitempty"`
+}
+
+// CertAuthority is the local CA issuing the certificate of a node in CertMode self.
+// Clients pin its fingerprint or trust its certificate instead of allowing insecure ones.
+type CertAuthority struct {
+	Tag         string `json:"tag"`
+	Domain      string `json:"domain"`
+	CAFile      string `json:"caFile"`
+	Fingerprint string `json:"sha256Fingerprint"` // As printed by openssl x509 -fingerprint -sha256
+}
+
+// BuildNodeConfig returns the inbounds and outbounds built for nodeInfo, with users
+// added to the clients of the inbound. No certificate is requested, the TLS settings
+// point to the ones already obtained.
+func BuildNodeConfig(config *Config, nodeInfo *api.NodeInfo, tag string, users []api.UserInfo) (*NodeConfig, error) {
+	nodeConfig := &NodeConfig{}
+	add := func(nodeInfo *api.NodeInfo, tag string, users []api.UserInfo) error {
+		inbound, err := buildInboundDetour(config, nodeInfo, tag, obtainedCertFiles)
+		if err != nil {
+			return err
+		}
+		if err := addClients(inbound, nodeInfo, tag, users); err != nil {
+			return err
+		}
+		outbound, err := buildOutboundDetour(config, nodeInfo, tag)
+		if err != nil {
+			return err
+		}
+		nodeConfig.Inbounds = append(nodeConfig.Inbounds, inbound)
+		nodeConfig.Outbounds = append(nodeConfig.Outbounds, outbound)
+		return nil
+	}
+	if nodeInfo.NodeType != "Shadowsocks-Plugin" {
+		if err := add(nodeInfo, tag, users); err != nil {
+			return nil, err
+		}
+		if config.CertConfig != nil && config.CertConfig.CertMode == "self" && nodeInfo.EnableTLS && !config.EnableREALITY {
+			ca, err := certAuthority(config.CertConfig, tag)
+			if err != nil {
+				return nil, err
+			}
+			nodeConfig.CertAuthorities = append(nodeConfig.CertAuthorities, ca)
+		}
+		return nodeConfig, nil
+	}
+
+	// The same inbounds as addInboundForSSPlugin
+	ssNodeInfo := *nodeInfo
+	ssNodeInfo.TransportProtocol = "tcp"
+	ssNodeInfo.EnableTLS = false
+	if err := add(&ssNodeInfo, tag, users); err != nil {
+		return nil, err
+	}
+	dokodemoNodeInfo := *nodeInfo
+	dokodemoNodeInfo.Port++
+	dokodemoNodeInfo.NodeType = "dokodemo-door"
+	return nodeConfig, add(&dokodemoNodeInfo, fmt.Sprintf("dokodemo-door_%s+1", tag), nil)
+}
+
+// addClients adds the users to the clients of the inbound settings, as addNewUser does
+func addClients(inbound *conf.InboundDetourConfig, nodeInfo *api.NodeInfo, tag string, users []api.UserInfo) error {
+	if len(users) == 0 || inbound.Protocol == "dokodemo-door" {
+		return nil
+	}
+	settings := make(map[string]interface{})
+	if err := json.Unmarshal(*inbound.Settings, &settings); err != nil {
+		return err
+	}
+	clients, _ := settings["clients"].([]interface{})
+	for _, user := range users {
+		client := map[string]interface{}{"email": userTag(tag, &user)}
+		switch inbound.Protocol {
+		case "vmess":
+			client["id"] = user.UUID
+		case "vless":
+			client["id"] = user.UUID
+			if nodeInfo.TransportProtocol == "tcp" && nodeInfo.VlessFlow != "" {
+				client["flow"] = nodeInfo.VlessFlow
+			}
+		case "trojan":
+			client["password"] = user.UUID
+		case "shadowsocks":
+			method := strings.ToLower(nodeInfo.CypherMethod)
+			if nodeInfo.NodeType == "Shadowsocks-Plugin" {
+				method = strings.ToLower(user.Method)
+				if _, ok := AEADMethod[cipherFromString(method)]; !ok && !C.Contains(shadowaead_2022.List, method) {
+					continue
+				}
+			}
+			client["password"] = user.Passwd
+			// Shadowsocks 2022 users share the method of the server
+			if !C.Contains(shadowaead_2022.List, method) {
+				client["method"] = method
+			}
+		}
+		clients = append(clients, client)
+	}
+	settings["clients"] = clients
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("marshal %s clients failed: %s", inbound.Protocol, err)
+	}
+	raw := json.RawMessage(data)
+	inbound.Settings = &raw
+	return nil
+}
+
+// obtainedCertFiles returns the certificate files of certConfig without requesting a certificate
+func obtainedCertFiles(certConfig *mylego.CertConfig) ([]*mylego.CertFileConfig, error) {
+	return certFiles(certConfig, func(certConfig *mylego.CertConfig) (string, string, error) {
+		lego, err := mylego.New(certConfig)
+		if err != nil {
+			return "", "", err
+		}
+		return lego.CertFile()
+	})
+}
+
+// certAuthority returns the local CA of certConfig in CertMode self
+func certAuthority(certConfig *mylego.CertConfig, tag string) (*CertAuthority, error) {
+	lego, err := mylego.New(certConfig)
+	if err != nil {
+		return nil, err
+	}
+	caFile, _ := lego.CAFile()
+	caCert, err := mylego.ReadCertificate(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read the local CA failed: %s", err)
+	}
+	sum := sha256.Sum256(caCert.Raw)
+	fingerprint := make([]string, len(sum))
+	for i, b := range sum {
+		fingerprint[i] = fmt.Sprintf("%02X", b)
+	}
+	return &CertAuthority{
+		Tag:         tag,
+		Domain:      certConfig.Domains()[0],
+		CAFile:      caFile,
+		Fingerprint: strings.Join(fingerprint, ":"),
+	}, nil
+}
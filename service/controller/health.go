@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	xraylog "Xray-P/common/log"
+)
+
+// nodeHealth is the part of the node state the health endpoints read
+type nodeHealth struct {
+	tag          string
+	panelErrors  int
+	panelError   string
+	lastSync     time.Time
+	certNotAfter time.Time
+	certFailures int
+	certError    string
+}
+
+// Health is the state of the node checked by the health endpoints
+type Health struct {
+	Tag          string    `json:"tag"`
+	NodeID       int       `json:"node_id"`
+	NodeType     string    `json:"node_type"`
+	PanelErrors  int       `json:"panel_errors"` // Consecutive failed panel syncs
+	PanelError   string    `json:"panel_error,omitempty"`
+	LastSync     time.Time `json:"last_sync"`
+	Inbound      bool      `json:"inbound"`                 // The inbound handler of the node is live
+	CertNotAfter time.Time `json:"cert_not_after,omitzero"` // Zero when the node has no certificate or it could not be read
+	CertFailures int       `json:"cert_failures,omitempty"` // Consecutive failed certificate renewals
+	CertError    string    `json:"cert_error,omitempty"`
+	StalledTasks []string  `json:"stalled_tasks,omitempty"`
+}
+
+// setTag sets the inbound tag of the node and everything that refers to it
+func (c *Controller) setTag(tag string) {
+	c.Tag = tag
+	c.logger = c.logger.WithField(xraylog.FieldNodeTag, tag)
+	c.events.SetTag(tag)
+	c.status.Lock()
+	c.health.tag = tag
+	c.status.Unlock()
+}
+
+// Health returns the state of the node, it does not wait for a running sync
+func (c *Controller) Health() Health {
+	c.status.Lock()
+	h := c.health
+	c.status.Unlock()
+	clientInfo := c.ClientInfo()
+	_, err := c.ibm.GetHandler(context.Background(), h.tag)
+	return Health{
+		Tag:          h.tag,
+		NodeID:       clientInfo.NodeID,
+		NodeType:     clientInfo.NodeType,
+		PanelErrors:  h.panelErrors,
+		PanelError:   h.panelError,
+		LastSync:     h.lastSync,
+		Inbound:      err == nil,
+		CertNotAfter: h.certNotAfter,
+		CertFailures: h.certFailures,
+		CertError:    h.certError,
+		StalledTasks: c.Stalled(),
+	}
+}
@@ -21,6 +21,25 @@ import (
 
 // InboundBuilder build Inbound config for different protocol
 func InboundBuilder(config *Config, nodeInfo *api.NodeInfo, tag string) (*core.InboundHandlerConfig, error) {
+	inboundDetourConfig, err := buildInboundDetour(config, nodeInfo, tag, getCertFiles)
+	if err != nil {
+		return nil, err
+	}
+	return inboundDetourConfig.Build()
+}
+
+// buildInbound builds the inbound of the node, the ACME certificates are registered to the
+// certificate manager on behalf of the node
+func (c *Controller) buildInbound(nodeInfo *api.NodeInfo, tag string) (*core.InboundHandlerConfig, error) {
+	inboundDetourConfig, err := buildInboundDetour(c.config, nodeInfo, tag, c.certFiles)
+	if err != nil {
+		return nil, err
+	}
+	return inboundDetourConfig.Build()
+}
+
+// buildInboundDetour builds the JSON config of the inbound, certPaths returns the certificate files of the TLS settings
+func buildInboundDetour(config *Config, nodeInfo *api.NodeInfo, tag string, certPaths func(*mylego.CertConfig) ([]*mylego.CertFileConfig, error)) (*conf.InboundDetourConfig, error) {
 	inboundDetourConfig := &conf.InboundDetourConfig{}
 	// Build Listen IP address
 	if nodeInfo.NodeType == "Shadowsocks-Plugin" {
@@ -231,14 +250,17 @@ func InboundBuilder(config *Config, nodeInfo *api.NodeInfo, tag string) (*core.I
 
 	if !isREALITY && nodeInfo.EnableTLS && config.CertConfig.CertMode != "none" {
 		streamSetting.Security = "tls"
-		certFile, keyFile, err := getCertFile(config.CertConfig)
+		certFiles, err := certPaths(config.CertConfig)
 		if err != nil {
 			return nil, err
 		}
 		tlsSettings := &conf.TLSConfig{
 			RejectUnknownSNI: config.CertConfig.RejectUnknownSni,
 		}
-		tlsSettings.Certs = append(tlsSettings.Certs, &conf.TLSCertConfig{CertFile: certFile, KeyFile: keyFile, OcspStapling: 3600})
+		// Xray picks the certificate matching the SNI, the first one without a match
+		for _, f := range certFiles {
+			tlsSettings.Certs = append(tlsSettings.Certs, &conf.TLSCertConfig{CertFile: f.CertFile, KeyFile: f.KeyFile, OcspStapling: 3600})
+		}
 		streamSetting.TLSSettings = tlsSettings
 	}
 
@@ -251,39 +273,64 @@ func InboundBuilder(config *Config, nodeInfo *api.NodeInfo, tag string) (*core.I
 	}
 	inboundDetourConfig.StreamSetting = streamSetting
 
-	return inboundDetourConfig.Build()
+	return applyInboundOverride(config, inboundDetourConfig)
+}
+
+// certFiles returns the certificate files of the node
+func (c *Controller) certFiles(certConfig *mylego.CertConfig) ([]*mylego.CertFileConfig, error) {
+	return certFiles(certConfig, func(certConfig *mylego.CertConfig) (string, string, error) {
+		return mylego.Acquire(certConfig, c, c.certChanged)
+	})
+}
+
+func getCertFiles(certConfig *mylego.CertConfig) ([]*mylego.CertFileConfig, error) {
+	return certFiles(certConfig, obtainCertFile)
+}
+
+// obtainCertFile returns the ACME or self issued certificate of certConfig, it is obtained
+// if it does not exist yet
+func obtainCertFile(certConfig *mylego.CertConfig) (string, string, error) {
+	lego, err := mylego.New(certConfig)
+	if err != nil {
+		return "", "", err
+	}
+	switch certConfig.CertMode {
+	case "dns":
+		return lego.DNSCert()
+	case "self":
+		return lego.SelfCert()
+	}
+	return lego.HTTPCert()
 }
 
-func getCertFile(certConfig *mylego.CertConfig) (certFile string, keyFile string, err error) {
+// certFiles returns the certificate of the mode, given by acme in the ACME modes and
+// CertMode self, followed by the certificates of Certificates
+func certFiles(certConfig *mylego.CertConfig, acme func(*mylego.CertConfig) (string, string, error)) ([]*mylego.CertFileConfig, error) {
+	var files []*mylego.CertFileConfig
 	switch certConfig.CertMode {
 	case "file":
-		if certConfig.CertFile == "" || certConfig.KeyFile == "" {
-			return "", "", fmt.Errorf("cert file path or key file path not exist")
-		}
-		return certConfig.CertFile, certConfig.KeyFile, nil
-	case "dns":
-		lego, err := mylego.New(certConfig)
-		if err != nil {
-			return "", "", err
+		if certConfig.CertFile != "" || certConfig.KeyFile != "" {
+			files = append(files, &mylego.CertFileConfig{CertFile: certConfig.CertFile, KeyFile: certConfig.KeyFile})
 		}
-		certPath, keyPath, err := lego.DNSCert()
+	case "dns", "http", "tls", "self":
+		certFile, keyFile, err := acme(certConfig)
 		if err != nil {
-			return "", "", err
-		}
-		return certPath, keyPath, err
-	case "http", "tls":
-		lego, err := mylego.New(certConfig)
-		if err != nil {
-			return "", "", err
-		}
-		certPath, keyPath, err := lego.HTTPCert()
-		if err != nil {
-			return "", "", err
+			return nil, err
 		}
-		return certPath, keyPath, err
+		files = append(files, &mylego.CertFileConfig{CertFile: certFile, KeyFile: keyFile})
 	default:
-		return "", "", fmt.Errorf("unsupported certmode: %s", certConfig.CertMode)
+		return nil, fmt.Errorf("unsupported certmode: %s", certConfig.CertMode)
+	}
+	files = append(files, certConfig.Certificates...)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("cert file path or key file path not exist")
+	}
+	for _, f := range files {
+		if f.CertFile == "" || f.KeyFile == "" {
+			return nil, fmt.Errorf("cert file path or key file path not exist")
+		}
 	}
+	return files, nil
 }
 
 func buildVlessFallbacks(fallbackConfigs []*FallBackConfig) ([]*conf.VLessInboundFallback, error) {
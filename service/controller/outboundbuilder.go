@@ -12,6 +12,15 @@ import (
 
 // OutboundBuilder build freedom outbound config for addOutbound
 func OutboundBuilder(config *Config, nodeInfo *api.NodeInfo, tag string) (*core.OutboundHandlerConfig, error) {
+	outboundDetourConfig, err := buildOutboundDetour(config, nodeInfo, tag)
+	if err != nil {
+		return nil, err
+	}
+	return outboundDetourConfig.Build()
+}
+
+// buildOutboundDetour builds the JSON config of the outbound
+func buildOutboundDetour(config *Config, nodeInfo *api.NodeInfo, tag string) (*conf.OutboundDetourConfig, error) {
 	outboundDetourConfig := &conf.OutboundDetourConfig{}
 	outboundDetourConfig.Protocol = "freedom"
 	outboundDetourConfig.Tag = tag
@@ -43,5 +52,5 @@ func OutboundBuilder(config *Config, nodeInfo *api.NodeInfo, tag string) (*core.
 		return nil, fmt.Errorf("marshal proxy %s config failed: %s", nodeInfo.NodeType, err)
 	}
 	outboundDetourConfig.Settings = &setting
-	return outboundDetourConfig.Build()
+	return applyOutboundOverride(config, outboundDetourConfig)
 }
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// Fields of the generated configs set from the panel or used by the controller, an
+// override must not touch them
+var (
+	inboundPanelFields  = []string{"tag", "protocol", "port", "settings.clients", "streamSettings.network", "streamSettings.security"}
+	outboundPanelFields = []string{"tag", "protocol"}
+)
+
+// parseOverride returns the JSON object of an override, given as a YAML mapping or
+// as a string of inline Xray JSON. It returns nil when there is no override.
+func parseOverride(name string, override interface{}) (map[string]interface{}, error) {
+	var data []byte
+	switch v := override.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return nil, nil
+		}
+		data = []byte(v)
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("%s is not a JSON object: %s", name, err)
+		}
+	}
+	var patch map[string]interface{}
+	if err := decodeJSON(data, &patch); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON object: %s", name, err)
+	}
+	return patch, nil
+}
+
+// validateOverride checks the override, the error names the panel fields it conflicts with
+func validateOverride(name string, override interface{}, panelFields []string) error {
+	patch, err := parseOverride(name, override)
+	if err != nil || patch == nil {
+		return err
+	}
+	var conflicts []string
+	for _, field := range panelFields {
+		if patchTouches(patch, strings.Split(field, ".")) {
+			conflicts = append(conflicts, field)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%s conflicts with the fields set from the panel: %s", name, strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// patchTouches returns whether the merge patch sets, removes or replaces the field at path
+func patchTouches(patch map[string]interface{}, path []string) bool {
+	value, ok := lookupFold(patch, path[0])
+	if !ok {
+		return false
+	}
+	if len(path) == 1 {
+		return true
+	}
+	object, ok := value.(map[string]interface{})
+	// Anything but an object replaces the whole subtree
+	return !ok || patchTouches(object, path[1:])
+}
+
+// applyInboundOverride merges the InboundOverride of config into the inbound
+func applyInboundOverride(config *Config, inbound *conf.InboundDetourConfig) (*conf.InboundDetourConfig, error) {
+	patched := &conf.InboundDetourConfig{}
+	if ok, err := applyOverride("InboundOverride", config.InboundOverride, inboundPanelFields, inbound, patched); err != nil || !ok {
+		return inbound, err
+	}
+	return patched, nil
+}
+
+// applyOutboundOverride merges the OutboundOverride of config into the outbound
+func applyOutboundOverride(config *Config, outbound *conf.OutboundDetourConfig) (*conf.OutboundDetourConfig, error) {
+	patched := &conf.OutboundDetourConfig{}
+	if ok, err := applyOverride("OutboundOverride", config.OutboundOverride, outboundPanelFields, outbound, patched); err != nil || !ok {
+		return outbound, err
+	}
+	return patched, nil
+}
+
+// applyOverride merges the override, a JSON merge patch (RFC 7396), into the JSON of
+// detour and decodes the result into patched. It returns false when there is no override.
+func applyOverride(name string, override interface{}, panelFields []string, detour interface{}, patched interface{}) (bool, error) {
+	if err := validateOverride(name, override, panelFields); err != nil {
+		return false, err
+	}
+	patch, _ := parseOverride(name, override)
+	if patch == nil {
+		return false, nil
+	}
+	data, err := json.Marshal(detour)
+	if err != nil {
+		return false, err
+	}
+	var target interface{}
+	if err := decodeJSON(data, &target); err != nil {
+		return false, err
+	}
+	if data, err = json.Marshal(mergePatch(target, patch)); err != nil {
+		return false, err
+	}
+	// Report the typos, Xray would ignore them
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(patched); err != nil {
+		return false, fmt.Errorf("invalid %s: %s", name, err)
+	}
+	return true, nil
+}
+
+// mergePatch applies the JSON merge patch to target. Keys are matched regardless of
+// case like Xray does, the config file loader lowercases the keys of YAML mappings.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if existing, ok := findKeyFold(targetObject, key); ok {
+			key = existing
+		}
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+func findKeyFold(object map[string]interface{}, key string) (string, bool) {
+	if _, ok := object[key]; ok {
+		return key, true
+	}
+	for k := range object {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func lookupFold(object map[string]interface{}, key string) (interface{}, bool) {
+	if k, ok := findKeyFold(object, key); ok {
+		return object[k], true
+	}
+	return nil, false
+}
+
+// decodeJSON keeps the numbers as they are written
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"Xray-P/api"
+	"Xray-P/common/mylego"
+)
+
+func TestInboundOverride(t *testing.T) {
+	nodeInfo := &api.NodeInfo{NodeType: "V2ray", Port: 1145, TransportProtocol: "ws", Host: "test.com", Path: "/ws"}
+	config := &Config{
+		CertConfig: &mylego.CertConfig{CertMode: "none"},
+		// Keys of YAML mappings come lowercased from the config file
+		InboundOverride: map[string]interface{}{
+			"streamsettings": map[string]interface{}{
+				"wssettings": map[string]interface{}{"heartbeatperiod": 30},
+				"sockopt":    map[string]interface{}{"tcpKeepAliveIdle": 100},
+			},
+			"sniffing": nil,
+		},
+	}
+	inbound, err := buildInboundDetour(config, nodeInfo, "test_tag", getCertFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := inbound.StreamSetting
+	if stream.WSSettings.HeartbeatPeriod != 30 || stream.WSSettings.Path != "/ws" || stream.WSSettings.Host != "test.com" {
+		t.Errorf("unexpected ws settings: %+v", stream.WSSettings)
+	}
+	if stream.SocketSettings == nil || stream.SocketSettings.TCPKeepAliveIdle != 100 {
+		t.Errorf("unexpected sockopt: %+v", stream.SocketSettings)
+	}
+	if inbound.SniffingConfig != nil || inbound.Tag != "test_tag" || inbound.PortList.Range[0].From != 1145 {
+		t.Errorf("unexpected inbound: %+v", inbound)
+	}
+	if _, err := inbound.Build(); err != nil {
+		t.Error(err)
+	}
+
+	// Inline Xray JSON
+	config.InboundOverride = `{"settings": {"decryption": "none"}, "streamSettings": {"wsSettings": {"acceptProxyProtocol": true}}}`
+	if inbound, err = buildInboundDetour(config, nodeInfo, "test_tag", getCertFiles); err != nil {
+		t.Fatal(err)
+	}
+	if !inbound.StreamSetting.WSSettings.AcceptProxyProtocol {
+		t.Errorf("inline override not applied: %+v", inbound.StreamSetting.WSSettings)
+	}
+
+	for override, conflict := range map[string]string{
+		`{"port": 443}`:                                   "port",
+		`{"Tag": "other"}`:                                "tag",
+		`{"settings": null}`:                              "settings.clients",
+		`{"settings": {"clients": []}}`:                   "settings.clients",
+		`{"streamSettings": {"network": "grpc"}}`:         "streamSettings.network",
+		`{"streamSettings": "none"}`:                      "streamSettings.network, streamSettings.security",
+		`["not", "an", "object"]`:                         "not a JSON object",
+		`{"streamSettings": {"security": "tls"}, "x": 1}`: "streamSettings.security",
+	} {
+		config.InboundOverride = override
+		_, err := buildInboundDetour(config, nodeInfo, "test_tag", getCertFiles)
+		if err == nil || !strings.Contains(err.Error(), conflict) {
+			t.Errorf("override %s: got %v, want a conflict with %s", override, err, conflict)
+		}
+		if err := config.Validate(); err == nil || !strings.Contains(err.Error(), conflict) {
+			t.Errorf("validate override %s: got %v", override, err)
+		}
+	}
+}
+
+func TestOutboundOverride(t *testing.T) {
+	nodeInfo := &api.NodeInfo{NodeType: "V2ray", Port: 1145}
+	config := &Config{OutboundOverride: `{"sendThrough": "127.0.0.1", "settings": {"domainStrategy": "UseIPv4"}}`}
+	outbound, err := buildOutboundDetour(config, nodeInfo, "test_tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := make(map[string]interface{})
+	json.Unmarshal(*outbound.Settings, &settings)
+	if *outbound.SendThrough != "127.0.0.1" || settings["domainStrategy"] != "UseIPv4" || outbound.Protocol != "freedom" {
+		t.Errorf("unexpected outbound: %+v %v", outbound, settings)
+	}
+	if _, err := outbound.Build(); err != nil {
+		t.Error(err)
+	}
+
+	config.OutboundOverride = `{"sendThrough": "127.0.0.1", "mux": {"enabled": true, "concurency": 8}}`
+	if _, err := buildOutboundDetour(config, nodeInfo, "test_tag"); err == nil || !strings.Contains(err.Error(), "concurency") {
+		t.Errorf("typo accepted: %v", err)
+	}
+	config.OutboundOverride = map[string]interface{}{"protocol": "blackhole"}
+	if _, err := buildOutboundDetour(config, nodeInfo, "test_tag"); err == nil {
+		t.Error("protocol override accepted")
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// Cases of RFC 7396 appendix A
+	for _, c := range []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		// Keys are matched regardless of case
+		{`{"streamSettings":{"network":"ws"}}`, `{"streamsettings":{"sockopt":{}}}`, `{"streamSettings":{"network":"ws","sockopt":{}}}`},
+	} {
+		var target, patch, want interface{}
+		decodeJSON([]byte(c.target), &target)
+		decodeJSON([]byte(c.patch), &patch)
+		decodeJSON([]byte(c.want), &want)
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("merge %s into %s: got %v, want %s", c.patch, c.target, got, c.want)
+		}
+	}
+}
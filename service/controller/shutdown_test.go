@@ -0,0 +1,205 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	stdnet "net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/app/dispatcher"
+	"github.com/xtls/xray-core/app/proxyman"
+	"github.com/xtls/xray-core/app/stats"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/core"
+	featstats "github.com/xtls/xray-core/features/stats"
+
+	"Xray-P/api"
+	"Xray-P/api/sspanel"
+	"Xray-P/common/mylego"
+	. "Xray-P/service/controller"
+)
+
+func TestDrainAndFlushTraffic(t *testing.T) {
+	reported := make(chan []sspanel.UserTraffic, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/mod_mu/nodes/43/info":
+			nodeInfo, _ := json.Marshal(sspanel.NodeInfoResponse{
+				RawServerString: "127.0.0.1;12347;0;none;tcp;server=127.0.0.1",
+				CustomConfig:    json.RawMessage(`{"offset_port_node": "12347"}`),
+				Type:            "1",
+			})
+			json.NewEncoder(w).Encode(sspanel.Response{Ret: 1, Data: json.RawMessage(nodeInfo)})
+		case "/mod_mu/users":
+			users, _ := json.Marshal([]sspanel.UserResponse{
+				{ID: 7, UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", Port: 12347},
+			})
+			json.NewEncoder(w).Encode(sspanel.Response{Ret: 1, Data: json.RawMessage(users)})
+		case "/mod_mu/users/traffic":
+			data := struct {
+				Data []sspanel.UserTraffic `json:"data"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&data)
+			reported <- data.Data
+			json.NewEncoder(w).Encode(struct {
+				Ret int `json:"ret"`
+			}{Ret: 1})
+		default:
+			json.NewEncoder(w).Encode(struct {
+				Ret int `json:"ret"`
+			}{Ret: 1})
+		}
+	}))
+	defer ts.Close()
+
+	server, err := core.New(&core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&stats.Config{}),
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(server, sspanel.New(&api.Config{APIHost: ts.URL, Key: "123", NodeID: 43, NodeType: "V2ray"}), &Config{
+		UpdatePeriodic: 60,
+		CertConfig:     &mylego.CertConfig{CertMode: "none"},
+		ListenIP:       "127.0.0.1",
+	})
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if stalled := c.Stalled(); len(stalled) != 0 {
+		t.Errorf("tasks stalled right after start: %v", stalled)
+	}
+	if health := c.Health(); !health.Inbound || health.PanelErrors != 0 || health.LastSync.IsZero() || health.NodeID != 43 {
+		t.Errorf("unexpected health after start: %+v", health)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := stdnet.DialTimeout("tcp", "127.0.0.1:12347", time.Second); err == nil {
+		conn.Close()
+		t.Error("the inbound still accepts connections after the drain")
+	}
+	if health := c.Health(); health.Inbound {
+		t.Error("the inbound is still live after the drain")
+	}
+
+	stm := server.GetFeature(featstats.ManagerType()).(featstats.Manager)
+	counter, err := featstats.GetOrRegisterCounter(stm, "user>>>"+c.CurrentTag()+"||7>>>traffic>>>downlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter.Add(4096)
+	if err := c.FlushTraffic(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case traffic := <-reported:
+		if len(traffic) != 1 || traffic[0].UID != 7 || traffic[0].Download != 4096 {
+			t.Errorf("unexpected traffic report: %+v", traffic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("traffic not reported")
+	}
+	if counter.Value() != 0 {
+		t.Errorf("counter not reset after the report: %d", counter.Value())
+	}
+}
+
+func TestSyncDoesNotBlockAdmin(t *testing.T) {
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	var started atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/mod_mu/nodes/44/info":
+			// The panel hangs on the first sync after the start
+			if started.Swap(true) {
+				blocked <- struct{}{}
+				<-release
+			}
+			nodeInfo, _ := json.Marshal(sspanel.NodeInfoResponse{
+				RawServerString: "127.0.0.1;12348;0;none;tcp;server=127.0.0.1",
+				CustomConfig:    json.RawMessage(`{"offset_port_node": "12348"}`),
+				Type:            "1",
+			})
+			json.NewEncoder(w).Encode(sspanel.Response{Ret: 1, Data: json.RawMessage(nodeInfo)})
+		case "/mod_mu/users":
+			users, _ := json.Marshal([]sspanel.UserResponse{
+				{ID: 7, UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", Port: 12348},
+			})
+			json.NewEncoder(w).Encode(sspanel.Response{Ret: 1, Data: json.RawMessage(users)})
+		default:
+			json.NewEncoder(w).Encode(struct {
+				Ret int `json:"ret"`
+			}{Ret: 1})
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	server, err := core.New(&core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+			serial.ToTypedMessage(&stats.Config{}),
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(server, sspanel.New(&api.Config{APIHost: ts.URL, Key: "123", NodeID: 44, NodeType: "V2ray"}), &Config{
+		UpdatePeriodic: 60,
+		CertConfig:     &mylego.CertConfig{CertMode: "none"},
+		ListenIP:       "127.0.0.1",
+	})
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	go c.Sync()
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync did not reach the panel")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if users, err := c.Users(); err != nil || len(users) != 1 {
+			t.Errorf("got users %+v, %v", users, err)
+		}
+		if _, err := c.Kick(7); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the admin API is blocked by the panel sync")
+	}
+}
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	xtls "github.com/xtls/xray-core/transport/internet/tls"
+	"github.com/xtls/xray-core/xrayr/certstore"
+
+	"Xray-P/api"
+	"Xray-P/common/mylego"
+)
+
+func TestSNICertificates(t *testing.T) {
+	notAfter := time.Now().Add(30 * 24 * time.Hour)
+	aCert, aKey := writeCertFor(t, notAfter, "a.test.com")
+	bCert, bKey := writeCertFor(t, notAfter, "*.b.test.com", "b.test.com")
+	nodeInfo := &api.NodeInfo{NodeType: "Trojan", Port: 1145, TransportProtocol: "tcp", EnableTLS: true}
+	config := &Config{CertConfig: &mylego.CertConfig{
+		CertMode:     "file",
+		CertFile:     aCert,
+		KeyFile:      aKey,
+		Certificates: []*mylego.CertFileConfig{{CertFile: bCert, KeyFile: bKey}},
+	}}
+	inbound, err := buildInboundDetour(config, nodeInfo, "test_tag", getCertFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certs := inbound.StreamSetting.TLSSettings.Certs; len(certs) != 2 || certs[0].CertFile != aCert || certs[1].CertFile != bCert {
+		t.Fatalf("unexpected certificates: %+v", certs)
+	}
+	message, err := inbound.StreamSetting.TLSSettings.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := message.(*xtls.Config).GetTLSConfig()
+	for sni, want := range map[string]string{"a.test.com": "a.test.com", "x.b.test.com": "*.b.test.com", "other.com": "a.test.com"} {
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Leaf.DNSNames[0] != want {
+			t.Errorf("SNI %s got the certificate of %s, want %s", sni, cert.Leaf.DNSNames[0], want)
+		}
+	}
+}
+
+func TestReloadCert(t *testing.T) {
+	// Valid from yesterday to tomorrow
+	certFile, keyFile := writeCertFor(t, time.Now().Add(24*time.Hour), "old.test.com")
+	nodeInfo := &api.NodeInfo{NodeType: "Trojan", Port: 1145, TransportProtocol: "tcp", EnableTLS: true}
+	config := &Config{CertConfig: &mylego.CertConfig{CertMode: "file", CertFile: certFile, KeyFile: keyFile}}
+	inbound, err := buildInboundDetour(config, nodeInfo, "test_tag", getCertFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := inbound.StreamSetting.TLSSettings.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := message.(*xtls.Config).GetTLSConfig()
+	served := func() string {
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "old.test.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Leaf.DNSNames[0]
+	}
+
+	reloaded := make(chan error, 1)
+	watcher, err := newCertWatcher(userCertFiles(config.CertConfig), func(pair *mylego.CertFileConfig) {
+		_, err := certstore.Reload(pair.CertFile, pair.KeyFile)
+		reloaded <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	replace := func(certPEM, keyPEM []byte) error {
+		if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-reloaded:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("the change is not reloaded")
+			return nil
+		}
+	}
+
+	newCert, newKey := writeCertFor(t, time.Now().Add(24*time.Hour), "new.test.com")
+	certPEM, _ := os.ReadFile(newCert)
+	keyPEM, _ := os.ReadFile(newKey)
+	if err := replace(certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if name := served(); name != "new.test.com" {
+		t.Errorf("the inbound serves %s after the reload", name)
+	}
+
+	// A broken pair is rejected, the handshakes get the loaded one
+	if err := replace([]byte("broken"), keyPEM); err == nil {
+		t.Error("broken certificate accepted")
+	}
+	if name := served(); name != "new.test.com" {
+		t.Errorf("the inbound serves %s after a rejected pair", name)
+	}
+}
+
+func TestWatchCertSymlinks(t *testing.T) {
+	// A Kubernetes secret volume, the files link to ..data which links to the current version
+	dir := t.TempDir()
+	version := func(name string) {
+		certFile, keyFile := writeCertFor(t, time.Now().Add(24*time.Hour), name)
+		if err := os.Mkdir(filepath.Join(dir, ".."+name), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		for from, to := range map[string]string{certFile: "tls.crt", keyFile: "tls.key"} {
+			data, _ := os.ReadFile(from)
+			if err := os.WriteFile(filepath.Join(dir, ".."+name, to), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink(".."+name, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version("old.test.com")
+	pair := &mylego.CertFileConfig{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+	for _, f := range []string{"tls.crt", "tls.key"} {
+		if err := os.Symlink(filepath.Join("..data", f), filepath.Join(dir, f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed := make(chan *mylego.CertFileConfig, 1)
+	watcher, err := newCertWatcher([]*mylego.CertFileConfig{pair}, func(pair *mylego.CertFileConfig) { changed <- pair })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	// Another file of the directory does not change the pair
+	if err := os.WriteFile(filepath.Join(dir, "ca.crt"), []byte("ca"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+		t.Error("unchanged pair reloaded")
+	case <-time.After(2 * certChangeDelay):
+	}
+
+	version("new.test.com")
+	select {
+	case p := <-changed:
+		if p != pair {
+			t.Errorf("unexpected pair %v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the symlink swap is not reloaded")
+	}
+}
@@ -163,7 +163,12 @@ func cipherFromString(c string) shadowsocks.CipherType {
 }
 
 func (c *Controller) buildUserTag(user *api.UserInfo) string {
-	return fmt.Sprintf("%s|%s|%d", c.Tag, user.Email, user.UID)
+	return userTag(c.Tag, user)
+}
+
+// userTag is the email of the user in the inbound of tag: InboundTag|email|uid
+func userTag(tag string, user *api.UserInfo) string {
+	return fmt.Sprintf("%s|%s|%d", tag, user.Email, user.UID)
 }
 
 func (c *Controller) checkShadowsocksPassword(password string) (string, error) {
@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"Xray-P/common/mylego"
+)
+
+var clientVerRegexp = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// Validate checks the config without contacting the panel or the CA, the error
+// holds every problem found
+func (c *Config) Validate() error {
+	var errs []error
+	for _, ip := range [][2]string{{"ListenIP", c.ListenIP}, {"SendIP", c.SendIP}} {
+		if ip[1] != "" && net.ParseIP(ip[1]) == nil {
+			errs = append(errs, fmt.Errorf("%s %q is not an IP address", ip[0], ip[1]))
+		}
+	}
+	switch c.DNSType {
+	case "", "AsIs", "UseIP", "UseIPv4", "UseIPv6":
+	default:
+		errs = append(errs, fmt.Errorf("unsupported DNSType: %s", c.DNSType))
+	}
+	if c.CertConfig != nil {
+		errs = append(errs, validateCert(c.CertConfig)...)
+	}
+	if c.EnableREALITY && !c.DisableLocalREALITYConfig {
+		if c.REALITYConfigs == nil {
+			errs = append(errs, fmt.Errorf("EnableREALITY is set without REALITYConfigs"))
+		} else {
+			errs = append(errs, validateREALITY(c.REALITYConfigs)...)
+		}
+	}
+	if c.EnableFallback {
+		if len(c.FallBackConfigs) == 0 {
+			errs = append(errs, fmt.Errorf("EnableFallback is set without FallBackConfigs"))
+		}
+		for i, fallback := range c.FallBackConfigs {
+			if err := validateDest(fallback.Dest); err != nil {
+				errs = append(errs, fmt.Errorf("FallBackConfigs %d: %s", i, err))
+			}
+			if fallback.ProxyProtocolVer > 2 {
+				errs = append(errs, fmt.Errorf("FallBackConfigs %d: unsupported ProxyProtocolVer %d", i, fallback.ProxyProtocolVer))
+			}
+		}
+	}
+	if s := c.AutoSpeedLimitConfig; s != nil && s.Limit > 0 && (s.LimitSpeed <= 0 || s.LimitDuration <= 0) {
+		errs = append(errs, fmt.Errorf("AutoSpeedLimitConfig needs LimitSpeed and LimitDuration with Limit"))
+	}
+	if g := c.GlobalDeviceLimitConfig; g != nil && g.Enable && g.RedisAddr == "" {
+		errs = append(errs, fmt.Errorf("GlobalDeviceLimitConfig is enabled without RedisAddr"))
+	}
+	errs = append(errs, validateOverride("InboundOverride", c.InboundOverride, inboundPanelFields))
+	errs = append(errs, validateOverride("OutboundOverride", c.OutboundOverride, outboundPanelFields))
+	return errors.Join(errs...)
+}
+
+func validateCert(certConfig *mylego.CertConfig) []error {
+	var errs []error
+	switch certConfig.CertMode {
+	case "", "none":
+		return nil
+	case "file":
+		if certConfig.CertFile == "" && certConfig.KeyFile == "" && len(certConfig.Certificates) == 0 {
+			return []error{fmt.Errorf("CertMode file needs CertFile and KeyFile or Certificates")}
+		}
+		if certConfig.CertFile != "" || certConfig.KeyFile != "" {
+			errs = append(errs, validateCertFile(certConfig.CertFile, certConfig.KeyFile)...)
+		}
+	case "dns", "http", "tls", "self":
+		if len(certConfig.Domains()) == 0 {
+			errs = append(errs, fmt.Errorf("CertMode %s needs CertDomain or CertDomains", certConfig.CertMode))
+		}
+		for _, domain := range certConfig.Domains() {
+			if strings.HasPrefix(domain, "*.") && certConfig.CertMode != "dns" && certConfig.CertMode != "self" {
+				errs = append(errs, fmt.Errorf("wildcard domain %s needs CertMode dns or self", domain))
+			} else if strings.Contains(strings.TrimPrefix(domain, "*."), "*") {
+				errs = append(errs, fmt.Errorf("invalid wildcard domain %s", domain))
+			}
+		}
+		if certConfig.CertMode == "dns" && certConfig.Provider == "" {
+			errs = append(errs, fmt.Errorf("CertMode dns needs a DNS Provider"))
+		}
+		if certConfig.CertMode != "self" {
+			errs = append(errs, validateACME(certConfig)...)
+		} else if _, err := certConfig.CertKeyType(); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		return []error{fmt.Errorf("unsupported CertMode: %s", certConfig.CertMode)}
+	}
+	for _, f := range certConfig.Certificates {
+		errs = append(errs, validateCertFile(f.CertFile, f.KeyFile)...)
+	}
+	return errs
+}
+
+// validateACME checks the options of the ACME CA
+func validateACME(certConfig *mylego.CertConfig) []error {
+	var errs []error
+	if _, err := certConfig.CADirURL(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := certConfig.CertKeyType(); err != nil {
+		errs = append(errs, err)
+	}
+	if (certConfig.EABKeyID == "") != (certConfig.EABHMACKey == "") {
+		errs = append(errs, fmt.Errorf("External Account Binding needs both EABKeyID and EABHMACKey"))
+	} else if _, err := base64.RawURLEncoding.DecodeString(certConfig.EABHMACKey); err != nil {
+		errs = append(errs, fmt.Errorf("EABHMACKey is not base64url encoded"))
+	}
+	if certConfig.CABundle != "" {
+		if data, err := os.ReadFile(certConfig.CABundle); err != nil {
+			errs = append(errs, fmt.Errorf("read CABundle failed: %s", err))
+		} else if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			errs = append(errs, fmt.Errorf("no certificate found in CABundle %s", certConfig.CABundle))
+		}
+	}
+	if certConfig.ChallengeListen != "" {
+		if _, _, err := net.SplitHostPort(certConfig.ChallengeListen); err != nil {
+			errs = append(errs, fmt.Errorf("invalid ChallengeListen: %s", err))
+		}
+	}
+	return errs
+}
+
+// validateCertFile checks the pair loads and the certificate did not expire
+func validateCertFile(certFile, keyFile string) []error {
+	if certFile == "" || keyFile == "" {
+		return []error{fmt.Errorf("certificate needs CertFile and KeyFile")}
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return []error{fmt.Errorf("load certificate %s failed: %s", certFile, err)}
+	}
+	if cert.Leaf != nil && time.Now().After(cert.Leaf.NotAfter) {
+		return []error{fmt.Errorf("certificate %s expired on %s", certFile, cert.Leaf.NotAfter.Format(time.DateOnly))}
+	}
+	return nil
+}
+
+func validateREALITY(r *REALITYConfig) []error {
+	var errs []error
+	if key, err := base64.RawURLEncoding.DecodeString(r.PrivateKey); err != nil || len(key) != 32 {
+		errs = append(errs, fmt.Errorf("REALITY PrivateKey is not a base64 X25519 key, generate one with xray x25519"))
+	}
+	if err := validateDest(r.Dest); err != nil {
+		errs = append(errs, fmt.Errorf("REALITY %s", err))
+	}
+	if r.ProxyProtocolVer > 2 {
+		errs = append(errs, fmt.Errorf("REALITY: unsupported ProxyProtocolVer %d", r.ProxyProtocolVer))
+	}
+	if len(r.ServerNames) == 0 {
+		errs = append(errs, fmt.Errorf("REALITY needs ServerNames"))
+	}
+	for _, id := range r.ShortIds {
+		if _, err := hex.DecodeString(id); err != nil || len(id) > 16 {
+			errs = append(errs, fmt.Errorf("REALITY ShortId %q is not up to 16 hex digits of even length", id))
+		}
+	}
+	for _, ver := range [][2]string{{"MinClientVer", r.MinClientVer}, {"MaxClientVer", r.MaxClientVer}} {
+		if ver[1] != "" && !clientVerRegexp.MatchString(ver[1]) {
+			errs = append(errs, fmt.Errorf("REALITY %s %q is not x.y.z", ver[0], ver[1]))
+		}
+	}
+	return errs
+}
+
+// validateDest checks a fallback or REALITY dest: a port, an address or a unix socket path
+func validateDest(dest string) error {
+	switch {
+	case dest == "":
+		return fmt.Errorf("dest is required")
+	case strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "@"):
+		return nil
+	}
+	port := dest
+	if strings.Contains(dest, ":") {
+		host, p, err := net.SplitHostPort(dest)
+		if err != nil || host == "" {
+			return fmt.Errorf("dest %q is not a port, host:port or unix socket path", dest)
+		}
+		port = p
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("dest %q has an invalid port", dest)
+	}
+	return nil
+}
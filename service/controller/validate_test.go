@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Xray-P/common/mylego"
+)
+
+// writeCert writes a self-signed certificate valid until notAfter
+func writeCert(t *testing.T, notAfter time.Time) (string, string) {
+	return writeCertFor(t, notAfter, "node.test.com")
+}
+
+// writeCertFor writes a self-signed certificate of names valid until notAfter
+func writeCertFor(t *testing.T, notAfter time.Time, names ...string) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     names,
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "node.cert"), filepath.Join(dir, "node.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestValidate(t *testing.T) {
+	certFile, keyFile := writeCert(t, time.Now().Add(30*24*time.Hour))
+	valid := &Config{
+		ListenIP:       "0.0.0.0",
+		CertConfig:     &mylego.CertConfig{CertMode: "file", CertFile: certFile, KeyFile: keyFile},
+		EnableFallback: true,
+		FallBackConfigs: []*FallBackConfig{
+			{Dest: "80"}, {Dest: "127.0.0.1:8080"}, {Dest: "/dev/shm/fallback.sock"},
+		},
+		EnableREALITY: true,
+		REALITYConfigs: &REALITYConfig{
+			Dest:        "www.amazon.com:443",
+			ServerNames: []string{"www.amazon.com"},
+			PrivateKey:  "YFb_H4dWm8gKGJmEjmDGLzkqQZcLN9AbTkZ-P-lpYGo",
+			ShortIds:    []string{"", "0123456789abcdef"},
+		},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid config: %s", err)
+	}
+
+	expiredCert, expiredKey := writeCert(t, time.Now().Add(-time.Hour))
+	invalid := &Config{
+		ListenIP:       "0.0.0",
+		DNSType:        "UseIPv5",
+		CertConfig:     &mylego.CertConfig{CertMode: "file", CertFile: expiredCert, KeyFile: expiredKey},
+		EnableFallback: true,
+		FallBackConfigs: []*FallBackConfig{
+			{Dest: ""}, {Dest: "localhost:http"}, {Dest: "80", ProxyProtocolVer: 3},
+		},
+		EnableREALITY: true,
+		REALITYConfigs: &REALITYConfig{
+			Dest:         "www.amazon.com",
+			PrivateKey:   "not a key",
+			ShortIds:     []string{"abc", "0123456789abcdef01"},
+			MinClientVer: "1.8",
+		},
+	}
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	problems := strings.Split(err.Error(), "\n")
+	// IP, DNSType, expired cert, 3 fallbacks, key, dest, server names, 2 short ids, client version
+	if len(problems) != 12 {
+		t.Errorf("got %d problems, want 12:\n%s", len(problems), err)
+	}
+	for _, want := range []string{"ListenIP", "UseIPv5", "expired", "PrivateKey", "ServerNames", "MinClientVer"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("no problem about %s in:\n%s", want, err)
+		}
+	}
+}
+
+func TestValidateCert(t *testing.T) {
+	certFile, keyFile := writeCert(t, time.Now().Add(30*24*time.Hour))
+	for _, c := range []*mylego.CertConfig{
+		{CertMode: "file", Certificates: []*mylego.CertFileConfig{{CertFile: certFile, KeyFile: keyFile}}},
+		{CertMode: "dns", CertDomains: []string{"*.test.com", "test.com"}, Provider: "cloudflare"},
+		{CertMode: "http", CertDomain: "a.test.com", CertDomains: []string{"b.test.com"}},
+		{CertMode: "tls", CertDomain: "a.test.com", CAServer: "https://ca.internal/acme", CABundle: certFile, KeyType: "RSA2048",
+			EABKeyID: "kid", EABHMACKey: "c2VjcmV0LWhtYWMta2V5", ChallengeListen: "127.0.0.1:8443"},
+		{CertMode: "self", CertDomain: "staging.test.com", CertDomains: []string{"*.staging.test.com", "10.0.0.1"}},
+	} {
+		if errs := validateCert(c); len(errs) > 0 {
+			t.Errorf("valid cert config %+v: %v", c, errs)
+		}
+	}
+	for want, c := range map[string]*mylego.CertConfig{
+		"needs CertMode dns":  {CertMode: "http", CertDomains: []string{"*.test.com"}},
+		"needs CertDomain or": {CertMode: "self"},
+		"unsupported KeyType": {CertMode: "self", CertDomain: "a.test.com", KeyType: "ED25519"},
+		"invalid wildcard":    {CertMode: "dns", CertDomain: "a.*.test.com", Provider: "cloudflare"},
+		"needs CertDomain":    {CertMode: "tls"},
+		"needs CertFile":      {CertMode: "file"},
+		"certificate needs":   {CertMode: "file", Certificates: []*mylego.CertFileConfig{{CertFile: certFile}}},
+		"CAServer":            {CertMode: "http", CertDomain: "a.test.com", CAServer: "letsencrypt2"},
+		"KeyType":             {CertMode: "http", CertDomain: "a.test.com", KeyType: "ED25519"},
+		"both EABKeyID":       {CertMode: "http", CertDomain: "a.test.com", CAServer: "zerossl", EABKeyID: "kid"},
+		"CABundle":            {CertMode: "http", CertDomain: "a.test.com", CABundle: keyFile},
+	} {
+		errs := validateCert(c)
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), want) {
+			t.Errorf("cert config %+v: got %v, want %s", c, errs, want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package health serves the liveness and readiness endpoints of the nodes for
+// load balancers and uptime monitors
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"Xray-P/service/controller"
+)
+
+const (
+	defaultListen         = "127.0.0.1:9101"
+	defaultMaxPanelErrors = 3
+	defaultCertExpiryDays = 7
+
+	StatusOK        = "ok"
+	StatusUnhealthy = "unhealthy"
+	StatusNotReady  = "not ready"
+)
+
+type Config struct {
+	Enable         bool   `mapstructure:"Enable"`
+	Listen         string `mapstructure:"Listen"`
+	MaxPanelErrors int    `mapstructure:"MaxPanelErrors"` // Consecutive failed panel syncs of a node to be unhealthy
+	CertExpiryDays int    `mapstructure:"CertExpiryDays"` // Days left on the certificate of a node to be unhealthy
+}
+
+// Node is the health of a node
+type Node struct {
+	controller.Health
+	Status   string   `json:"status"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Report is the body of the endpoints
+type Report struct {
+	Status string `json:"status"`
+	Nodes  []Node `json:"nodes"`
+}
+
+// Server serves /healthz and /readyz
+type Server struct {
+	maxPanelErrors int
+	certExpiry     time.Duration
+	listen         string
+	controllers    func() []*controller.Controller
+	ready          func() bool
+	server         *http.Server
+}
+
+// New returns the health endpoints of the controllers, ready tells whether the
+// panel is started and not shutting down
+func New(config *Config, controllers func() []*controller.Controller, ready func() bool) *Server {
+	s := &Server{
+		maxPanelErrors: config.MaxPanelErrors,
+		certExpiry:     time.Duration(config.CertExpiryDays) * 24 * time.Hour,
+		listen:         config.Listen,
+		controllers:    controllers,
+		ready:          ready,
+	}
+	if s.maxPanelErrors <= 0 {
+		s.maxPanelErrors = defaultMaxPanelErrors
+	}
+	if s.certExpiry <= 0 {
+		s.certExpiry = defaultCertExpiryDays * 24 * time.Hour
+	}
+	if s.listen == "" {
+		s.listen = defaultListen
+	}
+	s.server = &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	return s
+}
+
+// Start listens on config.Listen and serves the endpoints
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Health server stopped: %s", err)
+		}
+	}()
+	log.Printf("Health checks listening on http://%s", listener.Addr())
+	return nil
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// Handler returns the HTTP handler of the endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	return mux
+}
+
+// Check returns the health of every node
+func (s *Server) Check() Report {
+	report := Report{Status: StatusOK, Nodes: make([]Node, 0)}
+	for _, c := range s.controllers() {
+		node := s.checkNode(c.Health())
+		if node.Status != StatusOK {
+			report.Status = StatusUnhealthy
+		}
+		report.Nodes = append(report.Nodes, node)
+	}
+	return report
+}
+
+func (s *Server) checkNode(h controller.Health) Node {
+	node := Node{Health: h, Status: StatusOK}
+	if h.PanelErrors >= s.maxPanelErrors {
+		node.Problems = append(node.Problems, fmt.Sprintf("last %d panel syncs failed", h.PanelErrors))
+	}
+	if !h.Inbound {
+		node.Problems = append(node.Problems, "no live inbound handler")
+	}
+	if !h.CertNotAfter.IsZero() {
+		if left := time.Until(h.CertNotAfter); left <= 0 {
+			node.Problems = append(node.Problems, "certificate expired")
+		} else if left < s.certExpiry {
+			node.Problems = append(node.Problems, fmt.Sprintf("certificate expires in %s", left.Round(time.Hour)))
+		}
+	}
+	for _, task := range h.StalledTasks {
+		node.Problems = append(node.Problems, fmt.Sprintf("periodic task %s stalled", task))
+	}
+	if len(node.Problems) > 0 {
+		node.Status = StatusUnhealthy
+	}
+	return node
+}
+
+// healthz reports whether every node is healthy
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	report := s.Check()
+	writeReport(w, report)
+}
+
+// readyz also reports not ready while the panel is starting or draining,
+// or runs no node
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	report := s.Check()
+	if report.Status == StatusOK && (!s.ready() || len(report.Nodes) == 0) {
+		report.Status = StatusNotReady
+	}
+	writeReport(w, report)
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Debugf("Write health response failed: %s", err)
+	}
+}
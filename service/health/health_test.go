@@ -0,0 +1,62 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Xray-P/service/controller"
+)
+
+func TestCheckNode(t *testing.T) {
+	s := New(&Config{MaxPanelErrors: 2, CertExpiryDays: 3}, nil, nil)
+	healthy := controller.Health{Tag: "V2ray_0.0.0.0_443", Inbound: true, CertNotAfter: time.Now().Add(30 * 24 * time.Hour)}
+	if node := s.checkNode(healthy); node.Status != StatusOK || len(node.Problems) != 0 {
+		t.Errorf("healthy node: %+v", node)
+	}
+
+	for name, h := range map[string]controller.Health{
+		"panel":   {Inbound: true, PanelErrors: 2},
+		"inbound": {Inbound: false},
+		"expired": {Inbound: true, CertNotAfter: time.Now().Add(-time.Hour)},
+		"expiry":  {Inbound: true, CertNotAfter: time.Now().Add(24 * time.Hour)},
+		"stalled": {Inbound: true, StalledTasks: []string{"user monitor"}},
+	} {
+		if node := s.checkNode(h); node.Status != StatusUnhealthy || len(node.Problems) != 1 {
+			t.Errorf("%s: %+v", name, node)
+		}
+	}
+	// Fewer failed syncs than the threshold
+	if node := s.checkNode(controller.Health{Inbound: true, PanelErrors: 1}); node.Status != StatusOK {
+		t.Errorf("one failed sync: %+v", node)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	ready := false
+	s := New(&Config{}, func() []*controller.Controller { return nil }, func() bool { return ready })
+	handler := s.Handler()
+	get := func(path string) (int, Report) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		report := Report{}
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, report
+	}
+
+	if code, report := get("/healthz"); code != http.StatusOK || report.Status != StatusOK {
+		t.Errorf("healthz: got %d %+v", code, report)
+	}
+	if code, report := get("/readyz"); code != http.StatusServiceUnavailable || report.Status != StatusNotReady {
+		t.Errorf("readyz while not started: got %d %+v", code, report)
+	}
+	// Started but running no node
+	ready = true
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz without nodes: got %d", code)
+	}
+}
@@ -24,6 +24,8 @@ import (
 	"github.com/xtls/xray-core/transport"
 	"github.com/xtls/xray-core/transport/pipe"
 
+	"github.com/xtls/xray-core/xrayr/abuse"
+	"github.com/xtls/xray-core/xrayr/conntrack"
 	"github.com/xtls/xray-core/xrayr/limiter"
 	"github.com/xtls/xray-core/xrayr/rule"
 )
@@ -105,6 +107,8 @@ type DefaultDispatcher struct {
 	dns         dns.Client
 	Limiter     *limiter.Limiter
 	RuleManager *rule.Manager
+	AbuseGuard  *abuse.Manager
+	ConnTrack   *conntrack.Manager
 }
 
 func init() {
@@ -131,6 +135,12 @@ func (d *DefaultDispatcher) Init(config *Config, om outbound.Manager, router rou
 	d.dns = dc
 	d.Limiter = limiter.New()
 	d.RuleManager = rule.New()
+	d.AbuseGuard = abuse.New()
+	d.AbuseGuard.SetResolver(func(domain string) ([]net.IP, error) {
+		ips, _, err := dc.LookupIP(domain, dns.IPOption{IPv4Enable: true, IPv6Enable: true})
+		return ips, err
+	})
+	d.ConnTrack = conntrack.New()
 	return nil
 }
 
@@ -169,10 +179,12 @@ func (d *DefaultDispatcher) getLink(ctx context.Context) (*transport.Link, *tran
 	}
 
 	if user != nil && len(user.Email) > 0 {
+		conn := conntrack.FromContext(ctx)
 		// Speed Limit and Device Limit
 		bucket, ok, reject := d.Limiter.GetUserBucket(sessionInbound.Tag, user.Email, sessionInbound.Source.Address.IP().String())
 		if reject {
-			errors.LogWarning(ctx, "Devices reach the limit: ", user.Email)
+			log.RecordFields(log.Severity_Warning, "Devices reach the limit", log.Fields{"email": user.Email, "ip": sessionInbound.Source.Address.IP().String()})
+			conn.SetReason(conntrack.ReasonDeviceLimit)
 			common.Close(outboundLink.Writer)
 			common.Close(inboundLink.Writer)
 			common.Interrupt(outboundLink.Reader)
@@ -183,6 +195,18 @@ func (d *DefaultDispatcher) getLink(ctx context.Context) (*transport.Link, *tran
 			inboundLink.Writer = d.Limiter.RateWriter(inboundLink.Writer, bucket)
 			outboundLink.Writer = d.Limiter.RateWriter(outboundLink.Writer, bucket)
 		}
+		if conn != nil {
+			inboundLink.Writer = &SizeStatWriter{Counter: &conn.Uplink, Writer: inboundLink.Writer}
+			outboundLink.Writer = &SizeStatWriter{Counter: &conn.Downlink, Writer: outboundLink.Writer}
+		}
+		d.Limiter.AddSession(ctx, sessionInbound.Tag, user.Email, func() {
+			conn.SetReason(conntrack.ReasonKicked)
+			common.Interrupt(uplinkWriter)
+			common.Interrupt(downlinkWriter)
+			if sessionInbound.Conn != nil {
+				sessionInbound.Conn.Close()
+			}
+		})
 
 		p := d.policy.ForLevel(user.Level)
 		errors.LogInfo(ctx, "Dispatcher: Policy StatsUserOnline=", p.Stats.UserOnline)
@@ -323,6 +347,8 @@ func (d *DefaultDispatcher) Dispatch(ctx context.Context, destination net.Destin
 		ctx = session.ContextWithContent(ctx, content)
 	}
 
+	ctx = d.ConnTrack.Track(ctx)
+
 	sniffingRequest := content.SniffingRequest
 	inbound, outbound := d.getLink(ctx)
 	if !sniffingRequest.Enabled {
@@ -387,15 +413,31 @@ func (d *DefaultDispatcher) DispatchLink(ctx context.Context, destination net.De
 	}
 
 	if user != nil && len(user.Email) > 0 {
+		ctx = d.ConnTrack.Track(ctx)
+		conn := conntrack.FromContext(ctx)
 		// Speed Limit and Device Limit
 		bucket, ok, reject := d.Limiter.GetUserBucket(sessionInbound.Tag, user.Email, sessionInbound.Source.Address.IP().String())
 		if reject {
-			errors.LogWarning(ctx, "Devices reach the limit: ", user.Email)
+			log.RecordFields(log.Severity_Warning, "Devices reach the limit", log.Fields{"email": user.Email, "ip": sessionInbound.Source.Address.IP().String()})
+			conn.SetReason(conntrack.ReasonDeviceLimit)
 			return errors.New("Devices reach the limit: " + user.Email)
 		}
 		if ok {
 			outbound.Writer = d.Limiter.RateWriter(outbound.Writer, bucket)
 		}
+		if conn != nil {
+			outbound.Reader = &SizeStatReader{Counter: &conn.Uplink, Reader: outbound.Reader}
+			outbound.Writer = &SizeStatWriter{Counter: &conn.Downlink, Writer: outbound.Writer}
+		}
+		link := *outbound
+		d.Limiter.AddSession(ctx, sessionInbound.Tag, user.Email, func() {
+			conn.SetReason(conntrack.ReasonKicked)
+			common.Interrupt(link.Reader)
+			common.Interrupt(link.Writer)
+			if sessionInbound.Conn != nil {
+				sessionInbound.Conn.Close()
+			}
+		})
 	}
 
 	outbound = d.WrapLink(ctx, outbound)
@@ -496,8 +538,15 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 	// Whether the inbound connection contains a user
 	if sessionInbound != nil && sessionInbound.User != nil {
 		if d.RuleManager.Detect(sessionInbound.Tag, destination.String(), sessionInbound.User.Email) {
-			errors.LogError(ctx, "User ", sessionInbound.User.Email, " access ", destination.String(), " reject by rule")
-			errors.New("destination is reject by rule")
+			log.RecordFields(log.Severity_Error, "Access rejected by rule", log.Fields{"email": sessionInbound.User.Email, "destination": destination.String()})
+			conntrack.FromContext(ctx).SetReason(conntrack.ReasonRule)
+			common.Close(link.Writer)
+			common.Interrupt(link.Reader)
+			return
+		}
+		if d.AbuseGuard.Check(sessionInbound.Tag, sessionInbound.User.Email, destination) {
+			log.RecordFields(log.Severity_Warning, "Access rejected by abuse guard", log.Fields{"email": sessionInbound.User.Email, "destination": destination.String()})
+			conntrack.FromContext(ctx).SetReason(conntrack.ReasonAbuseGuard)
 			common.Close(link.Writer)
 			common.Interrupt(link.Reader)
 			return
@@ -520,6 +569,7 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 			handler = h
 		} else {
 			errors.LogError(ctx, "non existing tag for platform initialized detour: ", forcedOutboundTag)
+			conntrack.FromContext(ctx).SetReason(conntrack.ReasonNoOutbound)
 			common.Close(link.Writer)
 			common.Interrupt(link.Reader)
 			return
@@ -537,6 +587,7 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 				handler = h
 			} else {
 				errors.LogWarning(ctx, "non existing outTag: ", outTag)
+				conntrack.FromContext(ctx).SetReason(conntrack.ReasonNoOutbound)
 				common.Close(link.Writer)
 				common.Interrupt(link.Reader)
 				return // DO NOT CHANGE: the traffic shouldn't be processed by default outbound if the specified outbound tag doesn't exist (yet), e.g., VLESS Reverse Proxy
@@ -552,6 +603,7 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 
 	if handler == nil {
 		errors.LogInfo(ctx, "default outbound handler not exist")
+		conntrack.FromContext(ctx).SetReason(conntrack.ReasonNoOutbound)
 		common.Close(link.Writer)
 		common.Interrupt(link.Reader)
 		return
@@ -114,6 +114,10 @@ func (g *Instance) Handle(msg log.Message) {
 		if g.accessLogger != nil {
 			g.accessLogger.Handle(Msg)
 		}
+	case *log.ConnectionMessage:
+		if g.accessLogger != nil {
+			g.accessLogger.Handle(Msg)
+		}
 	case *log.DNSLog:
 		if g.dns && g.accessLogger != nil {
 			g.accessLogger.Handle(Msg)
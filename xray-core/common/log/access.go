@@ -2,7 +2,9 @@ package log
 
 import (
 	"context"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/xtls/xray-core/common/serial"
 )
@@ -58,6 +60,59 @@ func (m *AccessMessage) String() string {
 	return builder.String()
 }
 
+// ConnectionMessage is written to the access log once a connection of a user is closed.
+type ConnectionMessage struct {
+	From     string
+	To       string
+	Domain   string
+	Protocol string
+	Tag      string
+	Detour   string
+	Email    string
+	Upload   int64
+	Download int64
+	Duration time.Duration
+	Reason   string
+}
+
+func (m *ConnectionMessage) String() string {
+	builder := strings.Builder{}
+	builder.WriteString("from ")
+	builder.WriteString(m.From)
+	builder.WriteString(" closed ")
+	builder.WriteString(m.To)
+
+	if len(m.Domain) > 0 {
+		builder.WriteString(" (")
+		builder.WriteString(m.Domain)
+		builder.WriteByte(')')
+	}
+
+	builder.WriteString(" [")
+	builder.WriteString(m.Tag)
+	if len(m.Detour) > 0 {
+		builder.WriteString(" -> ")
+		builder.WriteString(m.Detour)
+	}
+	builder.WriteByte(']')
+
+	builder.WriteString(" up: ")
+	builder.WriteString(strconv.FormatInt(m.Upload, 10))
+	builder.WriteString(" down: ")
+	builder.WriteString(strconv.FormatInt(m.Download, 10))
+	builder.WriteString(" duration: ")
+	builder.WriteString(m.Duration.Round(time.Millisecond).String())
+	builder.WriteString(" reason: ")
+	builder.WriteString(m.Reason)
+
+	if len(m.Email) > 0 {
+		builder.WriteString(" email: ")
+		builder.WriteString(m.Email)
+	}
+
+	return builder.String()
+}
+
 func ContextWithAccessMessage(ctx context.Context, accessMessage *AccessMessage) context.Context {
 	return context.WithValue(ctx, accessMessageKey, accessMessage)
 }
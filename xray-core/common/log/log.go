@@ -1,6 +1,8 @@
 package log // import "github.com/xtls/xray-core/common/log"
 
 import (
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/xtls/xray-core/common/serial"
@@ -62,3 +64,36 @@ func (h *syncHandler) Set(handler Handler) {
 
 	h.Handler = handler
 }
+
+// Fields are the structured fields of a log message.
+type Fields map[string]interface{}
+
+// FieldsContent is the content of a GeneralMessage with structured fields.
+// Text handlers print it as the message followed by sorted key=value pairs.
+type FieldsContent struct {
+	Message string
+	Fields  Fields
+}
+
+// String implements fmt.Stringer.
+func (c *FieldsContent) String() string {
+	keys := make([]string, 0, len(c.Fields))
+	for k := range c.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	builder := strings.Builder{}
+	builder.WriteString(c.Message)
+	for _, k := range keys {
+		builder.WriteString(" " + k + "=" + serial.ToString(c.Fields[k]))
+	}
+	return builder.String()
+}
+
+// RecordFields writes a general message with structured fields into log stream.
+func RecordFields(severity Severity, message string, fields Fields) {
+	Record(&GeneralMessage{
+		Severity: severity,
+		Content:  &FieldsContent{Message: message, Fields: fields},
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/xtls/xray-core/common/platform/filesystem"
 	"github.com/xtls/xray-core/common/protocol/tls/cert"
 	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/xrayr/certstore"
 )
 
 var globalSessionCache = tls.NewLRUClientSessionCache(128)
@@ -48,7 +49,18 @@ func (c *Config) loadSelfCertPool() (*x509.CertPool, error) {
 
 // BuildCertificates builds a list of TLS certificates from proto definition.
 func (c *Config) BuildCertificates() []*tls.Certificate {
-	certs := make([]*tls.Certificate, 0, len(c.Certificate))
+	keyPairs := c.buildKeyPairs()
+	certs := make([]*tls.Certificate, 0, len(keyPairs))
+	for _, keyPair := range keyPairs {
+		certs = append(certs, keyPair.Load())
+	}
+	return certs
+}
+
+// buildKeyPairs returns the key pairs of the certificates, replaced when the files are
+// reloaded. The pairs loaded from files are shared through certstore.
+func (c *Config) buildKeyPairs() []*certstore.Pair {
+	keyPairs := make([]*certstore.Pair, 0, len(c.Certificate))
 	for _, entry := range c.Certificate {
 		if entry.Usage != Certificate_ENCIPHERMENT {
 			continue
@@ -66,32 +78,44 @@ func (c *Config) BuildCertificates() []*tls.Certificate {
 			}
 			return &keyPair
 		}
-		if keyPair := getX509KeyPair(); keyPair != nil {
-			certs = append(certs, keyPair)
-		} else {
+		keyPair := getX509KeyPair()
+		if keyPair == nil {
 			continue
 		}
-		index := len(certs) - 1
+		var pair *certstore.Pair
+		if entry.CertificatePath != "" && entry.KeyPath != "" && !entry.OneTimeLoading {
+			pair = certstore.Register(entry.CertificatePath, entry.KeyPath, keyPair)
+		} else {
+			pair = new(certstore.Pair)
+			pair.Store(keyPair)
+		}
+		keyPairs = append(keyPairs, pair)
 		setupOcspTicker(entry, func(isReloaded, isOcspstapling bool) {
-			cert := certs[index]
+			inUse := pair.Load()
+			cert := inUse
 			if isReloaded {
-				if newKeyPair := getX509KeyPair(); newKeyPair != nil {
-					cert = newKeyPair
-				} else {
+				newKeyPair, err := certstore.Parse(entry.Certificate, entry.Key)
+				if err != nil {
+					errors.LogWarningInner(context.Background(), err, "ignoring the reloaded X509 key pair")
 					return
 				}
+				cert = newKeyPair
 			}
 			if isOcspstapling {
 				if newOCSPData, err := ocsp.GetOCSPForCert(cert.Certificate); err != nil {
 					errors.LogWarningInner(context.Background(), err, "ignoring invalid OCSP")
 				} else if string(newOCSPData) != string(cert.OCSPStaple) {
-					cert.OCSPStaple = newOCSPData
+					// The pair in use is never modified, handshakes may be reading it
+					stapled := *cert
+					stapled.OCSPStaple = newOCSPData
+					cert = &stapled
 				}
 			}
-			certs[index] = cert
+			// A pair reloaded meanwhile by certstore is newer
+			pair.CompareAndSwap(inUse, cert)
 		})
 	}
-	return certs
+	return keyPairs
 }
 
 func setupOcspTicker(entry *Certificate, callback func(isReloaded, isOcspstapling bool)) {
@@ -109,17 +133,12 @@ func setupOcspTicker(entry *Certificate, callback func(isReloaded, isOcspstaplin
 		for {
 			var isReloaded bool
 			if entry.CertificatePath != "" && entry.KeyPath != "" {
-				newCert, err := filesystem.ReadCert(entry.CertificatePath)
-				if err != nil {
+				// The files may be missing while they are replaced, the next tick reads them again
+				if newCert, err := filesystem.ReadCert(entry.CertificatePath); err != nil {
 					errors.LogErrorInner(context.Background(), err, "failed to parse certificate")
-					return
-				}
-				newKey, err := filesystem.ReadCert(entry.KeyPath)
-				if err != nil {
+				} else if newKey, err := filesystem.ReadCert(entry.KeyPath); err != nil {
 					errors.LogErrorInner(context.Background(), err, "failed to parse key")
-					return
-				}
-				if string(newCert) != string(entry.Certificate) || string(newKey) != string(entry.Key) {
+				} else if string(newCert) != string(entry.Certificate) || string(newKey) != string(entry.Key) {
 					entry.Certificate = newCert
 					entry.Key = newKey
 					isReloaded = true
@@ -244,20 +263,21 @@ func getGetCertificateFunc(c *tls.Config, ca []*Certificate) func(hello *tls.Cli
 	}
 }
 
-func getNewGetCertificateFunc(certs []*tls.Certificate, rejectUnknownSNI bool) func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+func getNewGetCertificateFunc(keyPairs []*certstore.Pair, rejectUnknownSNI bool) func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		if len(certs) == 0 {
+		if len(keyPairs) == 0 {
 			return nil, errNoCertificates
 		}
 		sni := strings.ToLower(hello.ServerName)
-		if !rejectUnknownSNI && (len(certs) == 1 || sni == "") {
-			return certs[0], nil
+		if !rejectUnknownSNI && (len(keyPairs) == 1 || sni == "") {
+			return keyPairs[0].Load(), nil
 		}
 		gsni := "*"
 		if index := strings.IndexByte(sni, '.'); index != -1 {
 			gsni += sni[index:]
 		}
-		for _, keyPair := range certs {
+		for _, pair := range keyPairs {
+			keyPair := pair.Load()
 			if keyPair.Leaf.Subject.CommonName == sni || keyPair.Leaf.Subject.CommonName == gsni {
 				return keyPair, nil
 			}
@@ -270,7 +290,7 @@ func getNewGetCertificateFunc(certs []*tls.Certificate, rejectUnknownSNI bool) f
 		if rejectUnknownSNI {
 			return nil, errNoCertificates
 		}
-		return certs[0], nil
+		return keyPairs[0].Load(), nil
 	}
 }
 
@@ -390,7 +410,7 @@ func (c *Config) GetTLSConfig(opts ...Option) *tls.Config {
 	if len(caCerts) > 0 {
 		config.GetCertificate = getGetCertificateFunc(config, caCerts)
 	} else {
-		config.GetCertificate = getNewGetCertificateFunc(c.BuildCertificates(), c.RejectUnknownSni)
+		config.GetCertificate = getNewGetCertificateFunc(c.buildKeyPairs(), c.RejectUnknownSni)
 	}
 
 	if sn := c.parseServerName(); len(sn) > 0 {
@@ -0,0 +1,324 @@
+// Package abuse is to detect users that send spam or scan networks through the node
+package abuse
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/common/net"
+
+	"github.com/xtls/xray-core/xrayr/api"
+)
+
+const (
+	ActionThrottle = "throttle"
+	ActionBlock    = "block"
+
+	window          = time.Minute
+	maxRecentEvents = 100
+
+	reasonDestIPs   = "too many destination addresses"
+	reasonDestPorts = "too many destination ports"
+	reasonSMTP      = "too many SMTP connections"
+)
+
+var defaultSMTPPorts = []uint16{25, 465, 587}
+
+type userCounter struct {
+	access      sync.Mutex
+	windowStart time.Time
+	ips         map[string]struct{}
+	ports       map[net.Port]struct{}
+	smtp        int
+	penaltyEnd  time.Time
+	// The traffic rejected until penaltyEnd when throttled: the destinations known when
+	// the penalty started are still allowed, the window limits reset meanwhile
+	penaltyReason string
+	penaltyIPs    map[string]struct{}
+	penaltyPorts  map[net.Port]struct{}
+	removed       bool // Swept, Check takes a new counter
+}
+
+// expired reports whether the counter holds nothing to enforce anymore
+func (c *userCounter) expired(now time.Time) bool {
+	return now.Sub(c.windowStart) >= window && !now.Before(c.penaltyEnd)
+}
+
+type InboundGuard struct {
+	Tag          string
+	config       *Config
+	smtpPorts    map[net.Port]struct{}
+	UserCounter  *sync.Map // Key: Email, value: *userCounter
+	DetectResult mapset.Set
+	Rejects      atomic.Int64
+	access       sync.Mutex
+	recent       []Event
+	lastSweep    time.Time
+	log          *rollingLog
+}
+
+type Manager struct {
+	InboundGuard *sync.Map // Key: Tag, Value: *InboundGuard
+	now          func() time.Time
+	lookupIP     func(domain string) ([]net.IP, error)
+}
+
+func New() *Manager {
+	return &Manager{
+		InboundGuard: new(sync.Map),
+		now:          time.Now,
+	}
+}
+
+// SetResolver sets how the domain destinations are resolved to be counted by IP,
+// it is called before any Check
+func (m *Manager) SetResolver(lookupIP func(domain string) ([]net.IP, error)) {
+	m.lookupIP = lookupIP
+}
+
+func (m *Manager) AddInboundGuard(tag string, config *Config) error {
+	if config == nil || !config.Enable {
+		return nil
+	}
+	c := *config
+	if c.Action == "" {
+		c.Action = ActionThrottle
+	}
+	if c.Action != ActionThrottle && c.Action != ActionBlock {
+		return fmt.Errorf("unsupported abuse guard action: %s", c.Action)
+	}
+	if c.Duration <= 0 {
+		c.Duration = 10
+	}
+	if len(c.SMTPPorts) == 0 {
+		c.SMTPPorts = defaultSMTPPorts
+	}
+	guard := &InboundGuard{
+		Tag:          tag,
+		config:       &c,
+		smtpPorts:    make(map[net.Port]struct{}),
+		UserCounter:  new(sync.Map),
+		DetectResult: mapset.NewSet(),
+	}
+	for _, p := range c.SMTPPorts {
+		guard.smtpPorts[net.Port(p)] = struct{}{}
+	}
+	if c.LogPath != "" {
+		guard.log = getRollingLog(c.LogPath, c.LogMaxSize)
+	}
+	m.InboundGuard.Store(tag, guard) // Replace the old inbound guard
+	return nil
+}
+
+func (m *Manager) DeleteInboundGuard(tag string) error {
+	m.InboundGuard.Delete(tag)
+	return nil
+}
+
+// GetDetectResult returns the users that triggered the guard since the last call
+func (m *Manager) GetDetectResult(tag string) (*[]api.DetectResult, error) {
+	detectResult := make([]api.DetectResult, 0)
+	if value, ok := m.InboundGuard.Load(tag); ok {
+		guard := value.(*InboundGuard)
+		for _, result := range guard.DetectResult.ToSlice() {
+			detectResult = append(detectResult, result.(api.DetectResult))
+			guard.DetectResult.Remove(result)
+		}
+	}
+	return &detectResult, nil
+}
+
+// GetRejectCount returns the connections rejected by the guard since the last call
+func (m *Manager) GetRejectCount(tag string) int64 {
+	if value, ok := m.InboundGuard.Load(tag); ok {
+		return value.(*InboundGuard).Rejects.Swap(0)
+	}
+	return 0
+}
+
+// GetEvents returns the latest events of an inbound, oldest first
+func (m *Manager) GetEvents(tag string) []Event {
+	if value, ok := m.InboundGuard.Load(tag); ok {
+		guard := value.(*InboundGuard)
+		guard.access.Lock()
+		defer guard.access.Unlock()
+		return append([]Event(nil), guard.recent...)
+	}
+	return nil
+}
+
+// Check counts a new connection of the user and returns whether it should be rejected
+func (m *Manager) Check(tag string, email string, destination net.Destination) (reject bool) {
+	value, ok := m.InboundGuard.Load(tag)
+	if !ok {
+		return false
+	}
+	guard := value.(*InboundGuard)
+	now := m.now()
+	guard.sweep(now)
+	address := m.destinationIP(guard, destination)
+
+	var counter *userCounter
+	for {
+		v, _ := guard.UserCounter.LoadOrStore(email, &userCounter{})
+		counter = v.(*userCounter)
+		counter.access.Lock()
+		if !counter.removed {
+			break
+		}
+		counter.access.Unlock()
+	}
+	defer counter.access.Unlock()
+
+	if now.Sub(counter.windowStart) >= window {
+		counter.windowStart = now
+		counter.ips = make(map[string]struct{})
+		counter.ports = make(map[net.Port]struct{})
+		counter.smtp = 0
+	}
+
+	penalized := now.Before(counter.penaltyEnd)
+	if penalized && (guard.config.Action == ActionBlock || counter.throttled(guard, destination, address)) {
+		guard.Rejects.Add(1)
+		return true
+	}
+
+	_, isSMTP := guard.smtpPorts[destination.Port]
+	_, knownIP := counter.ips[address]
+	_, knownPort := counter.ports[destination.Port]
+
+	var reason string
+	switch {
+	case !knownIP && guard.config.MaxDestIPs > 0 && len(counter.ips) >= guard.config.MaxDestIPs:
+		reason = reasonDestIPs
+	case !knownPort && guard.config.MaxDestPorts > 0 && len(counter.ports) >= guard.config.MaxDestPorts:
+		reason = reasonDestPorts
+	case isSMTP && guard.config.MaxSMTPConns > 0 && counter.smtp >= guard.config.MaxSMTPConns:
+		reason = reasonSMTP
+	}
+
+	if reason == "" {
+		counter.ips[address] = struct{}{}
+		counter.ports[destination.Port] = struct{}{}
+		if isSMTP {
+			counter.smtp++
+		}
+		return false
+	}
+
+	if !penalized {
+		counter.penaltyEnd = now.Add(time.Duration(guard.config.Duration) * time.Minute)
+		counter.penaltyReason = reason
+		counter.penaltyIPs = counter.ips
+		counter.penaltyPorts = counter.ports
+		guard.trigger(&Event{
+			Time:      now.Format(time.RFC3339),
+			Tag:       tag,
+			Email:     email,
+			UID:       uidFromEmail(email),
+			Reason:    reason,
+			DestIPs:   len(counter.ips),
+			DestPorts: len(counter.ports),
+			SMTPConns: counter.smtp,
+			LastDest:  destination.String(),
+			Action:    guard.config.Action,
+			Until:     counter.penaltyEnd.Format(time.RFC3339),
+		})
+	}
+	guard.Rejects.Add(1)
+	return true
+}
+
+// destinationIP returns the IP the destination is counted by. A domain is resolved, so
+// the names of one server are one destination, it is counted as is if it does not resolve.
+func (m *Manager) destinationIP(guard *InboundGuard, destination net.Destination) string {
+	if !destination.Address.Family().IsDomain() || guard.config.MaxDestIPs <= 0 || m.lookupIP == nil {
+		return destination.Address.String()
+	}
+	ips, err := m.lookupIP(destination.Address.Domain())
+	if err != nil || len(ips) == 0 {
+		return destination.Address.String()
+	}
+	return ips[0].String()
+}
+
+// throttled reports whether the destination, counted by address, is of the traffic
+// the penalty of the counter rejects
+func (c *userCounter) throttled(guard *InboundGuard, destination net.Destination, address string) bool {
+	switch c.penaltyReason {
+	case reasonDestIPs:
+		_, known := c.penaltyIPs[address]
+		return !known
+	case reasonDestPorts:
+		_, known := c.penaltyPorts[destination.Port]
+		return !known
+	case reasonSMTP:
+		_, isSMTP := guard.smtpPorts[destination.Port]
+		return isSMTP
+	}
+	return false
+}
+
+// sweep deletes the counters of the users without recent traffic nor penalty, once a window
+func (g *InboundGuard) sweep(now time.Time) {
+	g.access.Lock()
+	if now.Sub(g.lastSweep) < window {
+		g.access.Unlock()
+		return
+	}
+	g.lastSweep = now
+	g.access.Unlock()
+
+	g.UserCounter.Range(func(key, value interface{}) bool {
+		counter := value.(*userCounter)
+		counter.access.Lock()
+		if counter.expired(now) {
+			counter.removed = true
+			g.UserCounter.Delete(key)
+		}
+		counter.access.Unlock()
+		return true
+	})
+}
+
+func (g *InboundGuard) trigger(event *Event) {
+	log.RecordFields(log.Severity_Warning, "User triggered abuse guard", log.Fields{
+		"email":  event.Email,
+		"reason": event.Reason,
+		"action": event.Action,
+		"until":  event.Until,
+	})
+
+	g.access.Lock()
+	g.recent = append(g.recent, *event)
+	if len(g.recent) > maxRecentEvents {
+		g.recent = g.recent[len(g.recent)-maxRecentEvents:]
+	}
+	g.access.Unlock()
+
+	if g.log != nil {
+		if err := g.log.Write(event); err != nil {
+			errors.LogWarningInner(context.Background(), err, "failed to write abuse log")
+		}
+	}
+	if g.config.RuleID != 0 && event.UID > 0 {
+		g.DetectResult.Add(api.DetectResult{UID: event.UID, RuleID: g.config.RuleID})
+	}
+}
+
+func uidFromEmail(email string) int {
+	l := strings.Split(email, "|")
+	uid, err := strconv.Atoi(l[len(l)-1])
+	if err != nil {
+		return 0
+	}
+	return uid
+}
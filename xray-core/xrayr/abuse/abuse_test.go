@@ -0,0 +1,187 @@
+package abuse
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/net"
+)
+
+const (
+	tag   = "Vless_0.0.0.0_443"
+	email = tag + "|a@b.c|42"
+)
+
+func newTestManager(t *testing.T, config *Config) (*Manager, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	m := New()
+	m.now = func() time.Time { return now }
+	if err := m.AddInboundGuard(tag, config); err != nil {
+		t.Fatal(err)
+	}
+	return m, &now
+}
+
+func TestThrottleSMTP(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "abuse.log")
+	m, now := newTestManager(t, &Config{Enable: true, MaxSMTPConns: 2, RuleID: 9, LogPath: logPath})
+
+	smtp := net.TCPDestination(net.ParseAddress("1.2.3.4"), 25)
+	web := net.TCPDestination(net.ParseAddress("1.2.3.4"), 443)
+	for i := 0; i < 2; i++ {
+		if m.Check(tag, email, smtp) {
+			t.Fatalf("connection %d should not be rejected", i)
+		}
+	}
+	if !m.Check(tag, email, smtp) {
+		t.Fatal("third SMTP connection should be rejected")
+	}
+	// Throttling only rejects the abusive traffic
+	if m.Check(tag, email, web) {
+		t.Error("web traffic should not be rejected when throttled")
+	}
+
+	result, _ := m.GetDetectResult(tag)
+	if len(*result) != 1 || (*result)[0].UID != 42 || (*result)[0].RuleID != 9 {
+		t.Errorf("unexpected detect result: %v", *result)
+	}
+	if events := m.GetEvents(tag); len(events) != 1 {
+		t.Errorf("expected 1 event, got %d", len(events))
+	}
+	if data, err := os.ReadFile(logPath); err != nil || len(data) == 0 {
+		t.Errorf("abuse log not written: %v", err)
+	}
+
+	// The next windows within the penalty still reject SMTP, not the other traffic
+	*now = now.Add(2 * time.Minute)
+	if !m.Check(tag, email, smtp) {
+		t.Error("SMTP connection should be rejected in a new window within the penalty")
+	}
+	if m.Check(tag, email, web) {
+		t.Error("web traffic should not be rejected in a new window within the penalty")
+	}
+
+	// The penalty expires automatically
+	*now = now.Add(9 * time.Minute)
+	if m.Check(tag, email, smtp) {
+		t.Error("SMTP connection should be allowed after the penalty expired")
+	}
+}
+
+func TestBlockScan(t *testing.T) {
+	m, now := newTestManager(t, &Config{Enable: true, MaxDestPorts: 3, Action: ActionBlock, Duration: 5})
+
+	for port := net.Port(1); port <= 3; port++ {
+		if m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.0.1"), port)) {
+			t.Fatalf("port %d should not be rejected", port)
+		}
+	}
+	if !m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.0.1"), 4)) {
+		t.Fatal("fourth port should be rejected")
+	}
+	if !m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.0.1"), 1)) {
+		t.Error("blocked user should be rejected for any destination")
+	}
+	if m.Check(tag, "other|x|1", net.TCPDestination(net.ParseAddress("10.0.0.1"), 1)) {
+		t.Error("other users should not be affected")
+	}
+
+	*now = now.Add(6 * time.Minute)
+	if m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.0.1"), 1)) {
+		t.Error("block should expire")
+	}
+}
+
+func TestThrottleScanWindows(t *testing.T) {
+	m, now := newTestManager(t, &Config{Enable: true, MaxDestIPs: 2, Duration: 5})
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if m.Check(tag, email, net.TCPDestination(net.ParseAddress(ip), 443)) {
+			t.Fatalf("%s should not be rejected", ip)
+		}
+	}
+	if !m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.0.3"), 443)) {
+		t.Fatal("third address should be rejected")
+	}
+
+	// Each window within the penalty gives no new allowance
+	for minute := 1; minute < 5; minute++ {
+		*now = now.Add(time.Minute)
+		if !m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.1.1"), 443)) {
+			t.Errorf("new address allowed %d minutes into the penalty", minute)
+		}
+		if m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.0.1"), 443)) {
+			t.Errorf("known address rejected %d minutes into the penalty", minute)
+		}
+	}
+
+	*now = now.Add(time.Minute)
+	if m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.1.1"), 443)) {
+		t.Error("new address should be allowed after the penalty expired")
+	}
+}
+
+func TestCountResolvedIPs(t *testing.T) {
+	m, _ := newTestManager(t, &Config{Enable: true, MaxDestIPs: 2, Duration: 5})
+	m.SetResolver(func(domain string) ([]net.IP, error) {
+		switch domain {
+		case "a.example.com", "b.example.com", "c.example.com":
+			return []net.IP{net.ParseIP("10.0.0.1")}, nil
+		}
+		return nil, errors.New("not found")
+	})
+
+	// The names of one server are one destination
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		if m.Check(tag, email, net.TCPDestination(net.ParseAddress(domain), 443)) {
+			t.Fatalf("%s should not be rejected", domain)
+		}
+	}
+	if m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.0.1"), 443)) {
+		t.Fatal("the resolved IP should be known")
+	}
+	// A domain that does not resolve is counted by its name
+	if m.Check(tag, email, net.TCPDestination(net.ParseAddress("unknown.example.com"), 443)) {
+		t.Fatal("second destination should not be rejected")
+	}
+	if !m.Check(tag, email, net.TCPDestination(net.ParseAddress("10.0.0.3"), 443)) {
+		t.Fatal("third destination should be rejected")
+	}
+	if m.Check(tag, email, net.TCPDestination(net.ParseAddress("b.example.com"), 443)) {
+		t.Error("a name of a known IP should be allowed when throttled")
+	}
+}
+
+func TestSweepCounters(t *testing.T) {
+	m, now := newTestManager(t, &Config{Enable: true, MaxSMTPConns: 1, Duration: 5})
+	value, _ := m.InboundGuard.Load(tag)
+	guard := value.(*InboundGuard)
+	count := func() (n int) {
+		guard.UserCounter.Range(func(_, _ interface{}) bool { n++; return true })
+		return n
+	}
+
+	smtp := net.TCPDestination(net.ParseAddress("1.2.3.4"), 25)
+	m.Check(tag, email, smtp)
+	m.Check(tag, email, smtp) // Penalized for 5 minutes
+	m.Check(tag, "idle|x|1", smtp)
+
+	// The idle user is swept after its window, the penalized one is kept
+	*now = now.Add(2 * time.Minute)
+	m.Check(tag, "other|y|2", smtp)
+	if _, ok := guard.UserCounter.Load("idle|x|1"); ok || count() != 2 {
+		t.Errorf("unexpected counters after the first sweep: %d", count())
+	}
+	if !m.Check(tag, email, smtp) {
+		t.Error("the penalty was lost by the sweep")
+	}
+
+	*now = now.Add(10 * time.Minute)
+	m.Check(tag, "other|y|2", smtp)
+	if count() != 1 {
+		t.Errorf("expired counters not swept: %d left", count())
+	}
+}
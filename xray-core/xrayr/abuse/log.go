@@ -0,0 +1,60 @@
+package abuse
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+const defaultLogMaxSize = 10 * 1024 // KB
+
+// rollingLog appends JSON lines to a file and keeps one previous generation
+// (path.1) once the file grows over its maximum size.
+type rollingLog struct {
+	access  sync.Mutex
+	path    string
+	maxSize int64
+}
+
+var (
+	logAccess sync.Mutex
+	logs      = make(map[string]*rollingLog)
+)
+
+// getRollingLog returns the shared log for a path, so nodes can write to the same file
+func getRollingLog(path string, maxSizeKB int) *rollingLog {
+	logAccess.Lock()
+	defer logAccess.Unlock()
+	if l, ok := logs[path]; ok {
+		return l
+	}
+	if maxSizeKB <= 0 {
+		maxSizeKB = defaultLogMaxSize
+	}
+	l := &rollingLog{path: path, maxSize: int64(maxSizeKB) * 1024}
+	logs[path] = l
+	return l
+}
+
+func (l *rollingLog) Write(event *Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.access.Lock()
+	defer l.access.Unlock()
+	if info, err := os.Stat(l.path); err == nil && info.Size()+int64(len(line)) > l.maxSize {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(line)
+	return err
+}
@@ -0,0 +1,29 @@
+package abuse
+
+type Config struct {
+	Enable       bool     `mapstructure:"Enable"`
+	MaxDestIPs   int      `mapstructure:"MaxDestIPs"`   // distinct destination IPs per minute, domains count by their IP, 0 means no limit
+	MaxDestPorts int      `mapstructure:"MaxDestPorts"` // distinct destination ports per minute, 0 means no limit
+	MaxSMTPConns int      `mapstructure:"MaxSMTPConns"` // connections to SMTP ports per minute, 0 means no limit
+	SMTPPorts    []uint16 `mapstructure:"SMTPPorts"`
+	Action       string   `mapstructure:"Action"`   // throttle or block
+	Duration     int      `mapstructure:"Duration"` // minute
+	RuleID       int      `mapstructure:"RuleID"`   // detect rule ID reported to the panel, 0 means log only
+	LogPath      string   `mapstructure:"LogPath"`
+	LogMaxSize   int      `mapstructure:"LogMaxSize"` // KB
+}
+
+// Event records a user that triggered the abuse guard
+type Event struct {
+	Time      string `json:"time"`
+	Tag       string `json:"tag"`
+	Email     string `json:"email"`
+	UID       int    `json:"uid"`
+	Reason    string `json:"reason"`
+	DestIPs   int    `json:"dest_ips"`
+	DestPorts int    `json:"dest_ports"`
+	SMTPConns int    `json:"smtp_conns"`
+	LastDest  string `json:"last_dest"`
+	Action    string `json:"action"`
+	Until     string `json:"until"`
+}
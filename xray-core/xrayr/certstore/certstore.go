@@ -0,0 +1,74 @@
+// Package certstore shares the key pairs the TLS inbounds load from files, so a pair
+// replaced on disk is served to the next handshakes without rebuilding the inbounds.
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pair is the key pair served for a certificate, swapped atomically on reload
+type Pair = atomic.Pointer[tls.Certificate]
+
+var pairs sync.Map // Key: certificate and key paths, Value: *Pair
+
+func pairKey(certFile, keyFile string) string {
+	return certFile + "\x00" + keyFile
+}
+
+// Register returns the pair of the files, shared by the inbounds using them, and makes it
+// serve keyPair
+func Register(certFile, keyFile string, keyPair *tls.Certificate) *Pair {
+	v, _ := pairs.LoadOrStore(pairKey(certFile, keyFile), new(Pair))
+	pair := v.(*Pair)
+	pair.Store(keyPair)
+	return pair
+}
+
+// Parse returns the key pair of the PEM data, with an error when the certificate does not
+// match the key or is not valid now
+func Parse(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if keyPair.Leaf == nil {
+		if keyPair.Leaf, err = x509.ParseCertificate(keyPair.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	if now.After(keyPair.Leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired at %s", keyPair.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(keyPair.Leaf.NotBefore) {
+		return nil, fmt.Errorf("certificate not valid before %s", keyPair.Leaf.NotBefore.Format(time.RFC3339))
+	}
+	return &keyPair, nil
+}
+
+// Reload reads the files again and serves the new pair to the inbounds using them.
+// The pair in use is kept when the new one is invalid.
+func Reload(certFile, keyFile string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPair, err := Parse(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := pairs.Load(pairKey(certFile, keyFile)); ok {
+		v.(*Pair).Store(keyPair)
+	}
+	return keyPair.Leaf, nil
+}
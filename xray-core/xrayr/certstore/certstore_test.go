@@ -0,0 +1,93 @@
+package certstore_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/xrayr/certstore"
+)
+
+func newPair(t *testing.T, name string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key")
+	write := func(certPEM, keyPEM []byte) {
+		if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	validUntil := time.Now().Add(30 * 24 * time.Hour)
+
+	oldCert, oldKey := newPair(t, "old.test.com", validUntil)
+	keyPair, err := tls.X509KeyPair(oldCert, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := certstore.Register(certFile, keyFile, &keyPair)
+	if certstore.Register(certFile, keyFile, &keyPair) != pair {
+		t.Fatal("the inbounds using the same files do not share the pair")
+	}
+
+	newCert, newKey := newPair(t, "new.test.com", validUntil)
+	write(newCert, newKey)
+	leaf, err := certstore.Reload(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.DNSNames[0] != "new.test.com" || pair.Load().Leaf.DNSNames[0] != "new.test.com" {
+		t.Fatalf("the new pair is not served: %v", pair.Load().Leaf.DNSNames)
+	}
+
+	// A key of another certificate, an expired certificate and missing files are rejected
+	otherCert, _ := newPair(t, "other.test.com", validUntil)
+	expiredCert, expiredKey := newPair(t, "expired.test.com", time.Now().Add(-time.Hour))
+	for name, files := range map[string][2][]byte{
+		"mismatched key": {otherCert, newKey},
+		"expired":        {expiredCert, expiredKey},
+		"empty":          {nil, nil},
+	} {
+		write(files[0], files[1])
+		if _, err := certstore.Reload(certFile, keyFile); err == nil {
+			t.Errorf("%s pair accepted", name)
+		}
+	}
+	if _, err := certstore.Reload(filepath.Join(dir, "missing.crt"), keyFile); err == nil {
+		t.Error("missing certificate accepted")
+	}
+	if pair.Load().Leaf.DNSNames[0] != "new.test.com" {
+		t.Errorf("the pair in use was replaced by %v", pair.Load().Leaf.DNSNames)
+	}
+}
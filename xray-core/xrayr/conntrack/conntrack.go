@@ -0,0 +1,207 @@
+// Package conntrack is to follow the connections of users from the dispatcher
+// until they are closed, and report them once they are done
+package conntrack
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+// Reasons a connection was closed for
+const (
+	ReasonClosed      = "closed"
+	ReasonKicked      = "kicked"
+	ReasonDeviceLimit = "device limit"
+	ReasonRule        = "rejected by rule"
+	ReasonAbuseGuard  = "rejected by abuse guard"
+	ReasonNoOutbound  = "no outbound"
+)
+
+type connKey struct{}
+
+// Record is a finished connection of a user
+type Record struct {
+	Tag         string
+	Email       string
+	Source      string
+	Destination string // Destination requested by the client
+	Domain      string // Sniffed domain of the destination
+	Network     string
+	Transport   string // Transport of the inbound, like tcp, ws or grpc
+	Protocol    string // Sniffed protocol
+	Outbound    string
+	Start       time.Time
+	End         time.Time
+	Upload      int64
+	Download    int64
+	Reason      string
+}
+
+// Counter counts the bytes of one direction of a connection, it implements stats.Counter
+type Counter struct {
+	value atomic.Int64
+}
+
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+func (c *Counter) Set(n int64) int64 {
+	return c.value.Swap(n)
+}
+
+func (c *Counter) Add(n int64) int64 {
+	return c.value.Add(n) - n
+}
+
+// Conn is a tracked connection, its methods are safe to call on nil
+type Conn struct {
+	Uplink   Counter
+	Downlink Counter
+	start    time.Time
+	reason   atomic.Pointer[string]
+}
+
+// SetReason records why the connection is closed, the first reason wins
+func (c *Conn) SetReason(reason string) {
+	if c == nil {
+		return
+	}
+	c.reason.CompareAndSwap(nil, &reason)
+}
+
+// FromContext returns the tracked connection of ctx, nil if it is not tracked
+func FromContext(ctx context.Context) *Conn {
+	conn, _ := ctx.Value(connKey{}).(*Conn)
+	return conn
+}
+
+// Exporter receives the records of finished connections, it must not block
+type Exporter interface {
+	Export(record *Record)
+}
+
+type Manager struct {
+	accessLog  atomic.Bool
+	access     sync.RWMutex
+	exporter   Exporter
+	transports sync.Map // Key: inbound tag, Value: transport of the inbound
+}
+
+func New() *Manager {
+	return &Manager{}
+}
+
+// SetAccessLog sets whether finished connections are written to the access log
+func (m *Manager) SetAccessLog(enable bool) {
+	m.accessLog.Store(enable)
+}
+
+// SetExporter sets the exporter of the finished connections, nil to remove it
+func (m *Manager) SetExporter(exporter Exporter) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	m.exporter = exporter
+}
+
+// SetTransport sets the transport recorded for the connections of the inbound tag, empty to remove it
+func (m *Manager) SetTransport(tag string, transport string) {
+	if transport == "" {
+		m.transports.Delete(tag)
+		return
+	}
+	m.transports.Store(tag, transport)
+}
+
+func (m *Manager) getExporter() Exporter {
+	m.access.RLock()
+	defer m.access.RUnlock()
+	return m.exporter
+}
+
+// Enabled returns whether connections need to be tracked
+func (m *Manager) Enabled() bool {
+	return m.accessLog.Load() || m.getExporter() != nil
+}
+
+// Track follows the connection of the user in ctx until ctx is done, the
+// returned context carries the connection. Connections without a user are not tracked.
+func (m *Manager) Track(ctx context.Context) context.Context {
+	if !m.Enabled() || FromContext(ctx) != nil {
+		return ctx
+	}
+	inbound := session.InboundFromContext(ctx)
+	if inbound == nil || inbound.User == nil || inbound.User.Email == "" {
+		return ctx
+	}
+	conn := &Conn{start: time.Now()}
+	ctx = context.WithValue(ctx, connKey{}, conn)
+	context.AfterFunc(ctx, func() {
+		m.finish(m.newRecord(ctx, conn))
+	})
+	return ctx
+}
+
+func (m *Manager) newRecord(ctx context.Context, conn *Conn) *Record {
+	inbound := session.InboundFromContext(ctx)
+	record := &Record{
+		Tag:      inbound.Tag,
+		Email:    inbound.User.Email,
+		Source:   inbound.Source.String(),
+		Start:    conn.start,
+		End:      time.Now(),
+		Upload:   conn.Uplink.Value(),
+		Download: conn.Downlink.Value(),
+		Reason:   ReasonClosed,
+	}
+	if reason := conn.reason.Load(); reason != nil {
+		record.Reason = *reason
+	}
+	if transport, ok := m.transports.Load(inbound.Tag); ok {
+		record.Transport = transport.(string)
+	}
+	if outbounds := session.OutboundsFromContext(ctx); len(outbounds) > 0 {
+		ob := outbounds[len(outbounds)-1]
+		record.Destination = ob.OriginalTarget.String()
+		record.Network = ob.OriginalTarget.Network.SystemString()
+		record.Outbound = ob.Tag
+		// The sniffed domain is either routed only, or overrides the target
+		for _, target := range []net.Destination{ob.RouteTarget, ob.Target, ob.OriginalTarget} {
+			if target.IsValid() && target.Address.Family().IsDomain() {
+				record.Domain = target.Address.Domain()
+				break
+			}
+		}
+	}
+	if content := session.ContentFromContext(ctx); content != nil {
+		record.Protocol = content.Protocol
+	}
+	return record
+}
+
+func (m *Manager) finish(record *Record) {
+	if exporter := m.getExporter(); exporter != nil {
+		exporter.Export(record)
+	}
+	if m.accessLog.Load() {
+		log.Record(&log.ConnectionMessage{
+			From:     record.Source,
+			To:       record.Destination,
+			Domain:   record.Domain,
+			Protocol: record.Protocol,
+			Tag:      record.Tag,
+			Detour:   record.Outbound,
+			Email:    record.Email,
+			Upload:   record.Upload,
+			Download: record.Download,
+			Duration: record.End.Sub(record.Start),
+			Reason:   record.Reason,
+		})
+	}
+}
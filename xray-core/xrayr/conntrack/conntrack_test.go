@@ -0,0 +1,101 @@
+package conntrack_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/xrayr/conntrack"
+)
+
+type handler chan log.Message
+
+func (h handler) Handle(msg log.Message) {
+	h <- msg
+}
+
+func TestTrack(t *testing.T) {
+	messages := make(handler, 10)
+	log.RegisterHandler(messages)
+
+	m := conntrack.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = session.ContextWithInbound(ctx, &session.Inbound{
+		Tag:    "vless_0.0.0.0_443",
+		Source: net.TCPDestination(net.ParseAddress("1.2.3.4"), 5678),
+		User:   &protocol.MemoryUser{Email: "vless_0.0.0.0_443|a@test.com|1"},
+	})
+	if m.Track(ctx) != ctx {
+		t.Fatal("connection tracked with access log disabled")
+	}
+
+	m.SetAccessLog(true)
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{
+		OriginalTarget: net.TCPDestination(net.ParseAddress("5.6.7.8"), 443),
+		RouteTarget:    net.TCPDestination(net.ParseAddress("example.com"), 443),
+		Tag:            "direct",
+	}})
+	ctx = m.Track(ctx)
+	conn := conntrack.FromContext(ctx)
+	if conn == nil {
+		t.Fatal("connection not tracked")
+	}
+	conn.Uplink.Add(100)
+	conn.Downlink.Add(2000)
+	conn.SetReason(conntrack.ReasonKicked)
+	conn.SetReason(conntrack.ReasonRule)
+	cancel()
+
+	select {
+	case msg := <-messages:
+		c, ok := msg.(*log.ConnectionMessage)
+		if !ok {
+			t.Fatalf("unexpected message: %v", msg)
+		}
+		if c.From != "tcp:1.2.3.4:5678" || c.To != "tcp:5.6.7.8:443" || c.Domain != "example.com" || c.Detour != "direct" ||
+			c.Upload != 100 || c.Download != 2000 || c.Reason != conntrack.ReasonKicked {
+			t.Errorf("unexpected connection: %+v", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection not logged")
+	}
+}
+
+type exporter chan *conntrack.Record
+
+func (e exporter) Export(record *conntrack.Record) {
+	e <- record
+}
+
+func TestExporter(t *testing.T) {
+	records := make(exporter, 1)
+	m := conntrack.New()
+	m.SetExporter(records)
+	m.SetTransport("trojan_0.0.0.0_443", "grpc")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = session.ContextWithInbound(ctx, &session.Inbound{
+		Tag:    "trojan_0.0.0.0_443",
+		Source: net.TCPDestination(net.ParseAddress("1.2.3.4"), 5678),
+		User:   &protocol.MemoryUser{Email: "trojan_0.0.0.0_443|a@test.com|1"},
+	})
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{
+		OriginalTarget: net.UDPDestination(net.ParseAddress("8.8.8.8"), 53),
+	}})
+	ctx = session.ContextWithContent(ctx, &session.Content{Protocol: "dns"})
+	ctx = m.Track(ctx)
+	cancel()
+
+	select {
+	case r := <-records:
+		if r.Tag != "trojan_0.0.0.0_443" || r.Network != "udp" || r.Transport != "grpc" || r.Protocol != "dns" || r.Destination != "udp:8.8.8.8:53" || r.Reason != conntrack.ReasonClosed {
+			t.Errorf("unexpected record: %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection not exported")
+	}
+}
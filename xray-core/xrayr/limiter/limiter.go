@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eko/gocache/lib/v4/cache"
@@ -16,7 +17,7 @@ import (
 	redisStore "github.com/eko/gocache/store/redis/v4"
 	goCache "github.com/patrickmn/go-cache"
 	"github.com/redis/go-redis/v9"
-	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/log"
 	"golang.org/x/time/rate"
 
 	"github.com/xtls/xray-core/xrayr/api"
@@ -34,14 +35,23 @@ type InboundInfo struct {
 	UserInfo       *sync.Map // Key: Email value: UserInfo
 	BucketHub      *sync.Map // key: Email, value: *rate.Limiter
 	UserOnlineIP   *sync.Map // Key: Email, value: {Key: IP, value: UID}
+	Sessions       *sync.Map // Key: *session, value: struct{}
+	Connections    atomic.Int64
+	DeviceRejects  atomic.Int64
 	GlobalLimit    struct {
 		config         *GlobalDeviceLimitConfig
 		globalOnlineIP *marshaler.Marshaler
 	}
 }
 
+// RejectHook is called with the inbound tag, the email and the IP of a connection
+// rejected by the device limits
+type RejectHook func(tag string, email string, ip string)
+
 type Limiter struct {
 	InboundInfo *sync.Map // Key: Tag, Value: *InboundInfo
+	access      sync.RWMutex
+	rejectHook  RejectHook
 }
 
 func New() *Limiter {
@@ -50,12 +60,30 @@ func New() *Limiter {
 	}
 }
 
+// SetRejectHook sets the hook of the rejected connections, nil to remove it
+func (l *Limiter) SetRejectHook(hook RejectHook) {
+	l.access.Lock()
+	defer l.access.Unlock()
+	l.rejectHook = hook
+}
+
+func (l *Limiter) rejected(inboundInfo *InboundInfo, email string, ip string) {
+	inboundInfo.DeviceRejects.Add(1)
+	l.access.RLock()
+	hook := l.rejectHook
+	l.access.RUnlock()
+	if hook != nil {
+		hook(inboundInfo.Tag, email, ip)
+	}
+}
+
 func (l *Limiter) AddInboundLimiter(tag string, nodeSpeedLimit uint64, userList *[]api.UserInfo, globalLimit *GlobalDeviceLimitConfig) error {
 	inboundInfo := &InboundInfo{
 		Tag:            tag,
 		NodeSpeedLimit: nodeSpeedLimit,
 		BucketHub:      new(sync.Map),
 		UserOnlineIP:   new(sync.Map),
+		Sessions:       new(sync.Map),
 	}
 
 	if globalLimit != nil && globalLimit.Enable {
@@ -161,6 +189,15 @@ func (l *Limiter) GetOnlineDevice(tag string) (*[]api.OnlineUser, error) {
 	return &onlineUser, nil
 }
 
+// GetInboundStats returns the connections and device limit rejections counted since the last call
+func (l *Limiter) GetInboundStats(tag string) (connections int64, deviceRejects int64, err error) {
+	if value, ok := l.InboundInfo.Load(tag); ok {
+		inboundInfo := value.(*InboundInfo)
+		return inboundInfo.Connections.Swap(0), inboundInfo.DeviceRejects.Swap(0), nil
+	}
+	return 0, 0, fmt.Errorf("no such inbound in limiter: %s", tag)
+}
+
 func (l *Limiter) GetUserBucket(tag string, email string, ip string) (limiter *rate.Limiter, SpeedLimit bool, Reject bool) {
 	if value, ok := l.InboundInfo.Load(tag); ok {
 		var (
@@ -169,6 +206,7 @@ func (l *Limiter) GetUserBucket(tag string, email string, ip string) (limiter *r
 		)
 
 		inboundInfo := value.(*InboundInfo)
+		inboundInfo.Connections.Add(1)
 		nodeLimit := inboundInfo.NodeSpeedLimit
 
 		if v, ok := inboundInfo.UserInfo.Load(email); ok {
@@ -193,6 +231,7 @@ func (l *Limiter) GetUserBucket(tag string, email string, ip string) (limiter *r
 				})
 				if counter > deviceLimit && deviceLimit > 0 {
 					ipMap.Delete(ip)
+					l.rejected(inboundInfo, email, ip)
 					return nil, false, true
 				}
 			}
@@ -201,6 +240,7 @@ func (l *Limiter) GetUserBucket(tag string, email string, ip string) (limiter *r
 		// GlobalLimit
 		if inboundInfo.GlobalLimit.config != nil && inboundInfo.GlobalLimit.config.Enable {
 			if reject := globalLimit(inboundInfo, email, uid, ip, deviceLimit); reject {
+				l.rejected(inboundInfo, email, ip)
 				return nil, false, true
 			}
 		}
@@ -219,7 +259,7 @@ func (l *Limiter) GetUserBucket(tag string, email string, ip string) (limiter *r
 			return nil, false, false
 		}
 	} else {
-		errors.LogDebug(context.Background(), "Get Inbound Limiter information failed")
+		log.RecordFields(log.Severity_Debug, "Get inbound limiter information failed", log.Fields{"node_tag": tag})
 		return nil, false, false
 	}
 }
@@ -239,7 +279,7 @@ func globalLimit(inboundInfo *InboundInfo, email string, uid int, ip string, dev
 			// If the email is a new device
 			go pushIP(inboundInfo, uniqueKey, &map[string]int{ip: uid})
 		} else {
-			errors.LogErrorInner(context.Background(), err, "cache service")
+			log.RecordFields(log.Severity_Error, "Global limit cache service failed", log.Fields{"email": email, "error": err})
 		}
 		return false
 	}
@@ -265,7 +305,7 @@ func pushIP(inboundInfo *InboundInfo, uniqueKey string, ipMap *map[string]int) {
 	defer cancel()
 
 	if err := inboundInfo.GlobalLimit.globalOnlineIP.Set(ctx, uniqueKey, ipMap); err != nil {
-		errors.LogErrorInner(context.Background(), err, "cache service")
+		log.RecordFields(log.Severity_Error, "Global limit cache service failed", log.Fields{"key": uniqueKey, "error": err})
 	}
 }
 
@@ -0,0 +1,38 @@
+package limiter_test
+
+import (
+	"testing"
+
+	"github.com/xtls/xray-core/xrayr/api"
+	"github.com/xtls/xray-core/xrayr/limiter"
+)
+
+func TestRejectHook(t *testing.T) {
+	const tag = "vless_0.0.0.0_443"
+	const email = tag + "|a@test.com|1"
+
+	l := limiter.New()
+	if err := l.AddInboundLimiter(tag, 0, &[]api.UserInfo{{UID: 1, Email: "a@test.com", DeviceLimit: 1}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var rejected []string
+	l.SetRejectHook(func(hookTag, hookEmail, ip string) {
+		if hookTag != tag || hookEmail != email {
+			t.Errorf("unexpected rejection of %s on %s", hookEmail, hookTag)
+		}
+		rejected = append(rejected, ip)
+	})
+
+	if _, _, reject := l.GetUserBucket(tag, email, "1.1.1.1"); reject {
+		t.Fatal("first device rejected")
+	}
+	if _, _, reject := l.GetUserBucket(tag, email, "2.2.2.2"); !reject {
+		t.Fatal("device over the limit accepted")
+	}
+	if len(rejected) != 1 || rejected[0] != "2.2.2.2" {
+		t.Errorf("got rejections %v", rejected)
+	}
+	if _, rejects, _ := l.GetInboundStats(tag); rejects != 1 {
+		t.Errorf("got %d device rejects, want 1", rejects)
+	}
+}
@@ -0,0 +1,82 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type session struct {
+	email string
+	close func()
+}
+
+// AddSession registers a live connection of the user so it can be kicked later.
+// The session is forgotten once ctx is done.
+func (l *Limiter) AddSession(ctx context.Context, tag string, email string, close func()) {
+	value, ok := l.InboundInfo.Load(tag)
+	if !ok {
+		return
+	}
+	inboundInfo := value.(*InboundInfo)
+	s := &session{email: email, close: close}
+	inboundInfo.Sessions.Store(s, struct{}{})
+	context.AfterFunc(ctx, func() {
+		inboundInfo.Sessions.Delete(s)
+	})
+}
+
+// KickUser closes every live connection of the user and forgets its online IPs,
+// it returns the number of connections closed
+func (l *Limiter) KickUser(tag string, email string) (int, error) {
+	value, ok := l.InboundInfo.Load(tag)
+	if !ok {
+		return 0, fmt.Errorf("no such inbound in limiter: %s", tag)
+	}
+	inboundInfo := value.(*InboundInfo)
+	kicked := 0
+	inboundInfo.Sessions.Range(func(key, _ interface{}) bool {
+		s := key.(*session)
+		if s.email == email {
+			inboundInfo.Sessions.Delete(s)
+			s.close()
+			kicked++
+		}
+		return true
+	})
+	inboundInfo.UserOnlineIP.Delete(email)
+	return kicked, nil
+}
+
+// GetOnlineIPs returns the online IPs of each user without resetting them
+func (l *Limiter) GetOnlineIPs(tag string) (map[string][]string, error) {
+	value, ok := l.InboundInfo.Load(tag)
+	if !ok {
+		return nil, fmt.Errorf("no such inbound in limiter: %s", tag)
+	}
+	inboundInfo := value.(*InboundInfo)
+	onlineIPs := make(map[string][]string)
+	inboundInfo.UserOnlineIP.Range(func(key, value interface{}) bool {
+		email := key.(string)
+		value.(*sync.Map).Range(func(key, _ interface{}) bool {
+			onlineIPs[email] = append(onlineIPs[email], key.(string))
+			return true
+		})
+		return true
+	})
+	return onlineIPs, nil
+}
+
+// GetSessionCount returns the number of live connections of each user
+func (l *Limiter) GetSessionCount(tag string) (map[string]int, error) {
+	value, ok := l.InboundInfo.Load(tag)
+	if !ok {
+		return nil, fmt.Errorf("no such inbound in limiter: %s", tag)
+	}
+	count := make(map[string]int)
+	value.(*InboundInfo).Sessions.Range(func(key, _ interface{}) bool {
+		count[key.(*session).email]++
+		return true
+	})
+	return count, nil
+}
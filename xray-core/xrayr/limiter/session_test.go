@@ -0,0 +1,52 @@
+package limiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/xrayr/api"
+	"github.com/xtls/xray-core/xrayr/limiter"
+)
+
+func TestKickUser(t *testing.T) {
+	const tag = "vless_0.0.0.0_443"
+	const email = tag + "|a@test.com|1"
+
+	l := limiter.New()
+	if err := l.AddInboundLimiter(tag, 0, &[]api.UserInfo{{UID: 1, Email: "a@test.com"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	l.GetUserBucket(tag, email, "1.1.1.1")
+
+	closed := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	l.AddSession(ctx, tag, email, func() { closed++ })
+	l.AddSession(context.Background(), tag, email, func() { closed++ })
+	l.AddSession(context.Background(), tag, tag+"|b@test.com|2", func() { closed++ })
+
+	// Finished sessions are forgotten
+	cancel()
+	count, _ := l.GetSessionCount(tag)
+	for i := 0; i < 100 && count[email] != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+		count, _ = l.GetSessionCount(tag)
+	}
+	if count[email] != 1 {
+		t.Fatalf("finished session still tracked: %v", count)
+	}
+
+	kicked, err := l.KickUser(tag, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kicked != 1 || closed != 1 {
+		t.Errorf("kicked %d sessions, closed %d, want 1", kicked, closed)
+	}
+	if ips, _ := l.GetOnlineIPs(tag); len(ips[email]) != 0 {
+		t.Errorf("online IPs not cleared: %v", ips)
+	}
+	if count, _ := l.GetSessionCount(tag); count[tag+"|b@test.com|2"] != 1 {
+		t.Errorf("other user kicked: %v", count)
+	}
+}
@@ -2,15 +2,14 @@
 package rule
 
 import (
-	"context"
-	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	mapset "github.com/deckarep/golang-set"
-	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/log"
 
 	"github.com/xtls/xray-core/xrayr/api"
 )
@@ -18,12 +17,14 @@ import (
 type Manager struct {
 	InboundRule         *sync.Map // Key: Tag, Value: []api.DetectRule
 	InboundDetectResult *sync.Map // key: Tag, Value: mapset.NewSet []api.DetectResult
+	InboundRejects      *sync.Map // key: Tag, Value: *atomic.Int64
 }
 
 func New() *Manager {
 	return &Manager{
 		InboundRule:         new(sync.Map),
 		InboundDetectResult: new(sync.Map),
+		InboundRejects:      new(sync.Map),
 	}
 }
 
@@ -49,6 +50,14 @@ func (r *Manager) GetDetectResult(tag string) (*[]api.DetectResult, error) {
 	return &detectResult, nil
 }
 
+// GetRejectCount returns the connections rejected by rules since the last call
+func (r *Manager) GetRejectCount(tag string) int64 {
+	if v, ok := r.InboundRejects.Load(tag); ok {
+		return v.(*atomic.Int64).Swap(0)
+	}
+	return 0
+}
+
 func (r *Manager) Detect(tag string, destination string, email string) (reject bool) {
 	reject = false
 	var hitRuleID = -1
@@ -62,12 +71,16 @@ func (r *Manager) Detect(tag string, destination string, email string) (reject b
 				break
 			}
 		}
+		if reject {
+			v, _ := r.InboundRejects.LoadOrStore(tag, new(atomic.Int64))
+			v.(*atomic.Int64).Add(1)
+		}
 		// If we hit some rule
 		if reject && hitRuleID != -1 {
 			l := strings.Split(email, "|")
 			uid, err := strconv.Atoi(l[len(l)-1])
 			if err != nil {
-				errors.LogDebug(context.Background(), fmt.Sprintf("Record illegal behavior failed! Cannot find user's uid: %s", email))
+				log.RecordFields(log.Severity_Debug, "Record illegal behavior failed, cannot find the uid of the user", log.Fields{"email": email})
 				return reject
 			}
 			newSet := mapset.NewSetWith(api.DetectResult{UID: uid, RuleID: hitRuleID})